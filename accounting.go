@@ -1,11 +1,15 @@
 package accounting
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"net/url"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,10 +20,291 @@ var (
 	defaultSchemes = []string{"ledger", "txtdb", "postgres"}
 )
 
+// Option configures a Ledger before it is read and filled by Open.
+type Option func(*Ledger)
+
+// WithRoundingTolerance makes Fill absorb any residual not bigger than tolerance
+// (an amount times U) when balancing a transaction, instead of failing.
+func WithRoundingTolerance(tolerance int64) Option {
+	return func(l *Ledger) {
+		l.RoundingTolerance = tolerance
+	}
+}
+
+// WithRoundingAccount makes Fill put rounding adjustments in a new split
+// to the account with that full name, instead of in the largest split.
+func WithRoundingAccount(name string) Option {
+	return func(l *Ledger) {
+		l.RoundingAccount = name
+	}
+}
+
+// WithoutAutomaticPrices makes Fill not add prices from the transactions
+// with two currencies or with a price, so only the explicit ones are used.
+func WithoutAutomaticPrices() Option {
+	return func(l *Ledger) {
+		l.NoAutomaticPrices = true
+	}
+}
+
+// WithBackendOption passes a setting to the backend, which can get it
+// with Backend.Options.  Its meaning depends on the backend.
+func WithBackendOption(name string, value interface{}) Option {
+	return func(l *Ledger) {
+		if l.backendOptions == nil {
+			l.backendOptions = make(map[string][]interface{})
+		}
+		l.backendOptions[name] = append(l.backendOptions[name], value)
+	}
+}
+
+// WithFileNotifier makes Watch use notifiers created by newNotifier to know
+// when the files of the ledger change, instead of polling them.
+func WithFileNotifier(newNotifier func() (FileNotifier, error)) Option {
+	return func(l *Ledger) {
+		l.newNotifier = newNotifier
+	}
+}
+
+// WithKeepOrder makes Fill keep the transactions in the order given
+// by the backend, instead of sorting them, failing if they are not
+// chronologically sorted.
+func WithKeepOrder() Option {
+	return func(l *Ledger) {
+		l.KeepOrder = true
+	}
+}
+
 // Open opens a ledger specified by a URL-like string, where the scheme is the
 // backend name and the rest of the URL is backend-specific (usually consisting
 // on a file name or a database name).
-func Open(dataSource string) (*Ledger, error) {
+func Open(dataSource string, options ...Option) (*Ledger, error) {
+	b, err := open(dataSource, false, options)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.Ledger.Fill(); err != nil {
+		return nil, err
+	}
+	return b.Ledger, nil
+}
+
+// OpenAll reads several data sources, which may use different backends,
+// and combines them in a single ledger:
+// accounts with the same full name are merged (keeping the code, alias,
+// type and default currency of the first source that has them), and so are
+// currencies with the same name; transactions and prices are merged and
+// sorted by time.
+//
+// The sources are closed after reading them: the combined ledger is kept
+// in memory, and Refresh does nothing.
+func OpenAll(sources ...string) (*Ledger, error) {
+	l := new(Ledger)
+	l.Comments = make(map[interface{}][]string)
+	l.FileComments = make(map[interface{}][]string)
+	l.Assertions = make(map[*Split]Value)
+	l.SplitPrices = make(map[*Split]Value)
+	l.LotPrices = make(map[*Split]Value)
+	l.Elided = make(map[*Split]bool)
+	for _, source := range sources {
+		b, err := open(source, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		l.merge(b.Ledger)
+		b.Ledger.Close()
+	}
+	sort.SliceStable(l.Transactions, func(i, j int) bool {
+		return l.Transactions[i].Time.Before(l.Transactions[j].Time)
+	})
+	if err := l.Fill(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// merge adds all the data in src to l, as explained in OpenAll.
+// The transactions, splits and prices of src are moved to l, so src cannot
+// be used afterwards.  src may have been filled already: its splits in
+// TransferAccount are moved as they are, and replaced when l is filled.
+func (l *Ledger) merge(src *Ledger) {
+	currencies := make(map[*Currency]*Currency)
+	currency := func(c *Currency) *Currency {
+		if c == nil {
+			return nil
+		}
+		if nc, ok := currencies[c]; ok {
+			return nc
+		}
+		nc, isNew := l.GetCurrency(c.Name)
+		if isNew {
+			*nc = *c
+		}
+		currencies[c] = nc
+		return nc
+	}
+	value := func(v Value) Value {
+		v.Currency = currency(v.Currency)
+		return v
+	}
+	accounts := make(map[*Account]*Account)
+	var account func(a *Account) *Account
+	account = func(a *Account) *Account {
+		if a == nil || a.IsTransferAccount() {
+			return a
+		}
+		if na, ok := accounts[a]; ok {
+			return na
+		}
+		parent := account(a.Parent)
+		var na *Account
+		for _, b := range l.Accounts {
+			if b.Parent == parent && b.Name == a.Name {
+				na = b
+				break
+			}
+		}
+		if na == nil {
+			na = &Account{ID: a.ID, Parent: parent, Name: a.Name}
+			if parent != nil {
+				na.Level = parent.Level + 1
+				parent.Children = append(parent.Children, na)
+			}
+			l.Accounts = append(l.Accounts, na)
+		}
+		if na.Code == "" {
+			na.Code = a.Code
+		}
+		if na.Alias == "" {
+			na.Alias = a.Alias
+		}
+		if na.Type == Untyped {
+			na.Type = a.Type
+		}
+		if na.DefaultCurrency == nil {
+			na.DefaultCurrency = currency(a.DefaultCurrency)
+		}
+		for _, v := range a.StartBalance {
+			na.StartBalance.Add(value(v))
+		}
+		accounts[a] = na
+		return na
+	}
+
+	for _, c := range src.Currencies {
+		currency(c)
+	}
+	for _, a := range src.Accounts {
+		account(a)
+	}
+	if l.DefaultCurrency == nil {
+		l.DefaultCurrency = currency(src.DefaultCurrency)
+	}
+	for _, t := range src.Transactions {
+		for _, s := range t.Splits {
+			s.Account = account(s.Account)
+			s.Value = value(s.Value)
+		}
+		l.Transactions = append(l.Transactions, t)
+	}
+	for _, p := range src.Periodic {
+		for _, s := range p.Template.Splits {
+			s.Account = account(s.Account)
+			s.Value = value(s.Value)
+		}
+		l.Periodic = append(l.Periodic, p)
+	}
+	for _, p := range src.Prices {
+		p.Currency = currency(p.Currency)
+		p.Value = value(p.Value)
+	}
+	l.AddPrices(src.Prices)
+	for s, v := range src.Assertions {
+		l.Assertions[s] = value(v)
+	}
+	for s, v := range src.SplitPrices {
+		l.SplitPrices[s] = value(v)
+	}
+	for s, v := range src.LotPrices {
+		l.LotPrices[s] = value(v)
+	}
+	for s, e := range src.Elided {
+		l.Elided[s] = e
+	}
+	// comments in merged accounts and currencies are added to
+	// the ones in the first source:
+	key := func(obj interface{}) interface{} {
+		switch x := obj.(type) {
+		case *Account:
+			return account(x)
+		case *Currency:
+			return currency(x)
+		}
+		return obj
+	}
+	for obj, c := range src.Comments {
+		l.Comments[key(obj)] = append(l.Comments[key(obj)], c...)
+	}
+	for obj, c := range src.FileComments {
+		l.FileComments[key(obj)] = append(l.FileComments[key(obj)], c...)
+	}
+}
+
+// Validate reads a ledger like Open, and returns all the problems found in it:
+// errors reported by the backend while reading it, unbalanced transactions and
+// wrong balance assertions.  Transactions with errors are ignored to keep on
+// checking the rest.  The ledger itself is discarded.
+//
+// Wrong balance assertions are reported as an *AssertionError (wrapped in a
+// *TransactionError), with the expected and actual balances.
+func Validate(dataSource string, options ...Option) []error {
+	b, err := open(dataSource, true, options)
+	if err != nil {
+		return []error{err}
+	}
+	defer b.Ledger.Close()
+	errs := b.errors
+	for {
+		err := b.Ledger.Fill()
+		if err == nil {
+			break
+		}
+		errs = append(errs, err)
+		var te *TransactionError
+		if !errors.As(err, &te) || !b.Ledger.removeTransaction(te.Transaction) {
+			break
+		}
+	}
+	return errs
+}
+
+// newAssertionError returns the error for a split whose account should have
+// a balance of expected, but has actual instead.
+func newAssertionError(s *Split, expected, actual Value) *AssertionError {
+	return &AssertionError{
+		ID:         s.ID,
+		Account:    s.Account,
+		Expected:   expected,
+		Actual:     actual,
+		Difference: Value{Amount: expected.Amount - actual.Amount, Currency: expected.Currency},
+	}
+}
+
+// removeTransaction removes a transaction from the ledger,
+// returning whether it was there.
+func (l *Ledger) removeTransaction(t *Transaction) bool {
+	for i := range l.Transactions {
+		if l.Transactions[i] == t {
+			l.Transactions = append(l.Transactions[:i], l.Transactions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// open reads a ledger with its backend, without filling it.
+// If collect is set, the backend errors are kept in the Backend.
+func open(dataSource string, collect bool, options []Option) (*Backend, error) {
 	url, err := url.Parse(dataSource)
 	if err != nil {
 		return nil, fmt.Errorf("accounting.Open: %v", err)
@@ -40,15 +325,16 @@ func Open(dataSource string) (*Ledger, error) {
 	}
 	b := new(Backend)
 	b.ready = true
+	b.collect = collect
 	b.Ledger = new(Ledger)
+	for _, option := range options {
+		option(b.Ledger)
+	}
 	b.Ledger.connection, err = drivers[backend].Open(dataSource, b)
 	if err != nil {
 		return nil, err
 	}
-	if err = b.Ledger.Fill(); err != nil {
-		return nil, err
-	}
-	return b.Ledger, nil
+	return b, nil
 }
 
 // Register makes an accounting backend available by the provided name.
@@ -69,6 +355,26 @@ func Register(name string, driver Driver) {
 // representation of that value, with or without its currency (units flag),
 // using just the defaults digits for the currency, or all the non-zero ones (full flag).
 func (value Value) GetString(full bool, units bool) string {
+	integer, fraction := value.getParts(full, units)
+	return integer + fraction
+}
+
+// StringParts returns the same as String, split in two parts: the integer
+// one (with the currency, if it goes first) and the rest, starting with the
+// decimal separator (if any).  It is used to align amounts on their decimal
+// separators.
+func (value Value) StringParts() (integer, fraction string) {
+	return value.getParts(false, true)
+}
+
+// FullStringParts is like StringParts, but with the parts of FullString.
+func (value Value) FullStringParts() (integer, fraction string) {
+	return value.getParts(true, true)
+}
+
+// getParts returns the representation of a value like GetString,
+// split before the decimal separator.
+func (value Value) getParts(full bool, units bool) (string, string) {
 	var result string
 	var c Currency
 
@@ -81,8 +387,8 @@ func (value Value) GetString(full bool, units bool) string {
 			result += " "
 		}
 	}
-	if value.Amount < 0 {
-		result += "-"
+	negative := value.Amount < 0
+	if negative {
 		value.Amount = -value.Amount
 	}
 	i := value.Amount / U
@@ -90,6 +396,30 @@ func (value Value) GetString(full bool, units bool) string {
 	if c.Decimal == "" { // shouldn't happen
 		c.Decimal = "."
 	}
+	if c.Precision < -18 || c.Precision > 8 {
+		panic(fmt.Sprintf("Money: invalid precision %d", c.Precision))
+	}
+	var suffix string
+	if c.Precision < 0 {
+		// negative precision rounds the integer part (only for display):
+		if !full {
+			scale := int64(1)
+			for n := c.Precision; n < 0; n++ {
+				scale *= 10
+			}
+			r := i % scale
+			i /= scale
+			if 2*r >= scale {
+				i++
+			}
+			d = 0
+			suffix = c.Suffix
+		}
+		c.Precision = 0
+	}
+	if negative && (i != 0 || d != 0) {
+		result += "-"
+	}
 	integer := fmt.Sprintf("%d", i)
 	for n, l := 0, len(integer); n < 1+(l-1)/3; n++ {
 		if n > 0 {
@@ -102,9 +432,7 @@ func (value Value) GetString(full bool, units bool) string {
 		}
 		result += integer[start:end]
 	}
-	if c.Precision < 0 || c.Precision > 8 {
-		panic(fmt.Sprintf("Money: invalid precision %d", c.Precision))
-	}
+	split := len(result)
 	if c.Precision > 0 || (full && d > 0) {
 		result += c.Decimal
 		precision := c.Precision
@@ -119,6 +447,7 @@ func (value Value) GetString(full bool, units bool) string {
 		}
 		result += digits[:precision]
 	}
+	result += suffix
 	if units && !c.PrintBefore {
 		if !c.WithoutSpace && c.Name != "" {
 			result += " "
@@ -126,7 +455,7 @@ func (value Value) GetString(full bool, units bool) string {
 		result += c.Name
 	}
 
-	return result
+	return result[:split], result[split:]
 }
 
 // String returns a string with the correct
@@ -136,6 +465,13 @@ func (value Value) String() string {
 	return value.GetString(false, true)
 }
 
+// AmountString returns the amount of a value as String does,
+// with thousands separator, decimal separator and precision,
+// but without the currency name or symbol.
+func (value Value) AmountString() string {
+	return value.GetString(false, false)
+}
+
 // FullString returns a string with the correct
 // representation of that value, including its currency.
 // The amount is represented with all the relevant digits.
@@ -143,29 +479,96 @@ func (value Value) FullString() string {
 	return value.GetString(true, true)
 }
 
-// String returns "0" for empty balances, or a list of its values separated by commas.
+// String returns "0" for empty balances (including the sum of zero values,
+// whatever their currency), or a list of its values separated by commas.
 func (b Balance) String() string {
+	return b.getString(false)
+}
+
+// FullString is like String, but it shows the values with FullString.
+func (b Balance) FullString() string {
+	return b.getString(true)
+}
+
+func (b Balance) getString(full bool) string {
 	if len(b) == 0 {
 		return "0"
 	}
 	var s string
-	for _, v := range b {
+	for _, v := range b.Sorted() {
 		if s != "" {
 			s += ", "
 		}
-		s += v.String()
+		s += v.GetString(full, true)
 	}
 	return s
 }
 
+// Equal reports whether two balances have the same amount in every currency,
+// regardless of their order.  Zero amounts are the same as absent ones.
+func (b Balance) Equal(b2 Balance) bool {
+	amounts := make(map[*Currency]int64)
+	for _, v := range b {
+		amounts[v.Currency] += v.Amount
+	}
+	for _, v := range b2 {
+		amounts[v.Currency] -= v.Amount
+	}
+	for _, a := range amounts {
+		if a != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Sorted returns a copy of a balance with its values ordered by currency name.
+func (b Balance) Sorted() Balance {
+	res := b.Dup()
+	sort.SliceStable(res, func(i, j int) bool {
+		return currencyName(res[i].Currency) < currencyName(res[j].Currency)
+	})
+	return res
+}
+
+func currencyName(c *Currency) string {
+	if c == nil {
+		return ""
+	}
+	return c.Name
+}
+
 // Close closes the ledger and prevents new queries from starting.
 func (l *Ledger) Close() error {
+	if l.connection == nil {
+		return nil
+	}
 	return l.connection.Close()
 }
 
 // Refresh loads again (if needed) all the accounting data.
 func (l *Ledger) Refresh() {
-	l.connection.Refresh()
+	if l.connection != nil {
+		l.connection.Refresh()
+	}
+}
+
+// Watch reads the accounting data again every time it changes, until ctx
+// is done, calling onChange with a new Ledger holding it after every
+// successful reload.  It blocks, so it is usually run in its own goroutine.
+// l itself is never modified, so it can still be used while Watch runs;
+// it is up to onChange to make the rest of the program use the new ledger.
+// If the backend cannot watch its data, Watch just waits for ctx to be done.
+func (l *Ledger) Watch(ctx context.Context, onChange func(*Ledger)) {
+	w, ok := l.connection.(Watcher)
+	if !ok {
+		<-ctx.Done()
+		return
+	}
+	w.Watch(ctx, func(nl *Ledger, c Connection) {
+		nl.connection = c
+		onChange(nl)
+	})
 }
 
 // Clone returns a deep copy of l.
@@ -175,6 +578,7 @@ func (l *Ledger) Clone() *Ledger {
 	mapSplits := make(map[*Split]*Split)
 	mapCurrencies := make(map[*Currency]*Currency)
 	mapPrices := make(map[*Price]*Price)
+	mapPeriodic := make(map[*PeriodicTransaction]*PeriodicTransaction)
 
 	for _, a := range l.Accounts {
 		mapAccounts[a] = new(Account)
@@ -191,9 +595,17 @@ func (l *Ledger) Clone() *Ledger {
 	for _, p := range l.Prices {
 		mapPrices[p] = new(Price)
 	}
+	for _, p := range l.Periodic {
+		mapPeriodic[p] = new(PeriodicTransaction)
+		mapTransactions[&p.Template] = &mapPeriodic[p].Template
+		for _, s := range p.Template.Splits {
+			mapSplits[s] = new(Split)
+		}
+	}
 
 	res := new(Ledger)
 	res.connection = l.connection
+	res.lastID = l.lastID
 	res.Accounts = make([]*Account, len(l.Accounts))
 	for i, a := range l.Accounts {
 		na := mapAccounts[a]
@@ -207,6 +619,9 @@ func (l *Ledger) Clone() *Ledger {
 		na.Level = a.Level
 		na.Name = a.Name
 		na.Code = a.Code
+		na.Alias = a.Alias
+		na.Type = a.Type
+		na.DefaultCurrency = mapCurrencies[a.DefaultCurrency]
 		na.Splits = make([]*Split, len(a.Splits))
 		for i := range a.Splits {
 			na.Splits[i] = mapSplits[a.Splits[i]]
@@ -216,14 +631,21 @@ func (l *Ledger) Clone() *Ledger {
 			na.StartBalance[k].Amount = v.Amount
 			na.StartBalance[k].Currency = mapCurrencies[v.Currency]
 		}
+		if a.StartClearedBalance != nil {
+			na.StartClearedBalance = make([]Value, len(a.StartClearedBalance))
+			for k, v := range a.StartClearedBalance {
+				na.StartClearedBalance[k].Amount = v.Amount
+				na.StartClearedBalance[k].Currency = mapCurrencies[v.Currency]
+			}
+		}
 	}
-	res.Transactions = make([]*Transaction, len(l.Transactions))
-	for i, t := range l.Transactions {
+	copyTransaction := func(t *Transaction) {
 		nt := mapTransactions[t]
-		res.Transactions[i] = nt
 		nt.ID = t.ID
 		nt.Time = t.Time
+		nt.State = t.State
 		nt.Description = t.Description
+		nt.Payee = t.Payee
 		nt.Splits = make([]*Split, len(t.Splits))
 		for j, s := range t.Splits {
 			ns := mapSplits[s]
@@ -240,6 +662,8 @@ func (l *Ledger) Clone() *Ledger {
 				ns.Time = new(time.Time)
 				*ns.Time = *s.Time
 			}
+			ns.State = s.State
+			ns.Virtual = s.Virtual
 			ns.Value.Amount = s.Value.Amount
 			ns.Value.Currency = mapCurrencies[s.Value.Currency]
 			ns.Balance = make([]Value, len(s.Balance))
@@ -247,8 +671,27 @@ func (l *Ledger) Clone() *Ledger {
 				ns.Balance[k].Amount = v.Amount
 				ns.Balance[k].Currency = mapCurrencies[v.Currency]
 			}
+			ns.ClearedBalance = make([]Value, len(s.ClearedBalance))
+			for k, v := range s.ClearedBalance {
+				ns.ClearedBalance[k].Amount = v.Amount
+				ns.ClearedBalance[k].Currency = mapCurrencies[v.Currency]
+			}
 		}
 	}
+	res.Transactions = make([]*Transaction, len(l.Transactions))
+	for i, t := range l.Transactions {
+		res.Transactions[i] = mapTransactions[t]
+		copyTransaction(t)
+	}
+	res.Periodic = make([]*PeriodicTransaction, len(l.Periodic))
+	for i, p := range l.Periodic {
+		np := mapPeriodic[p]
+		res.Periodic[i] = np
+		copyTransaction(&p.Template)
+		np.Months = p.Months
+		np.Days = p.Days
+		np.End = p.End
+	}
 	res.Currencies = make([]*Currency, len(l.Currencies))
 	for i, c := range l.Currencies {
 		nc := mapCurrencies[c]
@@ -261,6 +704,7 @@ func (l *Ledger) Clone() *Ledger {
 		nc.Decimal = c.Decimal
 		nc.Precision = c.Precision
 		nc.ISIN = c.ISIN
+		nc.Suffix = c.Suffix
 	}
 	res.Prices = make([]*Price, len(l.Prices))
 	for i, p := range l.Prices {
@@ -273,7 +717,37 @@ func (l *Ledger) Clone() *Ledger {
 		np.Value.Currency = mapCurrencies[p.Value.Currency]
 	}
 	res.Comments = make(map[interface{}][]string)
-	// TODO: Comments are not deep-copied (I have to deal with interface{})
+	for k, c := range l.Comments {
+		switch x := k.(type) {
+		case *Account:
+			k = mapAccounts[x]
+		case *Transaction:
+			k = mapTransactions[x]
+		case *Split:
+			k = mapSplits[x]
+		case *Currency:
+			k = mapCurrencies[x]
+		case *Price:
+			k = mapPrices[x]
+		}
+		res.Comments[k] = append([]string(nil), c...)
+	}
+	res.FileComments = make(map[interface{}][]string)
+	for k, c := range l.FileComments {
+		switch x := k.(type) {
+		case *Account:
+			k = mapAccounts[x]
+		case *Transaction:
+			k = mapTransactions[x]
+		case *Currency:
+			k = mapCurrencies[x]
+		case *Price:
+			k = mapPrices[x]
+		case *PeriodicTransaction:
+			k = mapPeriodic[x]
+		}
+		res.FileComments[k] = append([]string(nil), c...)
+	}
 	res.Assertions = make(map[*Split]Value)
 	for s, v := range l.Assertions {
 		v.Currency = mapCurrencies[v.Currency]
@@ -284,11 +758,110 @@ func (l *Ledger) Clone() *Ledger {
 		v.Currency = mapCurrencies[v.Currency]
 		res.SplitPrices[mapSplits[s]] = v
 	}
+	res.LotPrices = make(map[*Split]Value)
+	for s, v := range l.LotPrices {
+		v.Currency = mapCurrencies[v.Currency]
+		res.LotPrices[mapSplits[s]] = v
+	}
+	res.Elided = make(map[*Split]bool)
+	for s := range l.Elided {
+		res.Elided[mapSplits[s]] = true
+	}
 	res.DefaultCurrency = mapCurrencies[l.DefaultCurrency]
+	res.RoundingTolerance = l.RoundingTolerance
+	res.RoundingAccount = l.RoundingAccount
+	res.NoAutomaticPrices = l.NoAutomaticPrices
+	res.KeepOrder = l.KeepOrder
 
 	return res
 }
 
+// Occurrences returns the times of the transactions generated by p
+// between begin and end, both included.
+// With a period in months, days past the end of a month are moved to its
+// last day: a monthly template on January 31 happens on February 28.
+func (p *PeriodicTransaction) Occurrences(begin, end time.Time) []time.Time {
+	if p.Months < 0 || p.Days < 0 || (p.Months == 0 && p.Days == 0) {
+		return nil
+	}
+	if !p.End.IsZero() && p.End.Before(end) {
+		end = p.End
+	}
+	// skip the periods before begin, as no period is longer than
+	// Months*31+Days days:
+	k := 0
+	if start := p.Template.Time; begin.After(start) {
+		k = int(begin.Sub(start).Hours()/24)/(p.Months*31+p.Days) - 1
+		if k < 0 {
+			k = 0
+		}
+	}
+	var times []time.Time
+	for ; ; k++ {
+		t := addMonths(p.Template.Time, k*p.Months).AddDate(0, 0, k*p.Days)
+		if t.After(end) {
+			break
+		}
+		if !t.Before(begin) {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// addMonths is like t.AddDate(0, months, 0), but keeping the result
+// in its month, with the last day of it if t is after that day.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	if last := time.Date(year, month+time.Month(months)+1, 0, 0, 0, 0, 0, t.Location()).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month+time.Month(months), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// Forecast returns a copy of l with the transactions generated by its
+// periodic transactions between from and to (both included) added and
+// filled, to get the balances projected until to.
+// The generated transactions have a nil ID and a "forecast" comment.
+func (l *Ledger) Forecast(from, to time.Time) (*Ledger, error) {
+	res := l.Clone()
+	for _, p := range res.Periodic {
+		for _, when := range p.Occurrences(from, to) {
+			t := &Transaction{
+				Time:        when,
+				State:       p.Template.State,
+				Description: p.Template.Description,
+				Payee:       p.Template.Payee,
+			}
+			for _, s := range p.Template.Splits {
+				ns := &Split{Account: s.Account, Transaction: t, State: s.State, Virtual: s.Virtual, Value: s.Value}
+				if v, ok := res.SplitPrices[s]; ok {
+					res.SplitPrices[ns] = v
+				}
+				if v, ok := res.LotPrices[s]; ok {
+					res.LotPrices[ns] = v
+				}
+				t.Splits = append(t.Splits, ns)
+			}
+			res.Comments[t] = []string{"forecast"}
+			res.Transactions = append(res.Transactions, t)
+		}
+	}
+	sort.SliceStable(res.Transactions, func(i, j int) bool {
+		return res.Transactions[i].Time.Before(res.Transactions[j].Time)
+	})
+	if err := res.Fill(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// IsForecast tells whether a transaction was generated by Forecast.
+func (l *Ledger) IsForecast(t *Transaction) bool {
+	c := l.Comments[t]
+	return t.ID == nil && len(c) == 1 && c[0] == "forecast"
+}
+
 // Account returns details for one account, given its ID.
 func (l *Ledger) Account(id ID) *Account {
 	x, ok := l.connection.(interface {
@@ -305,6 +878,33 @@ func (l *Ledger) Account(id ID) *Account {
 	return nil
 }
 
+// Source returns the file name and line number where an account, currency,
+// transaction, split or price was defined, if its ID records it
+// (as in the ledger backend).
+func (l *Ledger) Source(obj interface{}) (filename string, line int, ok bool) {
+	var id ID
+	switch x := obj.(type) {
+	case *Account:
+		id = x.ID
+	case *Currency:
+		id = x.ID
+	case *Transaction:
+		id = x.ID
+	case *Split:
+		id = x.ID
+	case *Price:
+		id = x.ID
+	}
+	src, ok := id.(interface {
+		Source() (string, int)
+	})
+	if !ok {
+		return "", 0, false
+	}
+	filename, line = src.Source()
+	return filename, line, true
+}
+
 // FullName returns the fully qualified name of the account:
 // the name of all its ancestors, separated by ":", and ending
 // with this account's name.
@@ -315,21 +915,386 @@ func (a Account) FullName() string {
 	return a.Parent.FullName() + ":" + a.Name
 }
 
+// DisplayName returns the alias of the account, if it has one,
+// or else its full name.
+func (a Account) DisplayName() string {
+	if a.Alias != "" {
+		return a.Alias
+	}
+	return a.FullName()
+}
+
+var accountTypes = []string{"", "asset", "liability", "equity", "income", "expense"}
+
+func (t AccountType) String() string {
+	if t < 0 || int(t) >= len(accountTypes) {
+		return fmt.Sprintf("AccountType(%d)", int(t))
+	}
+	return accountTypes[t]
+}
+
+// ParseAccountType returns the AccountType with a given name ("asset",
+// "liability", "equity", "income" or "expense").
+func ParseAccountType(s string) (AccountType, error) {
+	for i, name := range accountTypes[1:] {
+		if strings.EqualFold(s, name) {
+			return AccountType(i + 1), nil
+		}
+	}
+	return Untyped, fmt.Errorf("unknown account type %q", s)
+}
+
+// EffectiveType returns the type of the account or, if it has none,
+// the type of its nearest ancestor which has one.
+func (a *Account) EffectiveType() AccountType {
+	for ; a != nil; a = a.Parent {
+		if a.Type != Untyped {
+			return a.Type
+		}
+	}
+	return Untyped
+}
+
+// NaturalSign returns the sign which makes the usual balance of an account
+// positive, according to its type: -1 for liabilities, equity and income,
+// which normally have credit (negative) balances, and 1 for the rest.
+//
+// Multiplying amounts by it shows liabilities and income as positive numbers,
+// as most people expect to read them.
+func (a *Account) NaturalSign() int {
+	switch a.EffectiveType() {
+	case Liability, Equity, Income:
+		return -1
+	}
+	return 1
+}
+
+// SubtreeBalance returns the balance of an account plus the balances
+// of all its descendants, after their last splits (or their StartBalance,
+// if they have no splits).
+func (a *Account) SubtreeBalance() Balance {
+	var b Balance
+	if len(a.Splits) > 0 {
+		b.AddBalance(a.Splits[len(a.Splits)-1].Balance)
+	} else {
+		b.AddBalance(a.StartBalance)
+	}
+	for _, c := range a.Children {
+		b.AddBalance(c.SubtreeBalance())
+	}
+	return b
+}
+
+// Commodities returns the currencies which have appeared in any split
+// of the account, in the order they were first seen, even if its
+// current balance in some of them is zero.
+func (a *Account) Commodities() []*Currency {
+	var currencies []*Currency
+	seen := make(map[*Currency]bool)
+	for _, s := range a.Splits {
+		if c := s.Value.Currency; !seen[c] {
+			seen[c] = true
+			currencies = append(currencies, c)
+		}
+	}
+	return currencies
+}
+
+// HasPostingsInInterval tells whether the account has any split with
+// start <= time <= end; a zero time means there is no limit.
+// It does a binary search, so the splits must be sorted by time,
+// as they are after Fill.
+func (a *Account) HasPostingsInInterval(start, end time.Time) bool {
+	i := 0
+	if !start.IsZero() {
+		i = sort.Search(len(a.Splits), func(i int) bool {
+			return !a.Splits[i].Time.Before(start)
+		})
+	}
+	return i < len(a.Splits) && (end.IsZero() || !a.Splits[i].Time.After(end))
+}
+
+// TagValue returns the value of a tag ("name:value" comment) in an
+// account, transaction, split, currency or price, and whether it is present.
+//
+// Splits inherit the tags of their transaction: if a split does not have
+// a tag, it is looked up in its transaction. A tag in the split takes
+// precedence over a tag with the same name in the transaction.
+func (l *Ledger) TagValue(obj interface{}, name string) (string, bool) {
+	for _, c := range l.Comments[obj] {
+		c = strings.TrimSpace(c)
+		if strings.HasPrefix(c, name+":") {
+			return strings.TrimSpace(c[len(name)+1:]), true
+		}
+	}
+	if s, ok := obj.(*Split); ok && s.Transaction != nil {
+		return l.TagValue(s.Transaction, name)
+	}
+	return "", false
+}
+
+// Notes returns the comments in an account, transaction, split, currency
+// or price which are not tags (that is, which do not start with a
+// lowercase "name:"), in order.  Unlike TagValue, splits do not inherit
+// the notes of their transaction.
+func (l *Ledger) Notes(obj interface{}) []string {
+	var notes []string
+	for _, c := range l.Comments[obj] {
+		if !isTag(c) {
+			notes = append(notes, c)
+		}
+	}
+	return notes
+}
+
+// isTag tells whether a comment is a "name:value" tag,
+// with a name made of lowercase letters.
+func isTag(comment string) bool {
+	comment = strings.TrimSpace(comment)
+	i := strings.IndexByte(comment, ':')
+	if i <= 0 {
+		return false
+	}
+	for _, c := range comment[:i] {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeAccount moves every split and subaccount of src to dst, removes src
+// and recalculates the balances with Fill.
+// Subaccounts with the same name in src and dst are merged too.
+// The comments in src are added to the ones in dst, and the balance
+// assertions in its splits are kept (and checked against dst).
+func (l *Ledger) MergeAccount(src, dst *Account) error {
+	if src == nil || dst == nil {
+		return errors.New("MergeAccount: nil account")
+	}
+	for a := dst; a != nil; a = a.Parent {
+		if a == src {
+			return fmt.Errorf("cannot merge %s into itself or a subaccount", src.FullName())
+		}
+	}
+	l.mergeAccount(src, dst)
+	return l.Fill()
+}
+
+func (l *Ledger) mergeAccount(src, dst *Account) {
+	for _, t := range l.Transactions {
+		for _, s := range t.Splits {
+			if s.Account == src {
+				s.Account = dst
+			}
+		}
+	}
+	for _, child := range append([]*Account(nil), l.Accounts...) {
+		if child.Parent != src {
+			continue
+		}
+		var existing *Account
+		for _, a := range l.Accounts {
+			if a.Parent == dst && a.Name == child.Name {
+				existing = a
+				break
+			}
+		}
+		if existing != nil {
+			l.mergeAccount(child, existing)
+		} else {
+			child.Parent = dst
+		}
+	}
+	if src.StartClearedBalance != nil || dst.StartClearedBalance != nil {
+		cleared := dst.startClearedBalance().Dup()
+		cleared.AddBalance(src.startClearedBalance())
+		dst.StartClearedBalance = cleared
+	}
+	dst.StartBalance = dst.StartBalance.Dup()
+	dst.StartBalance.AddBalance(src.StartBalance)
+	if c, ok := l.Comments[src]; ok {
+		l.Comments[dst] = append(l.Comments[dst], c...)
+		delete(l.Comments, src)
+	}
+	if c, ok := l.FileComments[src]; ok {
+		l.FileComments[dst] = append(l.FileComments[dst], c...)
+		delete(l.FileComments, src)
+	}
+	for i, a := range l.Accounts {
+		if a == src {
+			l.Accounts = append(l.Accounts[:i], l.Accounts[i+1:]...)
+			break
+		}
+	}
+}
+
 // GetBalance gets an account balance at a given time.
 // If passed the zero value, it gets the current balance.
 func (l *Ledger) GetBalance(account *Account, when time.Time) Balance {
 	if len(account.Splits) == 0 {
-		return nil
+		return account.StartBalance
+	}
+	if (when == time.Time{}) {
+		return account.Splits[len(account.Splits)-1].Balance
+	}
+	for i := 0; i < len(account.Splits); i++ {
+		if account.Splits[i].Time.After(when) {
+			if i == 0 {
+				return account.StartBalance
+			}
+			return account.Splits[i-1].Balance
+		}
+	}
+	return account.Splits[len(account.Splits)-1].Balance
+}
+
+// GetClearedBalance is like GetBalance, but it only takes into account
+// the cleared splits (and the cleared part of StartBalance).
+func (l *Ledger) GetClearedBalance(account *Account, when time.Time) Balance {
+	n := len(account.Splits)
+	if !when.IsZero() {
+		n = sort.Search(n, func(i int) bool {
+			return account.Splits[i].Time.After(when)
+		})
+	}
+	if n == 0 {
+		return account.startClearedBalance()
+	}
+	return account.Splits[n-1].ClearedBalance
+}
+
+// startClearedBalance returns the cleared part of the StartBalance.
+func (a *Account) startClearedBalance() Balance {
+	if a.StartClearedBalance == nil {
+		return a.StartBalance
+	}
+	return a.StartClearedBalance
+}
+
+// NetWorthSeries returns the total balance of some accounts, converted
+// to one currency, at every step between from and to (both included).
+//
+// If some amount cannot be converted at one step, it is left out of
+// the total at that step, and the series goes on; the error returned
+// at the end lists all the failed conversions.
+func (l *Ledger) NetWorthSeries(accounts []*Account, from, to time.Time, step time.Duration, in *Currency) ([]struct {
+	Time  time.Time
+	Value Value
+}, error) {
+	if step <= 0 {
+		return nil, errors.New("NetWorthSeries: step must be positive")
+	}
+	if in == nil {
+		return nil, errors.New("NetWorthSeries: no currency")
+	}
+	var series []struct {
+		Time  time.Time
+		Value Value
+	}
+	var failed []string
+	for when := from; !when.After(to); when = when.Add(step) {
+		var b Balance
+		for _, a := range accounts {
+			b.AddBalance(l.GetBalance(a, when))
+		}
+		total := Value{Currency: in}
+		for _, v := range b {
+			nv, err := l.Convert(v, when, in)
+			if err != nil {
+				failed = append(failed, when.Format("2006-01-02 15:04")+": "+err.Error())
+				continue
+			}
+			total.Amount += nv.Amount
+		}
+		series = append(series, struct {
+			Time  time.Time
+			Value Value
+		}{when, total})
+	}
+	if len(failed) > 0 {
+		return series, fmt.Errorf("NetWorthSeries: %s", strings.Join(failed, "; "))
+	}
+	return series, nil
+}
+
+// Filter returns a new ledger with only the transactions for which keep
+// returns true, and the balances of the accounts computed again from them.
+// l is not modified; keep is called with the transactions of the new ledger.
+//
+// Balance assertions are kept only in opening-balance entries,
+// as the rest do not hold without the removed transactions.
+// The error is the one from Fill, if the new ledger cannot be filled.
+func (l *Ledger) Filter(keep func(*Transaction) bool) (*Ledger, error) {
+	res := l.Clone()
+	var transactions []*Transaction
+	for _, t := range res.Transactions {
+		opening := res.isOpening(t)
+		if !keep(t) {
+			for _, s := range t.Splits {
+				delete(res.Assertions, s)
+				delete(res.SplitPrices, s)
+				delete(res.LotPrices, s)
+				delete(res.Elided, s)
+			}
+			continue
+		}
+		if !opening {
+			for _, s := range t.Splits {
+				delete(res.Assertions, s)
+			}
+		}
+		transactions = append(transactions, t)
 	}
-	if (when == time.Time{}) {
-		return account.Splits[len(account.Splits)-1].Balance
+	res.Transactions = transactions
+	if err := res.Fill(); err != nil {
+		return nil, fmt.Errorf("Filter: %w", err)
 	}
-	for i := 1; i < len(account.Splits); i++ {
-		if account.Splits[i].Time.After(when) {
-			return account.Splits[i-1].Balance
+	return res, nil
+}
+
+// TrimTo restricts the ledger to the transactions and splits between begin
+// and end, both included (that is, with begin <= time <= end);
+// a zero time means there is no limit.
+//
+// The StartBalance of every account is set to its balance just before begin,
+// and the Balance in each of its remaining splits still includes it,
+// so the movements in the period are the difference between both.
+func (l *Ledger) TrimTo(begin, end time.Time) {
+	if !begin.IsZero() {
+		for i := len(l.Transactions) - 1; i >= 0; i-- {
+			if l.Transactions[i].Time.Before(begin) {
+				l.Transactions = l.Transactions[i+1:]
+				break
+			}
+		}
+		for _, a := range l.Accounts {
+			for j := len(a.Splits) - 1; j >= 0; j-- {
+				if a.Splits[j].Time.Before(begin) {
+					a.StartBalance = a.Splits[j].Balance
+					a.StartClearedBalance = a.Splits[j].ClearedBalance.Dup()
+					a.Splits = a.Splits[j+1:]
+					break
+				}
+			}
+		}
+	}
+	if !end.IsZero() {
+		for i, t := range l.Transactions {
+			if t.Time.After(end) {
+				l.Transactions = l.Transactions[:i]
+				break
+			}
+		}
+		for _, a := range l.Accounts {
+			for j, s := range a.Splits {
+				if s.Time.After(end) {
+					a.Splits = a.Splits[:j]
+					break
+				}
+			}
 		}
 	}
-	return account.Splits[len(account.Splits)-1].Balance
 }
 
 // TransactionsInAccount gets the list of all the transactions
@@ -355,6 +1320,56 @@ func (l *Ledger) TransactionsInAccount(account ID) []*Transaction {
 	return trans
 }
 
+// SplitCount returns the number of splits in all the transactions,
+// not counting the ones added by Fill to TransferAccount.
+func (l *Ledger) SplitCount() int {
+	var n int
+	for _, t := range l.Transactions {
+		for _, s := range t.Splits {
+			if !s.Account.IsTransferAccount() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// TransactionsBetween gets the list of all the transactions
+// with splits in both accounts.
+func (l *Ledger) TransactionsBetween(a, b *Account) []*Transaction {
+	trans := make([]*Transaction, 0)
+	for _, t := range l.Transactions {
+		var inA, inB bool
+		for _, s := range t.Splits {
+			if s.Account == a {
+				inA = true
+			}
+			if s.Account == b {
+				inB = true
+			}
+		}
+		if inA && inB {
+			trans = append(trans, t)
+		}
+	}
+	return trans
+}
+
+// Payees returns the names of all the payees in the transactions,
+// sorted and without duplicates.
+func (l *Ledger) Payees() []string {
+	seen := make(map[string]bool)
+	var payees []string
+	for _, t := range l.Transactions {
+		if t.Payee != "" && !seen[t.Payee] {
+			seen[t.Payee] = true
+			payees = append(payees, t.Payee)
+		}
+	}
+	sort.Strings(payees)
+	return payees
+}
+
 // TransactionsInInterval returns all the transactions between two times.
 func (l *Ledger) TransactionsInInterval(start, end time.Time) []*Transaction {
 	x, ok := l.connection.(interface {
@@ -376,15 +1391,43 @@ func (l *Ledger) TransactionsInInterval(start, end time.Time) []*Transaction {
 	return trans
 }
 
-// NewAccount adds a new Account in a ledger
+// newID returns a new SeqID, different from all the ones given before.
+func (l *Ledger) newID() ID {
+	l.lastID++
+	return l.lastID
+}
+
+// NewAccount adds a new Account in a ledger.
+// If the ledger has no backend, the account is just added to Accounts
+// (and to the Children of its Parent).
+// If the account has no ID after that, it is given a SeqID.
 func (l *Ledger) NewAccount(a Account) (*Account, error) {
 	x, ok := l.connection.(interface {
 		NewAccount(Account) (*Account, error)
 	})
-	if ok {
-		return x.NewAccount(a)
+	var account *Account
+	switch {
+	case ok:
+		var err error
+		if account, err = x.NewAccount(a); err != nil {
+			return nil, err
+		}
+	case l.connection == nil:
+		account = &a
+		account.Children = nil
+		account.Level = 0
+		if account.Parent != nil {
+			account.Level = account.Parent.Level + 1
+			account.Parent.Children = append(account.Parent.Children, account)
+		}
+		l.Accounts = append(l.Accounts, account)
+	default:
+		return nil, errors.New("Ledger.NewAccount: not implemented")
 	}
-	return nil, errors.New("Ledger.NewAccount: not implemented")
+	if account.ID == nil {
+		account.ID = l.newID()
+	}
+	return account, nil
 }
 
 // EditAccount edits an Account in a ledger
@@ -398,15 +1441,110 @@ func (l *Ledger) EditAccount(a Account) (*Account, error) {
 	return nil, errors.New("Ledger.EditAccount: not implemented")
 }
 
-// NewTransaction adds a new Transaction in a ledger
+// NewTransaction adds a new Transaction in a ledger.
+// If the ledger has no backend, the transaction is just inserted
+// in Transactions, after any other one with the same Time;
+// Fill must be called after that to update the accounts.
+// The transaction and its splits are given a SeqID if they have no ID.
 func (l *Ledger) NewTransaction(t Transaction) (*Transaction, error) {
 	x, ok := l.connection.(interface {
 		NewTransaction(Transaction) (*Transaction, error)
 	})
-	if ok {
-		return x.NewTransaction(t)
+	var transaction *Transaction
+	switch {
+	case ok:
+		var err error
+		if transaction, err = x.NewTransaction(t); err != nil {
+			return nil, err
+		}
+	case l.connection == nil:
+		transaction = &t
+		for _, s := range transaction.Splits {
+			s.Transaction = transaction
+		}
+		i := sort.Search(len(l.Transactions), func(i int) bool {
+			return l.Transactions[i].Time.After(t.Time)
+		})
+		l.Transactions = append(l.Transactions, nil)
+		copy(l.Transactions[i+1:], l.Transactions[i:])
+		l.Transactions[i] = transaction
+	default:
+		return nil, errors.New("Ledger.NewTransaction: not implemented")
+	}
+	if transaction.ID == nil {
+		transaction.ID = l.newID()
+	}
+	for _, s := range transaction.Splits {
+		if s.ID == nil {
+			s.ID = l.newID()
+		}
+	}
+	return transaction, nil
+}
+
+// NewAllocatedTransaction adds a new transaction with the only split in t
+// (the source) and one more split for every allocation, with the opposite of
+// the source value times its Fraction, rounded to the precision of the
+// currency (halves away from zero).  The Fractions must add up to 1.
+// The rounding remainder goes to the largest allocation (the first one,
+// if there are several), so that the transaction is balanced.
+func (l *Ledger) NewAllocatedTransaction(t Transaction, allocations []Allocation) (*Transaction, error) {
+	if len(t.Splits) != 1 || t.Splits[0].Value.Currency == nil {
+		return nil, errors.New("Ledger.NewAllocatedTransaction: transaction must have exactly one split with a value")
+	}
+	if len(allocations) == 0 {
+		return nil, errors.New("Ledger.NewAllocatedTransaction: no allocations")
+	}
+	var sum float64
+	largest := 0
+	for i, a := range allocations {
+		if a.Account == nil || !(a.Fraction > 0) {
+			return nil, fmt.Errorf("Ledger.NewAllocatedTransaction: wrong allocation %d", i)
+		}
+		sum += a.Fraction
+		if a.Fraction > allocations[largest].Fraction {
+			largest = i
+		}
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		return nil, fmt.Errorf("Ledger.NewAllocatedTransaction: fractions add up to %g", sum)
+	}
+	source := t.Splits[0].Value
+	unit := roundingUnit(source.Currency.Precision)
+	total := big.NewInt(source.Amount)
+	total.Neg(total)
+	splits := []*Split{t.Splits[0]}
+	remainder := new(big.Int).Set(total)
+	for _, a := range allocations {
+		var amount int64
+		if unit != 0 {
+			// the share is computed with the Fraction as it is written,
+			// in units of the currency, and rounded half away from zero:
+			share, _ := new(big.Rat).SetString(strconv.FormatFloat(a.Fraction, 'g', -1, 64))
+			share.Mul(share, new(big.Rat).SetFrac(total, big.NewInt(unit)))
+			q, r := new(big.Int).QuoRem(share.Num(), share.Denom(), new(big.Int))
+			if r.Lsh(r.Abs(r), 1).Cmp(share.Denom()) >= 0 {
+				q.Add(q, big.NewInt(int64(share.Sign())))
+			}
+			q.Mul(q, big.NewInt(unit))
+			if !q.IsInt64() {
+				return nil, fmt.Errorf("Ledger.NewAllocatedTransaction: %w", ErrOverflow)
+			}
+			amount = q.Int64()
+		}
+		remainder.Sub(remainder, big.NewInt(amount))
+		splits = append(splits, &Split{
+			Account: a.Account,
+			Value:   Value{Amount: amount, Currency: source.Currency},
+		})
 	}
-	return nil, errors.New("Ledger.NewTransaction: not implemented")
+	remainder.Add(remainder, big.NewInt(splits[1+largest].Value.Amount))
+	if !remainder.IsInt64() {
+		return nil, fmt.Errorf("Ledger.NewAllocatedTransaction: %w", ErrOverflow)
+	}
+	splits[1+largest].Value.Amount = remainder.Int64()
+	t.Splits = splits
+	return l.NewTransaction(t)
 }
 
 // EditTransaction edits a Transaction in a ledger
@@ -442,28 +1580,112 @@ func SortAccounts(accounts []*Account) []*Account {
 	return accounts
 }
 
-// GetCurrency returns a Currency, given its name, and whether it is a new one or not
+// AddPrice inserts a price in the ledger, keeping Prices sorted by Time.
+// It goes after any other price with the same Time.
+func (l *Ledger) AddPrice(p *Price) {
+	i := sort.Search(len(l.Prices), func(i int) bool {
+		return l.Prices[i].Time.After(p.Time)
+	})
+	l.Prices = append(l.Prices, nil)
+	copy(l.Prices[i+1:], l.Prices[i:])
+	l.Prices[i] = p
+}
+
+// AddPrices adds several prices to the ledger, sorting Prices by Time
+// only once.
+func (l *Ledger) AddPrices(ps []*Price) {
+	l.Prices = append(l.Prices, ps...)
+	sort.SliceStable(l.Prices, func(i, j int) bool {
+		return l.Prices[i].Time.Before(l.Prices[j].Time)
+	})
+}
+
+// GetCurrency returns a Currency, given its name, and whether it is a new one or not.
+// New currencies are added to l.Currencies, so there is only one *Currency for every name.
 func (l *Ledger) GetCurrency(s string) (*Currency, bool) {
-	for i := range l.Currencies {
-		if s == l.Currencies[i].Name {
-			return l.Currencies[i], false
-		}
+	// l.Currencies can be changed directly; in that case, the index is rebuilt:
+	if len(l.currencies) != len(l.Currencies) {
+		l.indexCurrencies()
+	}
+	if c := l.currencies[s]; c != nil && c.Name == s {
+		return c, false
+	}
+	l.indexCurrencies()
+	if c := l.currencies[s]; c != nil {
+		return c, false
 	}
 	var currency Currency
 	currency.Name = s
 	l.Currencies = append(l.Currencies, &currency)
+	l.currencies[s] = &currency
 	return &currency, true
 }
 
+// indexCurrencies rebuilds the index of currencies by name.
+// If several currencies have the same name, the first one is used.
+func (l *Ledger) indexCurrencies() {
+	l.currencies = make(map[string]*Currency, len(l.Currencies))
+	for _, c := range l.Currencies {
+		if l.currencies[c.Name] == nil {
+			l.currencies[c.Name] = c
+		}
+	}
+}
+
+// Cmp compares two values, returning -1, 0 or +1 if value is less than,
+// equal to or greater than v2.  Values in different currencies are
+// ordered by the names of their currencies.
+func (value Value) Cmp(v2 Value) int {
+	if value.Currency != v2.Currency {
+		var n1, n2 string
+		if value.Currency != nil {
+			n1 = value.Currency.Name
+		}
+		if v2.Currency != nil {
+			n2 = v2.Currency.Name
+		}
+		if n1 != n2 {
+			return strings.Compare(n1, n2)
+		}
+	}
+	switch {
+	case value.Amount < v2.Amount:
+		return -1
+	case value.Amount > v2.Amount:
+		return 1
+	}
+	return 0
+}
+
 // Mul multiplies a value times the amount of another.
-func (value *Value) Mul(v2 Value) {
+// If the result does not fit in a Value, it returns ErrOverflow
+// and leaves value unchanged.
+func (value *Value) Mul(v2 Value) error {
 	i := big.NewInt(value.Amount)
 	i.Mul(i, big.NewInt(v2.Amount))
 	i.Div(i, big.NewInt(U))
+	if !i.IsInt64() {
+		return ErrOverflow
+	}
 	value.Amount = i.Int64()
+	return nil
+}
+
+// addOverflows tells whether adding v to b would overflow
+// its amount in the currency of v.
+func (b Balance) addOverflows(v Value) bool {
+	for _, x := range b {
+		if x.Currency == v.Currency {
+			sum := x.Amount + v.Amount
+			return (x.Amount > 0 && v.Amount > 0 && sum < 0) || (x.Amount < 0 && v.Amount < 0 && sum >= 0)
+		}
+	}
+	return false
 }
 
 // Add adds a value to a balance.
+// Adding a zero value does nothing, and values which become zero are removed,
+// so a balance never contains zero amounts.
 func (b *Balance) Add(v Value) {
 	if v.Amount == 0 {
 		return
@@ -501,6 +1723,79 @@ func (b *Balance) SubBalance(b2 Balance) {
 	}
 }
 
+// Negate returns a value with the opposite amount.
+func (value Value) Negate() Value {
+	value.Amount = -value.Amount
+	return value
+}
+
+// Round returns a value with its amount rounded to the precision of its
+// currency (to a multiple of U/10^Precision), rounding halves to even.
+// Unlike the rounding done when displaying a value, it changes the amount.
+// Values without a currency are returned unchanged.
+func (value Value) Round() Value {
+	if value.Currency == nil {
+		return value
+	}
+	unit := roundingUnit(value.Currency.Precision)
+	if unit == 0 {
+		// every amount is less than half of it
+		value.Amount = 0
+		return value
+	}
+	negative := value.Amount < 0
+	amount := value.Amount
+	if negative {
+		amount = -amount
+	}
+	q, r := amount/unit, amount%unit
+	if r > unit-r || (r == unit-r && q%2 == 1) {
+		q++
+	}
+	value.Amount = q * unit
+	if negative {
+		value.Amount = -value.Amount
+	}
+	return value
+}
+
+// roundingUnit returns the amount of the smallest value with a precision
+// (U/10^precision), or 0 if it is too big to fit in an int64.
+func roundingUnit(precision int) int64 {
+	unit := int64(U)
+	for p := precision; p > 0 && unit > 1; p-- {
+		unit /= 10
+	}
+	for p := precision; p < 0; p++ {
+		if unit > math.MaxInt64/10 {
+			return 0
+		}
+		unit *= 10
+	}
+	return unit
+}
+
+// Float64 returns the amount of a value as a floating-point number.
+// It is not exact: amounts with more than 15 or 16 significant digits
+// lose precision, so it should only be used for statistics or plotting.
+func (value Value) Float64() float64 {
+	return float64(value.Amount) / U
+}
+
+// Rat returns the exact amount of a value as a rational number.
+func (value Value) Rat() *big.Rat {
+	return big.NewRat(value.Amount, U)
+}
+
+// Negate returns a balance with the opposite amount in every currency.
+func (b Balance) Negate() Balance {
+	res := make(Balance, len(b))
+	for i, v := range b {
+		res[i] = v.Negate()
+	}
+	return res
+}
+
 // Dup duplicates a Balance.
 func (b Balance) Dup() Balance {
 	res := Balance{}
@@ -510,6 +1805,50 @@ func (b Balance) Dup() Balance {
 	return res
 }
 
+// TotalByCurrency returns the sum of the values of b in currency c
+// (with a zero amount if there are none).
+func (b Balance) TotalByCurrency(c *Currency) Value {
+	res := Value{Currency: c}
+	for _, v := range b {
+		if v.Currency == c {
+			res.Amount += v.Amount
+		}
+	}
+	return res
+}
+
+// SumBalances returns the sum of some balances, in every currency.
+// It is used for the "Total" column of periodic reports.
+func SumBalances(bs ...Balance) Balance {
+	var res Balance
+	for _, b := range bs {
+		res.AddBalance(b)
+	}
+	return res
+}
+
+// AverageBalance returns the mean of some balances in every currency,
+// counting the balances without that currency as zero, and rounding
+// to the nearest unit (times U).
+// It is used for the "Average" column of periodic reports.
+func AverageBalance(bs ...Balance) Balance {
+	var res Balance
+	n := int64(len(bs))
+	for _, v := range SumBalances(bs...) {
+		q, r := v.Amount/n, v.Amount%n
+		if 2*abs(r) >= n {
+			if v.Amount < 0 {
+				q--
+			} else {
+				q++
+			}
+		}
+		v.Amount = q
+		res.Add(v)
+	}
+	return res
+}
+
 func insertAccount(where *[]*Account, account *Account) {
 	*where = append(*where, account)
 	for _, a := range account.Children {
@@ -523,6 +1862,9 @@ func (l *Ledger) Convert(v Value, when time.Time, currency *Currency) (Value, er
 		//fmt.Printf("Convert(%s,%s,%s) = %s (1)\n", v, when.Format("2006-01-02"), currency.Name, v)
 		return v, nil
 	}
+	if currency == nil {
+		return v, fmt.Errorf("could not convert %q: no currency to convert to", v)
+	}
 	var prevTime, nextTime time.Time
 	var prevValue, nextValue Value
 	prevValue = v
@@ -531,9 +1873,11 @@ func (l *Ledger) Convert(v Value, when time.Time, currency *Currency) (Value, er
 			continue
 		}
 		//fmt.Printf("Price: %s %s = %s\n", p.Time, p.Currency.Name, p.Value)
-		if p.Time == when {
+		if p.Time.Equal(when) {
 			tmp := p.Value
-			tmp.Mul(v)
+			if err := tmp.Mul(v); err != nil {
+				return v, fmt.Errorf("could not convert %q to %q: %w", v, currency.Name, err)
+			}
 			//fmt.Printf("Convert(%s,%s,%s) = %s (2)\n", v, when.Format("2006-01-02"), currency.Name, p.Value)
 			return tmp, nil
 		}
@@ -573,33 +1917,244 @@ func (l *Ledger) Convert(v Value, when time.Time, currency *Currency) (Value, er
 		return l.Convert(nv, when, currency)
 	}
 	if nextTime == (time.Time{}) {
-		prevValue.Mul(v)
-		return prevValue, nil
+		nextValue = prevValue
+	} else if prevTime != (time.Time{}) {
+		d1 := when.Sub(prevTime)
+		d2 := nextTime.Sub(prevTime)
+		i := big.NewInt(nextValue.Amount)
+		i.Sub(i, big.NewInt(prevValue.Amount))
+		i.Mul(i, big.NewInt(int64(d1)))
+		i.Quo(i, big.NewInt(int64(d2)))
+		i.Add(i, big.NewInt(prevValue.Amount))
+		nextValue.Amount = i.Int64() // between both prices, so it fits
+	}
+	if err := nextValue.Mul(v); err != nil {
+		return v, fmt.Errorf("could not convert %q to %q: %w", v, currency.Name, err)
+	}
+	return nextValue, nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// adjustRounding absorbs a small residual left when balancing a transaction,
+// in a new split to l.RoundingAccount or in the largest split in that currency.
+// It returns the split which has been added or modified.
+func (l *Ledger) adjustRounding(t *Transaction, residual Value) (*Split, error) {
+	adjustment := Value{Amount: -residual.Amount, Currency: residual.Currency}
+	var s *Split
+	if l.RoundingAccount != "" {
+		var account *Account
+		for _, a := range l.Accounts {
+			if a.FullName() == l.RoundingAccount {
+				account = a
+				break
+			}
+		}
+		if account == nil {
+			return nil, fmt.Errorf("%s: rounding account %q not found", t.ID, l.RoundingAccount)
+		}
+		s = &Split{
+			Account:     account,
+			Transaction: t,
+			Time:        &t.Time,
+			Value:       adjustment,
+		}
+		t.Splits = append(t.Splits, s)
+		i := sort.Search(len(account.Splits), func(i int) bool {
+			return account.Splits[i].Time.After(t.Time)
+		})
+		account.Splits = append(account.Splits, nil)
+		copy(account.Splits[i+1:], account.Splits[i:])
+		account.Splits[i] = s
+	} else {
+		var largest int64 = -1
+		for _, sp := range t.Splits {
+			v := sp.Value
+			if p, ok := l.Cost(sp); ok {
+				v = p
+			}
+			if v.Currency == residual.Currency && abs(v.Amount) > largest {
+				s = sp
+				largest = abs(v.Amount)
+			}
+		}
+		if p, ok := l.Cost(s); ok {
+			p.Amount += adjustment.Amount
+			l.SplitPrices[s] = p
+		} else {
+			s.Value.Amount += adjustment.Amount
+		}
+	}
+	l.Comments[s] = append(l.Comments[s], "rounding:"+adjustment.FullString())
+	return s, nil
+}
+
+// InterpolatePrices adds one price per day between the first and the last
+// price declared for every pair of currencies, using the same interpolation
+// as Convert.  New prices are commented as "interpolated".
+func (l *Ledger) InterpolatePrices() {
+	type pair struct {
+		from, to *Currency
+	}
+	first := make(map[pair]*Price)
+	last := make(map[pair]*Price)
+	days := make(map[pair]map[string]bool)
+	var pairs []pair
+	for _, p := range l.Prices {
+		k := pair{p.Currency, p.Value.Currency}
+		if first[k] == nil {
+			first[k] = p
+			days[k] = make(map[string]bool)
+			pairs = append(pairs, k)
+		}
+		last[k] = p
+		days[k][p.Time.Format("2006-01-02")] = true
+	}
+	var prices []*Price
+	for _, k := range pairs {
+		for t := first[k].Time.AddDate(0, 0, 1); t.Before(last[k].Time); t = t.AddDate(0, 0, 1) {
+			if days[k][t.Format("2006-01-02")] {
+				continue
+			}
+			v, err := l.Convert(Value{Amount: U, Currency: k.from}, t, k.to)
+			if err != nil {
+				continue
+			}
+			prices = append(prices, &Price{
+				Time:     t,
+				Currency: k.from,
+				Value:    v,
+			})
+		}
+	}
+	for _, p := range prices {
+		l.Prices = append(l.Prices, p)
+		l.Comments[p] = append(l.Comments[p], "interpolated")
 	}
-	if prevTime == (time.Time{}) {
-		nextValue.Mul(v)
-		return nextValue, nil
+	sort.SliceStable(l.Prices, func(i, j int) bool {
+		return l.Prices[i].Time.Before(l.Prices[j].Time)
+	})
+}
+
+// CheckSplitTimes returns a warning for every split whose time is more than
+// window away from the time of its transaction, which is usually a typo.
+func (l *Ledger) CheckSplitTimes(window time.Duration) []error {
+	var warnings []error
+	for _, t := range l.Transactions {
+		for _, s := range t.Splits {
+			if s.Time == nil || s.Account.IsTransferAccount() {
+				continue
+			}
+			d := s.Time.Sub(t.Time)
+			if d < 0 {
+				d = -d
+			}
+			if d > window {
+				var id ID = s.ID
+				if id == nil {
+					id = t.ID
+				}
+				warnings = append(warnings, fmt.Errorf("%v: split date %s is %d days away from transaction date %s",
+					id, s.Time.Format("2006-01-02"), int(d.Hours()/24), t.Time.Format("2006-01-02")))
+			}
+		}
+	}
+	return warnings
+}
+
+// Cost returns the total cost of a split in the currency of its price:
+// its amount times the unit price (for "@" prices) or the total price
+// (for "@@" prices), with the same sign as the amount.
+// It returns false if the split has no price.
+func (l *Ledger) Cost(s *Split) (Value, bool) {
+	v, ok := l.SplitPrices[s]
+	if !ok {
+		return Value{}, false
+	}
+	if (v.Amount < 0) != (s.Value.Amount < 0) {
+		v.Amount = -v.Amount
+	}
+	return v, true
+}
+
+// isOpening tells whether a transaction is an opening-balance entry: one
+// in which every split is a balance assertion without any other amount,
+// and is the first split in an account without a StartBalance.
+// Those transactions do not need to be balanced.
+func (l *Ledger) isOpening(t *Transaction) bool {
+	if len(t.Splits) == 0 {
+		return false
+	}
+	for _, s := range t.Splits {
+		a := l.Assertions[s]
+		if a == (Value{}) || a.Currency == nil {
+			return false
+		}
+		if s.Value != (Value{}) && s.Value != a {
+			return false
+		}
+		if len(s.Account.Splits) == 0 || s.Account.Splits[0] != s || len(s.Account.StartBalance) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsTransferAccount tells whether an account is TransferAccount
+// or a copy of it made by Clone.
+func (a *Account) IsTransferAccount() bool {
+	return a == &TransferAccount || (a != nil && a.Parent == nil && a.ID == nil && a.Name == TransferAccount.Name)
+}
+
+// addAutomaticPrices adds two prices, marked with an "automatic" comment,
+// to convert from v1 to v2 and vice-versa, unless l.NoAutomaticPrices is set.
+func (l *Ledger) addAutomaticPrices(when time.Time, v1, v2 Value) {
+	if l.NoAutomaticPrices {
+		return
+	}
+	for _, p := range [][2]Value{{v1, v2}, {v2, v1}} {
+		price := new(Price)
+		price.Time = when
+		price.Currency = p[0].Currency
+		i := big.NewInt(U)
+		i.Mul(i, big.NewInt(p[1].Amount))
+		i.Quo(i, big.NewInt(p[0].Amount))
+		if !i.IsInt64() {
+			// too big (or small) to be stored
+			continue
+		}
+		price.Value.Amount = i.Int64()
+		price.Value.Currency = p[1].Currency
+		l.Prices = append(l.Prices, price)
+		l.Comments[price] = append(l.Comments[price], "automatic")
 	}
-	d1 := when.Sub(prevTime)
-	d2 := nextTime.Sub(prevTime)
-	i := big.NewInt(nextValue.Amount - prevValue.Amount)
-	i.Mul(i, big.NewInt(int64(d1)))
-	i.Quo(i, big.NewInt(int64(d2)))
-	i.Add(i, big.NewInt(prevValue.Amount))
-	prevValue.Amount = i.Int64()
-	prevValue.Mul(v)
-	return prevValue, nil
 }
 
 // Fill re-calculates all the automatic fields in all the accounting data.
+// It can be called again after changing the transactions or accounts.
+// The balance of every account starts with its StartBalance.
 func (l *Ledger) Fill() error {
+	if l.Comments == nil {
+		l.Comments = make(map[interface{}][]string)
+	}
+	if l.Elided == nil {
+		l.Elided = make(map[*Split]bool)
+	}
 	for _, a := range l.Accounts {
 		a.Splits = nil
 		a.Children = nil
 	}
 	for _, a := range l.Accounts {
+		a.Level = 0
+		for p := a.Parent; p != nil; p = p.Parent {
+			a.Level++
+		}
 		if a.Parent != nil {
-			a.Level = a.Parent.Level + 1
 			a.Parent.Children = append(a.Parent.Children, a)
 		}
 	}
@@ -612,22 +2167,37 @@ func (l *Ledger) Fill() error {
 	l.Accounts = newAccounts
 
 	// Remove splits with transferAccount, if any:
-	for i := range l.Transactions {
-		// TODO: this may be buggy!
-		for j := range l.Transactions[i].Splits {
-			if j >= len(l.Transactions[i].Splits) {
-				break
+	for _, t := range l.Transactions {
+		splits := t.Splits[:0]
+		for _, s := range t.Splits {
+			s.Balance = nil
+			if !s.Account.IsTransferAccount() {
+				splits = append(splits, s)
 			}
-			l.Transactions[i].Splits[j].Balance = nil
-			if l.Transactions[i].Splits[j].Account == &TransferAccount {
-				l.Transactions[i].Splits[j] = l.Transactions[i].Splits[len(l.Transactions[i].Splits)-1]
-				l.Transactions[i].Splits = l.Transactions[i].Splits[:len(l.Transactions[i].Splits)-1]
+		}
+		t.Splits = splits
+	}
+	// and the prices added by a previous Fill:
+	prices := l.Prices[:0]
+	for _, p := range l.Prices {
+		if c := l.Comments[p]; p.ID == nil && len(c) == 1 && c[0] == "automatic" {
+			delete(l.Comments, p)
+			continue
+		}
+		prices = append(prices, p)
+	}
+	l.Prices = prices
+	if l.KeepOrder {
+		for i := 1; i < len(l.Transactions); i++ {
+			if t := l.Transactions[i]; t.Time.Before(l.Transactions[i-1].Time) {
+				return &TransactionError{t, fmt.Errorf("%s: transaction is not chronologically sorted", t.ID)}
 			}
 		}
+	} else {
+		sort.SliceStable(l.Transactions, func(i, j int) bool {
+			return l.Transactions[i].Time.Before(l.Transactions[j].Time)
+		})
 	}
-	sort.SliceStable(l.Transactions, func(i, j int) bool {
-		return l.Transactions[i].Time.Before(l.Transactions[j].Time)
-	})
 
 	for _, t := range l.Transactions {
 		for _, s := range t.Splits {
@@ -638,12 +2208,30 @@ func (l *Ledger) Fill() error {
 			s.Account.Splits = append(s.Account.Splits, s)
 		}
 	}
+	// periodic transactions do not move any money until Forecast:
+	for _, p := range l.Periodic {
+		for _, s := range p.Template.Splits {
+			s.Transaction = &p.Template
+		}
+	}
 	for _, a := range l.Accounts {
 		sort.SliceStable(a.Splits, func(i, j int) bool {
 			return a.Splits[i].Time.Before(*a.Splits[j].Time)
 		})
 	}
 
+	// Transactions with only balance assertions, at the start
+	// of their accounts, set their opening balances:
+	opening := make(map[*Transaction]bool)
+	for _, t := range l.Transactions {
+		if l.isOpening(t) {
+			opening[t] = true
+			for _, s := range t.Splits {
+				s.Value = l.Assertions[s]
+			}
+		}
+	}
+
 	finished := false
 	deadlock := false
 	iTransactions := 0
@@ -655,29 +2243,46 @@ func (l *Ledger) Fill() error {
 			finished = false
 			// Check for the correctness of a transaction, and fill all the calculated fields
 			transaction := l.Transactions[iTransactions]
+			if opening[transaction] {
+				deadlock = false
+				continue
+			}
 			var unbalancedSplit *Split
 			var balance Balance
 			for i, s := range transaction.Splits {
 				if s.Value.Currency == nil && l.Assertions[s] != (Value{}) {
 					goto endTransaction
 				}
+				if s.Virtual == UnbalancedVirtual {
+					if s.Value.Currency == nil {
+						return &TransactionError{transaction, fmt.Errorf("%s: virtual posting without amount", transaction.ID)}
+					}
+					continue
+				}
 				if s.Value.Currency == nil {
 					if unbalancedSplit != nil {
-						return fmt.Errorf("%s: more than one posting without amount", transaction.ID)
+						return &TransactionError{transaction, fmt.Errorf("%s: more than one posting without amount", transaction.ID)}
 					}
 					unbalancedSplit = transaction.Splits[i]
 					continue
 				}
-				if v, ok := l.SplitPrices[s]; ok == true {
-					balance.Add(v)
-				} else {
-					balance.Add(s.Value)
+				// Splits with a price are balanced in the currency of their
+				// price, so an elided split can absorb the residual even if
+				// the other splits have different commodities:
+				v, ok := l.Cost(s)
+				if !ok {
+					v = s.Value
 				}
+				if balance.addOverflows(v) {
+					return &TransactionError{transaction, fmt.Errorf("%s: could not balance transaction: %w", transaction.ID, ErrOverflow)}
+				}
+				balance.Add(v)
 			}
 			if len(balance) == 0 {
 				// everything is balanced
 				if unbalancedSplit != nil {
 					unbalancedSplit.Value.Currency = new(Currency)
+					l.Elided[unbalancedSplit] = true
 				}
 				deadlock = false
 				continue
@@ -685,49 +2290,51 @@ func (l *Ledger) Fill() error {
 			if unbalancedSplit != nil && len(balance) == 1 {
 				unbalancedSplit.Value = balance[0]
 				unbalancedSplit.Value.Amount = -unbalancedSplit.Value.Amount
+				l.Elided[unbalancedSplit] = true
 				deadlock = false
 				continue
 			}
 			if unbalancedSplit != nil {
-				return fmt.Errorf("%s: could not balance account %q: two or more currencies in transaction", transaction.ID, unbalancedSplit.Account.FullName())
+				return &TransactionError{transaction, fmt.Errorf("%s: could not balance account %q: two or more currencies in transaction", transaction.ID, unbalancedSplit.Account.FullName())}
+			}
+			if len(balance) == 1 && abs(balance[0].Amount) <= l.RoundingTolerance {
+				s, err := l.adjustRounding(transaction, balance[0])
+				if err != nil {
+					return &TransactionError{transaction, err}
+				}
+				// If the balances in this account have already been
+				// calculated after this split, they must be re-calculated:
+				for i, a := range l.Accounts {
+					if a != s.Account {
+						continue
+					}
+					for j := 0; j < iAccounts[i]; j++ {
+						if a.Splits[j] == s {
+							iAccounts[i] = j
+							break
+						}
+					}
+				}
+				deadlock = false
+				continue
 			}
 			if len(balance) == 1 {
-				return fmt.Errorf("%s: could not balance transaction: total amount is %s", transaction.ID, balance[0])
+				return &TransactionError{transaction, fmt.Errorf("%s: could not balance transaction: total amount is %s", transaction.ID, balance[0])}
 			}
 			if len(balance) == 2 {
 				// we add 2 automatic prices, converting one currency to another and vice-versa
-				price := new(Price)
-				var i *big.Int
-				price.Time = transaction.Time
-				price.Currency = balance[0].Currency
-				i = big.NewInt(-U)
-				i.Mul(i, big.NewInt(balance[1].Amount))
-				i.Quo(i, big.NewInt(balance[0].Amount))
-				price.Value.Amount = i.Int64()
-				price.Value.Currency = balance[1].Currency
-				l.Prices = append(l.Prices, price)
-				l.Comments[price] = append(l.Comments[price], "automatic")
-				price = new(Price)
-				price.Time = transaction.Time
-				price.Currency = balance[1].Currency
-				i = big.NewInt(-U)
-				i.Mul(i, big.NewInt(balance[0].Amount))
-				i.Quo(i, big.NewInt(balance[1].Amount))
-				price.Value.Amount = i.Int64()
-				price.Value.Currency = balance[0].Currency
-				l.Prices = append(l.Prices, price)
-				l.Comments[price] = append(l.Comments[price], "automatic")
+				l.addAutomaticPrices(transaction.Time, balance[0], balance[1].Negate())
 				deadlock = false
 				continue
 			}
 			if len(balance) > 2 {
-				return fmt.Errorf("%s: not able to balance transactions with 3 or more currencies", transaction.ID)
+				return &TransactionError{transaction, fmt.Errorf("%s: not able to balance transactions with 3 or more currencies", transaction.ID)}
 			}
 			panic("balancing transaction: unreachable code")
 		}
 	endTransaction:
 		for i := 0; i < len(l.Accounts); i++ {
-			var b Balance
+			b := l.Accounts[i].StartBalance.Dup()
 			if iAccounts[i] > 0 {
 				b = l.Accounts[i].Splits[iAccounts[i]-1].Balance.Dup()
 			}
@@ -738,6 +2345,9 @@ func (l *Ledger) Fill() error {
 					break
 				}
 				deadlock = false
+				if b.addOverflows(s.Value) {
+					return &TransactionError{s.Transaction, fmt.Errorf("%s: balance of %s: %w", s.Transaction.ID, l.Accounts[i].FullName(), ErrOverflow)}
+				}
 				b.Add(s.Value)
 				s.Balance = b.Dup()
 				if a := l.Assertions[s]; a != (Value{}) {
@@ -758,46 +2368,28 @@ func (l *Ledger) Fill() error {
 								b.Add(s.Value)
 								s.Balance.Add(s.Value)
 							} else if v.Amount != a.Amount {
-								return fmt.Errorf("%s: wrong assertion: %s != %s", s.ID, v, a)
+								return &TransactionError{s.Transaction, newAssertionError(s, a, v)}
 							}
 							a = Value{}
 							break
 						}
 					}
 					if a != (Value{}) {
-						return fmt.Errorf("%s: wrong assertion: %s", s.ID, a)
+						return &TransactionError{s.Transaction, newAssertionError(s, a, Value{Currency: a.Currency})}
 					}
 				}
 			}
 		}
 	}
 	if !finished && deadlock {
-		return fmt.Errorf("%s: deadlock (cannot balance transaction)", l.Transactions[iTransactions].ID)
+		t := l.Transactions[iTransactions]
+		return &TransactionError{t, fmt.Errorf("%s: deadlock (cannot balance transaction)", t.ID)}
 	}
 
 	// Adding prices from splits
-	for s, v := range l.SplitPrices {
-		price := new(Price)
-		price.Time = *s.Time
-		price.Currency = s.Value.Currency
-		i := big.NewInt(U)
-		i.Mul(i, big.NewInt(v.Amount))
-		i.Quo(i, big.NewInt(s.Value.Amount))
-		price.Value.Amount = i.Int64()
-		price.Value.Currency = v.Currency
-		l.Prices = append(l.Prices, price)
-		l.Comments[price] = append(l.Comments[price], "automatic")
-
-		price = new(Price)
-		price.Time = *s.Time
-		price.Currency = v.Currency
-		i = big.NewInt(U)
-		i.Mul(i, big.NewInt(s.Value.Amount))
-		i.Quo(i, big.NewInt(v.Amount))
-		price.Value.Amount = i.Int64()
-		price.Value.Currency = s.Value.Currency
-		l.Prices = append(l.Prices, price)
-		l.Comments[price] = append(l.Comments[price], "automatic")
+	for s := range l.SplitPrices {
+		v, _ := l.Cost(s)
+		l.addAutomaticPrices(*s.Time, s.Value, v)
 	}
 
 	// This must be executed after all the balances
@@ -806,29 +2398,36 @@ func (l *Ledger) Fill() error {
 	})
 
 	// Create fake splits in transactions with different times.
-	for i := range l.Accounts {
-		if l.Accounts[i] == &TransferAccount {
-			goto transferAlreadyInAccounts
+	// Clones of the ledger have their own copy of TransferAccount.
+	var transfer *Account
+	for _, a := range l.Accounts {
+		if a.IsTransferAccount() {
+			transfer = a
+			break
 		}
 	}
-	l.Accounts = append(l.Accounts, &TransferAccount)
-transferAlreadyInAccounts:
+	if transfer == nil {
+		transfer = &TransferAccount
+		l.Accounts = append(l.Accounts, transfer)
+	}
 	for i := range l.Transactions {
 		for j := range l.Transactions[i].Splits {
 			if l.Transactions[i].Splits[j].Time != &l.Transactions[i].Time {
 				split1 := &Split{
-					Account:     &TransferAccount,
+					Account:     transfer,
 					Transaction: l.Transactions[i],
 					Time:        l.Transactions[i].Splits[j].Time,
+					State:       l.Transactions[i].Splits[j].State,
 					Value: Value{
 						Amount:   -l.Transactions[i].Splits[j].Value.Amount,
 						Currency: l.Transactions[i].Splits[j].Value.Currency,
 					},
 				}
 				split2 := &Split{
-					Account:     &TransferAccount,
+					Account:     transfer,
 					Transaction: l.Transactions[i],
 					Time:        &l.Transactions[i].Time,
+					State:       l.Transactions[i].Splits[j].State,
 					Value: Value{
 						Amount:   l.Transactions[i].Splits[j].Value.Amount,
 						Currency: l.Transactions[i].Splits[j].Value.Currency,
@@ -836,19 +2435,29 @@ transferAlreadyInAccounts:
 				}
 				l.Transactions[i].Splits = append(l.Transactions[i].Splits, split1)
 				l.Transactions[i].Splits = append(l.Transactions[i].Splits, split2)
-				TransferAccount.Splits = append(TransferAccount.Splits, split1)
-				TransferAccount.Splits = append(TransferAccount.Splits, split2)
+				transfer.Splits = append(transfer.Splits, split1)
+				transfer.Splits = append(transfer.Splits, split2)
 			}
 		}
 	}
-	sort.SliceStable(TransferAccount.Splits, func(i, j int) bool {
-		return TransferAccount.Splits[i].Time.Before(*TransferAccount.Splits[j].Time)
+	sort.SliceStable(transfer.Splits, func(i, j int) bool {
+		return transfer.Splits[i].Time.Before(*transfer.Splits[j].Time)
 	})
 
 	var b Balance
-	for _, s := range TransferAccount.Splits {
+	for _, s := range transfer.Splits {
 		b.Add(s.Value)
 		s.Balance = b.Dup()
 	}
+
+	for _, a := range l.Accounts {
+		b := a.startClearedBalance().Dup()
+		for _, s := range a.Splits {
+			if s.State == Cleared {
+				b.Add(s.Value)
+			}
+			s.ClearedBalance = b.Dup()
+		}
+	}
 	return nil
 }
@@ -1,7 +1,12 @@
 package accounting
 
 import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCurrencyString(t *testing.T) {
@@ -226,3 +231,1040 @@ func TestCurrencyString(t *testing.T) {
 		t.Errorf("Money(-23.45) = %q", got)
 	}
 }
+
+func roundingLedger() *Ledger {
+	eur := &Currency{Name: "EUR", Decimal: ".", Precision: 2}
+	cash := &Account{Name: "Cash"}
+	food := &Account{Name: "Food"}
+	rounding := &Account{Name: "Rounding"}
+	t := &Transaction{
+		Time: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		Splits: []*Split{
+			{Account: food, Value: Value{Amount: 10*U + 1, Currency: eur}},
+			{Account: cash, Value: Value{Amount: -10 * U, Currency: eur}},
+		},
+	}
+	return &Ledger{
+		Accounts:     []*Account{cash, food, rounding},
+		Transactions: []*Transaction{t},
+		Currencies:   []*Currency{eur},
+	}
+}
+
+func TestFillRounding(t *testing.T) {
+	l := roundingLedger()
+	if err := l.Fill(); err == nil {
+		t.Errorf("Fill() without tolerance: expected failure")
+	}
+
+	l = roundingLedger()
+	l.RoundingTolerance = 1
+	if err := l.Fill(); err != nil {
+		t.Fatalf("Fill() with tolerance: %v", err)
+	}
+	s := l.Transactions[0].Splits[0]
+	if s.Value.Amount != 10*U {
+		t.Errorf("largest split = %d (expected %d)", s.Value.Amount, 10*U)
+	}
+	if len(l.Comments[s]) != 1 {
+		t.Errorf("adjustment was not reported in split comments")
+	}
+
+	l = roundingLedger()
+	l.RoundingTolerance = 1
+	l.RoundingAccount = "Rounding"
+	if err := l.Fill(); err != nil {
+		t.Fatalf("Fill() with rounding account: %v", err)
+	}
+	rounding := l.Accounts[2]
+	if len(rounding.Splits) != 1 || rounding.Splits[0].Value.Amount != -1 {
+		t.Errorf("rounding account splits = %v", rounding.Splits)
+	}
+	if b := l.GetBalance(rounding, time.Time{}); len(b) != 1 || b[0].Amount != -1 {
+		t.Errorf("rounding account balance = %v", b)
+	}
+}
+
+func TestInterpolatePrices(t *testing.T) {
+	eur := &Currency{Name: "EUR", Decimal: ".", Precision: 2}
+	usd := &Currency{Name: "USD", Decimal: ".", Precision: 2}
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := &Ledger{
+		Currencies: []*Currency{eur, usd},
+		Prices: []*Price{
+			{Time: start, Currency: eur, Value: Value{Amount: 1 * U, Currency: usd}},
+			{Time: start.AddDate(0, 0, 10), Currency: eur, Value: Value{Amount: 2 * U, Currency: usd}},
+		},
+		Comments: make(map[interface{}][]string),
+	}
+	l.InterpolatePrices()
+	if len(l.Prices) != 11 {
+		t.Fatalf("InterpolatePrices: got %d prices (expected 11)", len(l.Prices))
+	}
+	for i, p := range l.Prices {
+		if want := start.AddDate(0, 0, i); !p.Time.Equal(want) {
+			t.Errorf("price %d: time %v (expected %v)", i, p.Time, want)
+		}
+	}
+	if p := l.Prices[5]; p.Value.Amount != 1.5*U || l.Comments[p][0] != "interpolated" {
+		t.Errorf("price 5 = %s %v", p.Value, l.Comments[p])
+	}
+}
+
+func TestBalanceNegate(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	usd := &Currency{Name: "USD"}
+	b := Balance{{Amount: 10 * U, Currency: eur}, {Amount: -3 * U, Currency: usd}}
+	n := b.Negate()
+	if len(n) != len(b) {
+		t.Fatalf("Negate(%v) = %v", b, n)
+	}
+	for i := range b {
+		if n[i].Currency != b[i].Currency || n[i].Amount != -b[i].Amount {
+			t.Errorf("Negate(%v)[%d] = %v", b, i, n[i])
+		}
+	}
+	nn := n.Negate()
+	for i := range b {
+		if nn[i] != b[i] {
+			t.Errorf("Negate(Negate(%v)) = %v", b, nn)
+		}
+	}
+	if b[0].Amount != 10*U {
+		t.Errorf("Negate modified the original balance")
+	}
+}
+
+func TestBalanceZero(t *testing.T) {
+	eur := &Currency{Name: "EUR", Decimal: ".", Precision: 2}
+	dollar := &Currency{Name: "$", Decimal: ".", Precision: 2, PrintBefore: true, WithoutSpace: true}
+	var b Balance
+	b.Add(Value{Amount: 0, Currency: eur})
+	b.Add(Value{Amount: 0, Currency: dollar})
+	if len(b) != 0 {
+		t.Errorf("Add(zero) = %v (expected an empty balance)", []Value(b))
+	}
+	b.Add(Value{Amount: 5 * U, Currency: dollar})
+	b.Sub(Value{Amount: 5 * U, Currency: dollar})
+	if len(b) != 0 {
+		t.Errorf("Add(5)+Sub(5) = %v (expected an empty balance)", []Value(b))
+	}
+	if s := b.String(); s != "0" {
+		t.Errorf("String(zero balance) = %q (expected \"0\")", s)
+	}
+}
+
+func TestCommodities(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	aapl := &Currency{Name: "AAPL"}
+	a := &Account{Name: "Broker"}
+	a.Splits = []*Split{
+		{Account: a, Value: Value{Amount: 100 * U, Currency: eur}},
+		{Account: a, Value: Value{Amount: 1 * U, Currency: aapl}},
+		{Account: a, Value: Value{Amount: -1 * U, Currency: aapl}},
+		{Account: a, Value: Value{Amount: 5 * U, Currency: eur}},
+	}
+	c := a.Commodities()
+	if len(c) != 2 || c[0] != eur || c[1] != aapl {
+		t.Errorf("Commodities() = %v (expected [EUR AAPL])", c)
+	}
+}
+
+func TestValueFloat64(t *testing.T) {
+	v := Value{Amount: -1234 * U / 100}
+	if f := v.Float64(); f != -12.34 {
+		t.Errorf("Float64(%d) = %v (expected -12.34)", v.Amount, f)
+	}
+	if r := v.Rat(); r.Cmp(big.NewRat(-1234, 100)) != 0 {
+		t.Errorf("Rat(%d) = %v (expected -617/50)", v.Amount, r)
+	}
+}
+
+func TestValueCmp(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	usd := &Currency{Name: "USD"}
+	tests := []struct {
+		v1, v2   Value
+		expected int
+	}{
+		{Value{Amount: U, Currency: eur}, Value{Amount: U, Currency: eur}, 0},
+		{Value{Amount: U, Currency: eur}, Value{Amount: U + 1, Currency: eur}, -1},
+		{Value{Amount: -U, Currency: eur}, Value{Amount: -2 * U, Currency: eur}, 1},
+		{Value{Amount: 5 * U, Currency: eur}, Value{Amount: U, Currency: usd}, -1},
+		{Value{Amount: U, Currency: usd}, Value{Amount: 5 * U, Currency: eur}, 1},
+		{Value{Amount: U}, Value{Amount: U, Currency: eur}, -1},
+		{Value{Amount: U}, Value{Amount: U}, 0},
+	}
+	for _, test := range tests {
+		if got := test.v1.Cmp(test.v2); got != test.expected {
+			t.Errorf("%v.Cmp(%v) = %d (expected %d)", test.v1, test.v2, got, test.expected)
+		}
+	}
+}
+
+func TestBalanceTotalByCurrency(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	usd := &Currency{Name: "USD"}
+	b := Balance{{Amount: 3 * U, Currency: eur}, {Amount: U, Currency: usd}, {Amount: -U, Currency: eur}}
+	if v := b.TotalByCurrency(eur); v.Amount != 2*U || v.Currency != eur {
+		t.Errorf("TotalByCurrency(EUR) = %v (expected 2 EUR)", v)
+	}
+	if v := b.TotalByCurrency(&Currency{Name: "GBP"}); v.Amount != 0 {
+		t.Errorf("TotalByCurrency(GBP) = %v (expected 0)", v)
+	}
+}
+
+func TestValueRound(t *testing.T) {
+	eur := &Currency{Name: "EUR", Precision: 2}
+	jpy := &Currency{Name: "JPY"}
+	big := &Currency{Name: "BIG", Precision: -3}
+	tests := []struct {
+		amount   int64
+		currency *Currency
+		expected int64
+	}{
+		{1234 * U / 100, eur, 1234 * U / 100},
+		{1234*U/100 + U/200, eur, 1234 * U / 100},     // 12.345 -> 12.34 (to even)
+		{1235*U/100 + U/200, eur, 1236 * U / 100},     // 12.355 -> 12.36 (to even)
+		{1234*U/100 + U/200 + 1, eur, 1235 * U / 100}, // just above the half
+		{1235*U/100 + U/200 - 1, eur, 1235 * U / 100}, // just below the half
+		{-(1234*U/100 + U/200), eur, -1234 * U / 100},
+		{-(1235*U/100 + U/200), eur, -1236 * U / 100},
+		{-(1234*U/100 + U/200 + 1), eur, -1235 * U / 100},
+		{U / 2, jpy, 0},
+		{3 * U / 2, jpy, 2 * U},
+		{3*U/2 - 1, jpy, U},
+		{2500 * U, big, 2000 * U},
+		{2500*U + 1, big, 3000 * U},
+		{3500 * U, big, 4000 * U},
+		{1, nil, 1},
+	}
+	for _, test := range tests {
+		v := Value{Amount: test.amount, Currency: test.currency}
+		if got := v.Round(); got.Amount != test.expected || got.Currency != test.currency {
+			t.Errorf("Round(%d) = %d (expected %d)", test.amount, got.Amount, test.expected)
+		}
+	}
+	if got := (Value{Amount: 1 << 62, Currency: &Currency{Precision: -18}}).Round(); got.Amount != 0 {
+		t.Errorf("Round with precision -18 = %d (expected 0)", got.Amount)
+	}
+}
+
+func TestBalanceSorted(t *testing.T) {
+	eur := &Currency{Name: "EUR", Decimal: "."}
+	usd := &Currency{Name: "USD", Decimal: "."}
+	aapl := &Currency{Name: "AAPL", Decimal: "."}
+	var b1, b2 Balance
+	b1.Add(Value{Amount: U, Currency: usd})
+	b1.Add(Value{Amount: U, Currency: eur})
+	b1.Add(Value{Amount: U, Currency: aapl})
+	b2.Add(Value{Amount: U, Currency: aapl})
+	b2.Add(Value{Amount: U, Currency: usd})
+	b2.Add(Value{Amount: U, Currency: eur})
+	b2.Sub(Value{Amount: U, Currency: aapl}) // moves EUR before USD
+	b2.Add(Value{Amount: U, Currency: aapl})
+	if s1, s2 := b1.String(), b2.String(); s1 != s2 || s1 != "1 AAPL, 1 EUR, 1 USD" {
+		t.Errorf("String() = %q and %q (expected \"1 AAPL, 1 EUR, 1 USD\")", s1, s2)
+	}
+	if b1[0].Currency != usd {
+		t.Errorf("Sorted() modified the original balance")
+	}
+}
+
+func TestBalanceEqual(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	usd := &Currency{Name: "USD"}
+	aapl := &Currency{Name: "AAPL"}
+	tests := []struct {
+		b1, b2 Balance
+		equal  bool
+	}{
+		{nil, Balance{}, true},
+		{Balance{{Amount: 0, Currency: eur}}, nil, true},
+		{
+			Balance{{Amount: U, Currency: eur}, {Amount: 2 * U, Currency: usd}, {Amount: -3 * U, Currency: aapl}},
+			Balance{{Amount: -3 * U, Currency: aapl}, {Amount: U, Currency: eur}, {Amount: 2 * U, Currency: usd}},
+			true,
+		},
+		{
+			Balance{{Amount: U, Currency: eur}, {Amount: 2 * U, Currency: usd}},
+			Balance{{Amount: 2 * U, Currency: usd}, {Amount: U, Currency: eur}, {Amount: 0, Currency: aapl}},
+			true,
+		},
+		{
+			Balance{{Amount: U, Currency: eur}, {Amount: 2 * U, Currency: usd}},
+			Balance{{Amount: 2 * U, Currency: eur}, {Amount: U, Currency: usd}},
+			false,
+		},
+		{
+			Balance{{Amount: U, Currency: eur}},
+			Balance{{Amount: U, Currency: eur}, {Amount: U, Currency: usd}},
+			false,
+		},
+	}
+	for _, test := range tests {
+		if got := test.b1.Equal(test.b2); got != test.equal {
+			t.Errorf("Equal(%v, %v) = %v (expected %v)", test.b1, test.b2, got, test.equal)
+		}
+		if got := test.b2.Equal(test.b1); got != test.equal {
+			t.Errorf("Equal(%v, %v) = %v (expected %v)", test.b2, test.b1, got, test.equal)
+		}
+	}
+}
+
+func TestTrimTo(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	cash := &Account{Name: "Cash"}
+	food := &Account{Name: "Food"}
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+	l := &Ledger{Accounts: []*Account{cash, food}, Currencies: []*Currency{eur}}
+	for d := 1; d <= 3; d++ {
+		l.Transactions = append(l.Transactions, &Transaction{
+			Time: day(d),
+			Splits: []*Split{
+				{Account: food, Value: Value{Amount: int64(d) * U, Currency: eur}},
+				{Account: cash, Value: Value{Amount: -int64(d) * U, Currency: eur}},
+			},
+		})
+	}
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	l.TrimTo(day(2), day(2))
+	if len(l.Transactions) != 1 || !l.Transactions[0].Time.Equal(day(2)) {
+		t.Errorf("TrimTo: transactions = %v", l.Transactions)
+	}
+	if len(food.Splits) != 1 || food.Splits[0].Value.Amount != 2*U {
+		t.Errorf("TrimTo: splits = %v", food.Splits)
+	}
+	if len(food.StartBalance) != 1 || food.StartBalance[0].Amount != 1*U {
+		t.Errorf("TrimTo: start balance = %v (expected 1)", food.StartBalance)
+	}
+	if b := food.Splits[0].Balance; len(b) != 1 || b[0].Amount != 3*U {
+		t.Errorf("TrimTo: balance = %v (expected 3)", b)
+	}
+}
+
+func TestHasPostingsInInterval(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+	a := &Account{Name: "Cash"}
+	for _, d := range []int{3, 5, 5, 9} {
+		when := day(d)
+		a.Splits = append(a.Splits, &Split{Time: &when})
+	}
+	tests := []struct {
+		start, end time.Time
+		expected   bool
+	}{
+		{time.Time{}, time.Time{}, true},
+		{day(1), day(2), false},
+		{day(1), day(3), true},
+		{day(4), day(4), false},
+		{day(5), day(5), true},
+		{day(6), day(8), false},
+		{day(9), time.Time{}, true},
+		{day(10), time.Time{}, false},
+		{time.Time{}, day(2), false},
+		{time.Time{}, day(4), true},
+	}
+	for _, test := range tests {
+		if got := a.HasPostingsInInterval(test.start, test.end); got != test.expected {
+			t.Errorf("HasPostingsInInterval(%s, %s) = %v (expected %v)", test.start, test.end, got, test.expected)
+		}
+	}
+	if (&Account{}).HasPostingsInInterval(time.Time{}, time.Time{}) {
+		t.Errorf("HasPostingsInInterval of an account without splits = true (expected false)")
+	}
+}
+
+func TestNewIDs(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	l := new(Ledger)
+	assets, err := l.NewAccount(Account{Name: "Assets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cash, _ := l.NewAccount(Account{Name: "Cash", Parent: assets})
+	food, _ := l.NewAccount(Account{Name: "Food", ID: SeqID(100)})
+	if cash.Level != 1 || len(assets.Children) != 1 || assets.Children[0] != cash {
+		t.Errorf("NewAccount did not link Cash to its parent")
+	}
+	if food.ID != SeqID(100) {
+		t.Errorf("NewAccount changed an existing ID to %v", food.ID)
+	}
+	for i, ids := range [][]ID{{assets.ID, SeqID(1)}, {cash.ID, SeqID(2)}} {
+		if ids[0] != ids[1] {
+			t.Errorf("account %d: ID = %v (expected %v)", i, ids[0], ids[1])
+		}
+	}
+	if got := l.Account(SeqID(2)); got != cash {
+		t.Errorf("Account(2) = %v (expected Cash)", got)
+	}
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+	transaction := func(d int) Transaction {
+		return Transaction{Time: day(d), Splits: []*Split{
+			{Account: food, Value: Value{Amount: U, Currency: eur}},
+			{Account: cash, Value: Value{Amount: -U, Currency: eur}},
+		}}
+	}
+	t2, _ := l.NewTransaction(transaction(2))
+	t1, err := l.NewTransaction(transaction(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Transactions) != 2 || l.Transactions[0] != t1 || l.Transactions[1] != t2 {
+		t.Errorf("NewTransaction did not keep Transactions sorted")
+	}
+	if t1.ID.String() != "6" || t1.Splits[0].ID.String() != "7" || t1.Splits[1].Transaction != t1 {
+		t.Errorf("NewTransaction: ID = %v, split ID = %v (expected 6 and 7)", t1.ID, t1.Splits[0].ID)
+	}
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	if b := cash.Splits[1].Balance; len(b) != 1 || b[0].Amount != -2*U {
+		t.Errorf("balance after Fill = %v (expected -2)", b)
+	}
+}
+
+func TestNewAllocatedTransaction(t *testing.T) {
+	eur := &Currency{Name: "EUR", Precision: 2}
+	l := &Ledger{Currencies: []*Currency{eur}}
+	bank, _ := l.NewAccount(Account{Name: "Bank"})
+	rent, _ := l.NewAccount(Account{Name: "Rent"})
+	power, _ := l.NewAccount(Account{Name: "Power"})
+	water, _ := l.NewAccount(Account{Name: "Water"})
+	bill := func(amount int64) Transaction {
+		return Transaction{
+			Time:   time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+			Splits: []*Split{{Account: bank, Value: Value{Amount: amount, Currency: eur}}},
+		}
+	}
+	tests := []struct {
+		amount      int64
+		allocations []Allocation
+		expected    []int64
+	}{
+		{-100 * U, []Allocation{{rent, 0.6}, {power, 0.4}}, []int64{60 * U, 40 * U}},
+		{-100 * U, []Allocation{{rent, 1.0 / 3}, {power, 1.0 / 3}, {water, 1.0 / 3}}, []int64{3334 * U / 100, 3333 * U / 100, 3333 * U / 100}},
+		{-1 * U / 100, []Allocation{{rent, 0.5}, {power, 0.5}}, []int64{0, 1 * U / 100}},
+		{-10 * U, []Allocation{{rent, 0.25}, {power, 0.7}, {water, 0.05}}, []int64{250 * U / 100, 700 * U / 100, 50 * U / 100}},
+		// too big to be split with float64 without errors:
+		{-659315592393 * U / 100, []Allocation{{rent, 0.5}, {power, 0.5}}, []int64{329657796196 * U / 100, 329657796197 * U / 100}},
+		{-5 * U / 100, []Allocation{{rent, 0.7}, {power, 0.3}}, []int64{3 * U / 100, 2 * U / 100}},
+	}
+	for _, test := range tests {
+		tr, err := l.NewAllocatedTransaction(bill(test.amount), test.allocations)
+		if err != nil {
+			t.Errorf("NewAllocatedTransaction(%d, %v): %v", test.amount, test.allocations, err)
+			continue
+		}
+		var sum int64
+		for i, s := range tr.Splits {
+			sum += s.Value.Amount
+			if i > 0 && s.Value.Amount != test.expected[i-1] {
+				t.Errorf("NewAllocatedTransaction(%d, %v): split %d = %d (expected %d)", test.amount, test.allocations, i, s.Value.Amount, test.expected[i-1])
+			}
+		}
+		if sum != 0 {
+			t.Errorf("NewAllocatedTransaction(%d, %v): splits add up to %d (expected 0)", test.amount, test.allocations, sum)
+		}
+	}
+	// with a negative precision, the amounts are rounded to hundreds:
+	jpy := &Currency{Name: "JPY", Precision: -2}
+	l.Currencies = append(l.Currencies, jpy)
+	tr := bill(-1000 * U)
+	tr.Splits[0].Value.Currency = jpy
+	if tr, err := l.NewAllocatedTransaction(tr, []Allocation{{rent, 1.0 / 3}, {power, 1.0 / 3}, {water, 1.0 / 3}}); err != nil {
+		t.Errorf("NewAllocatedTransaction(-1000 JPY): %v", err)
+	} else if a := [3]int64{tr.Splits[1].Value.Amount, tr.Splits[2].Value.Amount, tr.Splits[3].Value.Amount}; a != [3]int64{400 * U, 300 * U, 300 * U} {
+		t.Errorf("NewAllocatedTransaction(-1000 JPY) = %v (expected 400, 300 and 300 JPY)", a)
+	}
+	if _, err := l.NewAllocatedTransaction(bill(-U), []Allocation{{rent, 0.5}, {power, 0.4}}); err == nil {
+		t.Errorf("NewAllocatedTransaction with fractions adding up to 0.9 did not fail")
+	}
+	if err := l.Fill(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCost(t *testing.T) {
+	usd := &Currency{Name: "USD"}
+	aapl := &Currency{Name: "AAPL"}
+	buy := &Split{Value: Value{Amount: 10 * U, Currency: aapl}}
+	sell := &Split{Value: Value{Amount: -5 * U, Currency: aapl}}
+	none := &Split{Value: Value{Amount: 1 * U, Currency: usd}}
+	l := &Ledger{SplitPrices: map[*Split]Value{
+		buy:  {Amount: 1000 * U, Currency: usd}, // 10 AAPL @ $100
+		sell: {Amount: 600 * U, Currency: usd},  // -5 AAPL @@ $600
+	}}
+	if v, ok := l.Cost(buy); !ok || v.Amount != 1000*U || v.Currency != usd {
+		t.Errorf("Cost(buy) = %v, %v", v, ok)
+	}
+	if v, ok := l.Cost(sell); !ok || v.Amount != -600*U || v.Currency != usd {
+		t.Errorf("Cost(sell) = %v, %v", v, ok)
+	}
+	if _, ok := l.Cost(none); ok {
+		t.Errorf("Cost(none): expected no price")
+	}
+}
+
+func TestCloneComments(t *testing.T) {
+	l := roundingLedger()
+	for _, s := range l.Transactions[0].Splits {
+		s.Transaction = l.Transactions[0]
+	}
+	food := l.Accounts[1]
+	l.Comments = map[interface{}][]string{
+		food:                            {"alias:Groceries"},
+		l.Transactions[0]:               {"paid in cash"},
+		l.Transactions[0].Splits[0]:     {"tag:value"},
+		"not a pointer to a ledger obj": {"kept"},
+	}
+	c := l.Clone()
+	if got := c.Comments[c.Accounts[1]]; len(got) != 1 || got[0] != "alias:Groceries" {
+		t.Errorf("Clone: account comments = %v", got)
+	}
+	if got := c.Comments[c.Transactions[0]]; len(got) != 1 || got[0] != "paid in cash" {
+		t.Errorf("Clone: transaction comments = %v", got)
+	}
+	if got := c.Comments[c.Transactions[0].Splits[0]]; len(got) != 1 || got[0] != "tag:value" {
+		t.Errorf("Clone: split comments = %v", got)
+	}
+	if _, ok := c.Comments[food]; ok {
+		t.Errorf("Clone: comments still refer to the original account")
+	}
+}
+
+func TestCheckSplitTimes(t *testing.T) {
+	l := roundingLedger()
+	tr := l.Transactions[0]
+	near := tr.Time.AddDate(0, 0, 10)
+	far := tr.Time.AddDate(1, 0, 0)
+	tr.Splits[0].Time = &near
+	tr.Splits[1].Time = &tr.Time
+	if w := l.CheckSplitTimes(90 * 24 * time.Hour); len(w) != 0 {
+		t.Errorf("CheckSplitTimes: unexpected warnings %v", w)
+	}
+	tr.Splits[0].Time = &far
+	if w := l.CheckSplitTimes(90 * 24 * time.Hour); len(w) != 1 {
+		t.Errorf("CheckSplitTimes: got %d warnings (expected 1)", len(w))
+	}
+}
+
+func TestTagValue(t *testing.T) {
+	tr := &Transaction{Description: "Taxi"}
+	s1 := &Split{Transaction: tr}
+	s2 := &Split{Transaction: tr}
+	l := &Ledger{Comments: map[interface{}][]string{
+		tr: {"shared ride", "project: home"},
+		s2: {"project:work"},
+	}}
+	tests := []struct {
+		obj   interface{}
+		name  string
+		value string
+		ok    bool
+	}{
+		{tr, "project", "home", true},
+		{s1, "project", "home", true}, // inherited from the transaction
+		{s2, "project", "work", true}, // the split's own tag takes precedence
+		{s1, "client", "", false},
+	}
+	for _, test := range tests {
+		value, ok := l.TagValue(test.obj, test.name)
+		if value != test.value || ok != test.ok {
+			t.Errorf("TagValue(%v, %q) = %q, %t (expected %q, %t)", test.obj, test.name, value, ok, test.value, test.ok)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	btc := &Currency{Name: "BTC"}
+	v := Value{Amount: 1e10 * U, Currency: eur}
+	if err := v.Mul(Value{Amount: 1e3 * U}); err != ErrOverflow || v.Amount != 1e10*U {
+		t.Errorf("Mul = %v, %v (expected ErrOverflow and no change)", v, err)
+	}
+	if err := v.Mul(Value{Amount: U / 2}); err != nil || v.Amount != 5e9*U {
+		t.Errorf("Mul = %v, %v (expected 5e9 EUR)", v, err)
+	}
+
+	when := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := &Ledger{Prices: []*Price{{Time: when, Currency: btc, Value: Value{Amount: 50000 * U, Currency: eur}}}}
+	if _, err := l.Convert(Value{Amount: 1e7 * U, Currency: btc}, when, eur); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Convert = %v (expected ErrOverflow)", err)
+	}
+
+	l = &Ledger{}
+	bank, _ := l.NewAccount(Account{Name: "Bank"})
+	equity, _ := l.NewAccount(Account{Name: "Equity"})
+	for i := 0; i < 2; i++ {
+		l.NewTransaction(Transaction{
+			Time: when,
+			Splits: []*Split{
+				{Account: bank, Value: Value{Amount: 5e10 * U, Currency: eur}},
+				{Account: equity, Value: Value{Amount: -5e10 * U, Currency: eur}},
+			},
+		})
+	}
+	var te *TransactionError
+	if err := l.Fill(); !errors.Is(err, ErrOverflow) || !errors.As(err, &te) || te.Transaction != l.Transactions[1] {
+		t.Errorf("Fill = %v (expected ErrOverflow in the second transaction)", err)
+	}
+}
+
+func TestNotes(t *testing.T) {
+	tr := &Transaction{Description: "Taxi"}
+	s := &Split{Transaction: tr}
+	l := &Ledger{Comments: map[interface{}][]string{
+		tr: {"shared ride", "project: home", "Note: to the airport", "see http://example.com"},
+	}}
+	notes := l.Notes(tr)
+	if strings.Join(notes, "|") != "shared ride|Note: to the airport|see http://example.com" {
+		t.Errorf("Notes(transaction) = %q (expected the comments which are not tags)", notes)
+	}
+	if notes := l.Notes(s); notes != nil {
+		t.Errorf("Notes(split) = %q (expected none)", notes)
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		value    Value
+		expected string
+	}{
+		{Value{Amount: -1234567 * U / 100, Currency: &Currency{Name: "EUR", Thousand: ".", Decimal: ",", Precision: 2}}, "-12.345,67"},
+		{Value{Amount: 5 * U, Currency: &Currency{Name: "$", PrintBefore: true, WithoutSpace: true, Decimal: ".", Precision: 2}}, "5.00"},
+		{Value{Amount: 3 * U}, "3"},
+	}
+	for _, test := range tests {
+		if got := test.value.AmountString(); got != test.expected {
+			t.Errorf("AmountString(%s) = %q (expected %q)", test.value, got, test.expected)
+		}
+	}
+}
+
+func TestAddPrice(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	var l Ledger
+	for i, d := range []int{3, 1, 2, 3, 0} {
+		l.AddPrice(&Price{Time: day(d), Value: Value{Amount: int64(i)}})
+	}
+	l.AddPrices([]*Price{{Time: day(2)}, {Time: day(1)}})
+	expected := []int{0, 1, 1, 2, 2, 3, 3}
+	if len(l.Prices) != len(expected) {
+		t.Fatalf("len(Prices) = %d (expected %d)", len(l.Prices), len(expected))
+	}
+	for i, d := range expected {
+		if !l.Prices[i].Time.Equal(day(d)) {
+			t.Errorf("Prices[%d].Time = %s (expected %s)", i, l.Prices[i].Time, day(d))
+		}
+	}
+	if l.Prices[5].Value.Amount != 0 || l.Prices[6].Value.Amount != 3 {
+		t.Errorf("AddPrice did not keep prices with the same time in order")
+	}
+}
+
+func TestTransactionsBetween(t *testing.T) {
+	checking := &Account{Name: "Checking"}
+	savings := &Account{Name: "Savings"}
+	food := &Account{Name: "Food"}
+	transaction := func(accounts ...*Account) *Transaction {
+		tr := new(Transaction)
+		for _, a := range accounts {
+			tr.Splits = append(tr.Splits, &Split{Account: a})
+		}
+		return tr
+	}
+	t1 := transaction(checking, savings)
+	t2 := transaction(checking, food)
+	t3 := transaction(savings, checking, food)
+	l := &Ledger{Transactions: []*Transaction{t1, t2, t3}}
+	got := l.TransactionsBetween(checking, savings)
+	if len(got) != 2 || got[0] != t1 || got[1] != t3 {
+		t.Errorf("TransactionsBetween(checking, savings) = %v (expected [t1 t3])", got)
+	}
+	if got := l.TransactionsBetween(savings, &Account{}); len(got) != 0 {
+		t.Errorf("TransactionsBetween with an unused account = %v (expected [])", got)
+	}
+}
+
+func TestSubtreeBalance(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	expense := &Account{Name: "Expense"}
+	food := &Account{Name: "Food", Parent: expense}
+	fuel := &Account{Name: "Fuel", Parent: expense}
+	expense.Children = []*Account{food, fuel}
+	food.Splits = []*Split{
+		{Balance: Balance{{Amount: 10 * U, Currency: eur}}},
+		{Balance: Balance{{Amount: 25 * U, Currency: eur}}},
+	}
+	fuel.StartBalance = Balance{{Amount: 40 * U, Currency: eur}}
+	expected := Balance{{Amount: 65 * U, Currency: eur}}
+	if got := expense.SubtreeBalance(); !got.Equal(expected) {
+		t.Errorf("SubtreeBalance() = %s (expected %s)", got, expected)
+	}
+	if got := food.SubtreeBalance(); !got.Equal(Balance{{Amount: 25 * U, Currency: eur}}) {
+		t.Errorf("food SubtreeBalance() = %s (expected 25 EUR)", got)
+	}
+}
+
+func TestNoAutomaticPrices(t *testing.T) {
+	exchange := func() *Ledger {
+		eur := &Currency{Name: "EUR", Precision: 2}
+		usd := &Currency{Name: "USD", Precision: 2}
+		a := &Account{Name: "A"}
+		b := &Account{Name: "B"}
+		return &Ledger{
+			Accounts:   []*Account{a, b},
+			Currencies: []*Currency{eur, usd},
+			Transactions: []*Transaction{{
+				Time: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+				Splits: []*Split{
+					{Account: a, Value: Value{Amount: 10 * U, Currency: eur}},
+					{Account: b, Value: Value{Amount: -12 * U, Currency: usd}},
+				},
+			}},
+		}
+	}
+	l := exchange()
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Prices) != 2 {
+		t.Errorf("Fill() added %d prices (expected 2)", len(l.Prices))
+	}
+	l = exchange()
+	WithoutAutomaticPrices()(l)
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Prices) != 0 {
+		t.Errorf("Fill() without automatic prices added %d prices (expected 0)", len(l.Prices))
+	}
+	if _, err := l.Convert(l.Transactions[0].Splits[0].Value, l.Transactions[0].Time, l.Currencies[1]); err == nil {
+		t.Errorf("Convert() without prices: expected failure")
+	}
+}
+
+func TestSplitCount(t *testing.T) {
+	a := &Account{Name: "A"}
+	l := &Ledger{Transactions: []*Transaction{
+		{Splits: []*Split{{Account: a}, {Account: a}, {Account: &TransferAccount}}},
+		{Splits: []*Split{{Account: a}, {Account: a}, {Account: a}}},
+	}}
+	if got := l.SplitCount(); got != 5 {
+		t.Errorf("SplitCount() = %d (expected 5)", got)
+	}
+}
+
+func TestNaturalSign(t *testing.T) {
+	liabilities := &Account{Name: "Liabilities", Type: Liability}
+	for _, test := range []struct {
+		account  *Account
+		expected int
+	}{
+		{&Account{Name: "Bank", Type: Asset}, 1},
+		{&Account{Name: "Card", Parent: liabilities}, -1},
+		{&Account{Name: "Salary", Type: Income}, -1},
+		{&Account{Name: "Food", Type: Expense}, 1},
+		{&Account{Name: "Opening", Type: Equity}, -1},
+		{&Account{Name: "Untyped"}, 1},
+	} {
+		if got := test.account.NaturalSign(); got != test.expected {
+			t.Errorf("%s: NaturalSign() = %d (expected %d)", test.account.FullName(), got, test.expected)
+		}
+	}
+}
+
+func TestNetWorthSeries(t *testing.T) {
+	eur := &Currency{Name: "EUR", Precision: 2}
+	usd := &Currency{Name: "USD", Precision: 2}
+	gbp := &Currency{Name: "GBP", Precision: 2}
+	bank := &Account{Name: "Bank"}
+	equity := &Account{Name: "Equity"}
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	deposit := func(d int, v Value) *Transaction {
+		return &Transaction{Time: day(d), Splits: []*Split{
+			{Account: bank, Value: v},
+			{Account: equity, Value: v.Negate()},
+		}}
+	}
+	l := &Ledger{
+		Accounts:   []*Account{bank, equity},
+		Currencies: []*Currency{eur, usd, gbp},
+		Transactions: []*Transaction{
+			deposit(1, Value{Amount: 100 * U, Currency: eur}),
+			deposit(3, Value{Amount: 10 * U, Currency: usd}),
+			deposit(5, Value{Amount: 1 * U, Currency: gbp}),
+		},
+		Prices: []*Price{{Time: day(1), Currency: usd, Value: Value{Amount: U / 2, Currency: eur}}},
+	}
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	series, err := l.NetWorthSeries([]*Account{bank}, day(0), day(5), 24*time.Hour, eur)
+	if err == nil {
+		t.Errorf("NetWorthSeries: expected an error converting GBP")
+	}
+	expected := []int64{0, 100, 100, 105, 105, 105}
+	if len(series) != len(expected) {
+		t.Fatalf("NetWorthSeries: got %d points (expected %d)", len(series), len(expected))
+	}
+	for i, p := range series {
+		if !p.Time.Equal(day(i)) || p.Value.Amount != expected[i]*U || p.Value.Currency != eur {
+			t.Errorf("NetWorthSeries[%d] = %s %s (expected %s %d EUR)", i, p.Time, p.Value, day(i), expected[i])
+		}
+	}
+}
+
+func TestNegativePrecision(t *testing.T) {
+	c := &Currency{Name: "EUR", Thousand: ",", Precision: -3, Suffix: "k"}
+	for _, test := range []struct {
+		amount   int64
+		expected string
+	}{
+		{12_000 * U, "12k EUR"},
+		{12_499 * U, "12k EUR"},
+		{12_500 * U, "13k EUR"},
+		{-12_500 * U, "-13k EUR"},
+		{1_234_567_890 * U, "1,234,568k EUR"},
+		{-400 * U, "0k EUR"},
+	} {
+		v := Value{Amount: test.amount, Currency: c}
+		if got := v.String(); got != test.expected {
+			t.Errorf("Value(%d).String() = %q (expected %q)", test.amount/U, got, test.expected)
+		}
+	}
+	v := Value{Amount: 12_345 * U, Currency: c}
+	if got := v.FullString(); got != "12,345 EUR" {
+		t.Errorf("FullString() = %q (expected \"12,345 EUR\")", got)
+	}
+}
+
+func TestSumAndAverageBalances(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	usd := &Currency{Name: "USD"}
+	periods := []Balance{
+		{{Amount: 10 * U, Currency: eur}},
+		{{Amount: 20 * U, Currency: eur}, {Amount: 3 * U, Currency: usd}},
+		{},
+	}
+	total := Balance{{Amount: 30 * U, Currency: eur}, {Amount: 3 * U, Currency: usd}}
+	if got := SumBalances(periods...); !got.Equal(total) {
+		t.Errorf("SumBalances() = %s (expected %s)", got, total)
+	}
+	average := Balance{{Amount: 10 * U, Currency: eur}, {Amount: 1 * U, Currency: usd}}
+	if got := AverageBalance(periods...); !got.Equal(average) {
+		t.Errorf("AverageBalance() = %s (expected %s)", got, average)
+	}
+	if got := AverageBalance(); len(got) != 0 {
+		t.Errorf("AverageBalance() with no balances = %s (expected 0)", got)
+	}
+}
+
+func TestStringParts(t *testing.T) {
+	eur := &Currency{Name: "EUR", Thousand: ",", Decimal: ".", Precision: 2}
+	btc := &Currency{Name: "BTC", PrintBefore: true, Decimal: ".", Precision: 0}
+	for _, test := range []struct {
+		value    Value
+		integer  string
+		fraction string
+	}{
+		{Value{Amount: 1000.5 * U, Currency: eur}, "1,000", ".50 EUR"},
+		{Value{Amount: -23.45 * U, Currency: eur}, "-23", ".45 EUR"},
+		{Value{Amount: 3 * U, Currency: btc}, "BTC 3", ""},
+	} {
+		i, f := test.value.StringParts()
+		if i != test.integer || f != test.fraction {
+			t.Errorf("StringParts(%s) = %q, %q (expected %q, %q)", test.value, i, f, test.integer, test.fraction)
+		}
+		if i+f != test.value.String() {
+			t.Errorf("StringParts(%s) does not match String()", test.value)
+		}
+	}
+}
+
+func TestFullString(t *testing.T) {
+	eur := &Currency{Name: "EUR", Decimal: ".", Precision: 2}
+	shares := &Currency{Name: "ACME", Decimal: ".", Precision: 0}
+	b := Balance{{Amount: 1.5 * U, Currency: eur}, {Amount: 2.125 * U, Currency: shares}}
+	if s := b.String(); s != "2 ACME, 1.50 EUR" {
+		t.Errorf("String() = %q (expected \"2 ACME, 1.50 EUR\")", s)
+	}
+	if s := b.FullString(); s != "2.125 ACME, 1.50 EUR" {
+		t.Errorf("FullString() = %q (expected \"2.125 ACME, 1.50 EUR\")", s)
+	}
+	if i, f := b[1].FullStringParts(); i != "2" || f != ".125 ACME" {
+		t.Errorf("FullStringParts() = %q, %q (expected \"2\", \".125 ACME\")", i, f)
+	}
+}
+
+func TestConvertIntraday(t *testing.T) {
+	eur := &Currency{Name: "EUR", Precision: 2}
+	usd := &Currency{Name: "USD", Precision: 2}
+	at := func(hour, min int, loc *time.Location) time.Time {
+		return time.Date(2020, 1, 1, hour, min, 0, 0, time.UTC).In(loc)
+	}
+	l := &Ledger{
+		Currencies: []*Currency{eur, usd},
+		Prices: []*Price{
+			{Time: at(9, 0, time.UTC), Currency: eur, Value: Value{Amount: 1 * U, Currency: usd}},
+			{Time: at(12, 0, time.UTC), Currency: eur, Value: Value{Amount: 1.3 * U, Currency: usd}},
+			{Time: at(15, 0, time.UTC), Currency: eur, Value: Value{Amount: 1.2 * U, Currency: usd}},
+		},
+	}
+	madrid := time.FixedZone("CET", 3600)
+	for _, test := range []struct {
+		when     time.Time
+		expected int64
+	}{
+		{at(9, 0, time.UTC), 1 * U},
+		{at(12, 0, time.UTC), 1.3 * U},
+		{at(12, 0, madrid), 1.3 * U}, // same instant, another location
+		{at(10, 30, time.UTC), 1.15 * U},
+		{at(13, 30, time.UTC), 1.25 * U},
+		{at(15, 0, time.UTC), 1.2 * U},
+		{at(18, 0, time.UTC), 1.2 * U},
+	} {
+		v, err := l.Convert(Value{Amount: 1 * U, Currency: eur}, test.when, usd)
+		if err != nil {
+			t.Errorf("Convert at %s: %v", test.when, err)
+			continue
+		}
+		if v.Amount != test.expected || v.Currency != usd {
+			t.Errorf("Convert at %s = %s (expected %s)", test.when, v, Value{Amount: test.expected, Currency: usd})
+		}
+	}
+}
+
+func TestGetCurrency(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	l := &Ledger{Currencies: []*Currency{eur}}
+	if c, isNew := l.GetCurrency("EUR"); c != eur || isNew {
+		t.Errorf("GetCurrency(EUR) = %p, %v (expected %p, false)", c, isNew, eur)
+	}
+	usd, isNew := l.GetCurrency("USD")
+	if !isNew || len(l.Currencies) != 2 {
+		t.Errorf("GetCurrency(USD): new=%v, %d currencies (expected true, 2)", isNew, len(l.Currencies))
+	}
+	if c, _ := l.GetCurrency("USD"); c != usd {
+		t.Errorf("GetCurrency(USD) returned two different currencies")
+	}
+	// currencies changed directly in the ledger:
+	gbp := &Currency{Name: "GBP"}
+	l.Currencies = []*Currency{gbp, eur}
+	if c, isNew := l.GetCurrency("GBP"); c != gbp || isNew {
+		t.Errorf("GetCurrency(GBP) = %p, %v (expected %p, false)", c, isNew, gbp)
+	}
+	if c, isNew := l.GetCurrency("USD"); c == usd || !isNew {
+		t.Errorf("GetCurrency(USD) after removing it: expected a new currency")
+	}
+}
+
+func TestKeepOrder(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	a := &Account{Name: "A"}
+	b := &Account{Name: "B"}
+	transaction := func(day int, description string) *Transaction {
+		return &Transaction{
+			Time:        time.Date(2020, 1, day, 12, 0, 0, 0, time.UTC),
+			Description: description,
+			Splits: []*Split{
+				{Account: a, Value: Value{Amount: U, Currency: eur}},
+				{Account: b, Value: Value{Amount: -U, Currency: eur}},
+			},
+		}
+	}
+	l := &Ledger{Accounts: []*Account{a, b}, KeepOrder: true}
+	l.Transactions = []*Transaction{transaction(1, "first"), transaction(1, "second"), transaction(2, "third")}
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range []string{"first", "second", "third"} {
+		if l.Transactions[i].Description != d {
+			t.Errorf("transaction %d = %q (expected %q)", i, l.Transactions[i].Description, d)
+		}
+	}
+	l.Transactions = []*Transaction{transaction(2, "third"), transaction(1, "first")}
+	err := l.Fill()
+	var te *TransactionError
+	if !errors.As(err, &te) || te.Transaction != l.Transactions[1] {
+		t.Errorf("Fill() with unsorted transactions = %v (expected a TransactionError)", err)
+	}
+}
+
+func TestPeriodicOccurrences(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2021, month, d, 12, 0, 0, 0, time.UTC)
+	}
+	monthly := &PeriodicTransaction{Template: Transaction{Time: time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)}, Months: 1}
+	got := monthly.Occurrences(day(2, 1), day(4, 30))
+	if len(got) != 3 || !got[0].Equal(day(2, 1)) || !got[2].Equal(day(4, 1)) {
+		t.Errorf("monthly occurrences = %v (expected Feb 1, Mar 1 and Apr 1)", got)
+	}
+	endOfMonth := &PeriodicTransaction{Template: Transaction{Time: day(1, 31)}, Months: 1}
+	got = endOfMonth.Occurrences(day(1, 1), day(5, 31))
+	if expected := []time.Time{day(1, 31), day(2, 28), day(3, 31), day(4, 30), day(5, 31)}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("monthly occurrences from Jan 31 = %v (expected %v)", got, expected)
+	}
+	weekly := &PeriodicTransaction{Template: Transaction{Time: day(1, 4)}, Days: 7, End: day(1, 31)}
+	got = weekly.Occurrences(day(1, 1), day(3, 1))
+	if len(got) != 4 || !got[3].Equal(day(1, 25)) {
+		t.Errorf("weekly occurrences = %v (expected 4, until Jan 25)", got)
+	}
+	if got := (&PeriodicTransaction{Template: Transaction{Time: day(1, 1)}}).Occurrences(day(1, 1), day(2, 1)); got != nil {
+		t.Errorf("occurrences without a period = %v (expected none)", got)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	eur := &Currency{Name: "EUR", Precision: 2}
+	l := &Ledger{Currencies: []*Currency{eur}, Comments: make(map[interface{}][]string)}
+	bank, _ := l.NewAccount(Account{Name: "Bank"})
+	salary, _ := l.NewAccount(Account{Name: "Salary"})
+	l.NewTransaction(Transaction{
+		Time: time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+		Splits: []*Split{
+			{Account: bank, Value: Value{Amount: 1000 * U, Currency: eur}},
+			{Account: salary, Value: Value{Amount: -1000 * U, Currency: eur}},
+		},
+	})
+	l.Periodic = []*PeriodicTransaction{{
+		Template: Transaction{
+			Time:        time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+			Description: "Salary",
+			Splits: []*Split{
+				{Account: bank, Value: Value{Amount: 1000 * U, Currency: eur}},
+				{Account: salary},
+			},
+		},
+		Months: 1,
+	}}
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.Forecast(time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Forecast: %v", err)
+	}
+	if len(l.Transactions) != 1 || len(bank.Splits) != 1 {
+		t.Errorf("Forecast changed the original ledger")
+	}
+	if len(f.Transactions) != 3 || f.IsForecast(f.Transactions[0]) || !f.IsForecast(f.Transactions[2]) {
+		t.Fatalf("Forecast transactions = %d (expected 1 actual and 2 forecast)", len(f.Transactions))
+	}
+	if b := f.Accounts[1].Splits[2].Balance; len(b) != 1 || b[0].Amount != -3000*U {
+		t.Errorf("projected Salary balance = %v (expected -3000 EUR)", b)
+	}
+}
@@ -1,6 +1,9 @@
 package accounting
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"time"
 )
 
@@ -20,10 +23,69 @@ type Connection interface {
 	Refresh()
 }
 
+// Watcher is implemented by the connections which can reload their data
+// as soon as it changes, as used by Ledger.Watch.
+type Watcher interface {
+	// Watch reads the data again every time it changes, until ctx is done.
+	// After every successful reload it calls onChange with a new Ledger
+	// holding the data, and the Connection to be used with it.
+	// The ledger of the Watcher must not be modified.
+	Watch(ctx context.Context, onChange func(*Ledger, Connection))
+}
+
+// FileNotifier reports changes in files.  Backends reading files use it,
+// if given with WithFileNotifier, to know when to reload them.
+// Package github.com/cespedes/accounting/notify implements it with fsnotify.
+type FileNotifier interface {
+	// Add starts watching a file or directory.
+	Add(name string) error
+	// Events returns a channel with the names of the changed files.
+	Events() <-chan string
+	// Errors returns a channel with the errors found while watching.
+	Errors() <-chan error
+	// Close stops watching all the files, and closes the channels.
+	Close() error
+}
+
 // Backend contains the Ledger and some methods to be called only by the backends.
 type Backend struct {
 	ready  bool
 	Ledger *Ledger
+
+	collect bool    // keep the errors reported by Errorf instead of logging them
+	errors  []error // errors reported by Errorf, if collect is set
+}
+
+// Errorf reports an error in the data which does not prevent reading
+// the rest of it (the wrong entry is usually skipped).
+// Errors are logged, or returned by Validate.
+// It can be called with a nil Backend.
+func (b *Backend) Errorf(format string, a ...interface{}) {
+	err := fmt.Errorf(format, a...)
+	if b != nil && b.collect {
+		b.errors = append(b.errors, err)
+		return
+	}
+	log.Print(err)
+}
+
+// Options returns the values given with WithBackendOption for a setting,
+// in the same order.  It can be called with a nil Backend.
+func (b *Backend) Options(name string) []interface{} {
+	if b == nil || b.Ledger == nil {
+		return nil
+	}
+	return b.Ledger.backendOptions[name]
+}
+
+// FileNotifier returns a new FileNotifier created by the function given
+// with WithFileNotifier, or nil if there is none.
+// It can be called with a nil Backend.
+func (b *Backend) FileNotifier() (FileNotifier, error) {
+	if b == nil || b.Ledger == nil || b.Ledger.newNotifier == nil {
+		return nil, nil
+	}
+	return b.Ledger.newNotifier()
 }
 
 // NewTransaction adds a new transaction to the ledger, updating
@@ -69,14 +131,16 @@ type ConnExtra interface {
 	TransactionsInInterval(start, end time.Time) []Transaction
 
 	// NewAccount adds a new Account in a ledger.
-	// ID field is ignored, and regenerated.
+	// ID field is ignored, and regenerated
+	// (if it is left nil, the Ledger gives it a SeqID).
 	NewAccount(a Account) (*Account, error)
 
 	// EditAccount edits an Account in a ledger.
 	// ID field must remain unchanged.
 	EditAccount(a Account) (*Account, error)
 
-	// NewTransaction adds a new Transaction in a ledger
+	// NewTransaction adds a new Transaction in a ledger.
+	// The Ledger gives a SeqID to it and its splits if they have no ID.
 	NewTransaction(t Transaction) (*Transaction, error)
 
 	// EditTransaction edits a Transaction in a ledger
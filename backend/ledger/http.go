@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cespedes/accounting"
+)
+
+// Names of the backend options for journals read from HTTP URLs.
+const (
+	optionHTTPTimeout = "ledger.http-timeout"
+	optionHTTPHeader  = "ledger.http-header"
+)
+
+// WithHTTPTimeout limits the time to fetch every journal read from
+// an "http" or "https" URL.
+func WithHTTPTimeout(d time.Duration) accounting.Option {
+	return accounting.WithBackendOption(optionHTTPTimeout, d)
+}
+
+// WithHTTPHeader adds a header (for example, "Authorization") to the
+// requests used to fetch journals from "http" or "https" URLs.
+func WithHTTPHeader(key, value string) accounting.Option {
+	return accounting.WithBackendOption(optionHTTPHeader, [2]string{key, value})
+}
+
+// httpGetter fetches journals from HTTP URLs.
+type httpGetter struct {
+	client *http.Client
+	header http.Header
+	origin *url.URL // scheme and host of the top-level journal, the only one getting header
+}
+
+// newHTTPGetter returns an httpGetter configured with the backend options,
+// for a top-level journal called top.  The headers given with WithHTTPHeader
+// are only sent to the same scheme and host as top, if it is a URL.
+func newHTTPGetter(backend *accounting.Backend, top string) *httpGetter {
+	g := &httpGetter{client: new(http.Client), header: make(http.Header)}
+	if isURL(top) {
+		g.origin, _ = url.Parse(top)
+	}
+	for _, o := range backend.Options(optionHTTPTimeout) {
+		g.client.Timeout = o.(time.Duration)
+	}
+	for _, o := range backend.Options(optionHTTPHeader) {
+		h := o.([2]string)
+		g.header.Add(h[0], h[1])
+	}
+	return g
+}
+
+// get sends a GET request to a URL and returns the response,
+// failing if its status is not 200 OK.
+func (g *httpGetter) get(u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.origin != nil && req.URL.Scheme == g.origin.Scheme && req.URL.Host == g.origin.Host {
+		for key, values := range g.header {
+			req.Header[key] = values
+		}
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+// isURL tells whether a journal name is an "http" or "https" URL.
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// resolveURL returns the URL of a file included from the journal in base.
+func resolveURL(base, name string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	u, err := b.Parse(name)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
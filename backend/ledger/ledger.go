@@ -1,9 +1,16 @@
 package ledger
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cespedes/accounting"
@@ -13,12 +20,22 @@ type driver struct{}
 
 func init() {
 	accounting.Register("ledger", driver{})
+	accounting.Register("http", driver{})
+	accounting.Register("https", driver{})
 }
 
 type ledgerConnection struct {
 	file    string
 	backend *accounting.Backend
 	ledger  *accounting.Ledger
+	marks   map[ID]string        // state marks to be written by Flush
+	mtimes  map[string]time.Time // modification times of the files read, to be checked by Refresh
+	mu      sync.Mutex           // held while refreshing
+
+	declared map[*accounting.Currency]bool // currencies with an explicit format
+	named    map[*accounting.Currency]bool // currencies named before any amount, to get the format of their first one
+	pending  []string                      // file comments not yet attached to anything
+	lastTag  *taggedComment                // tag in the previous line, which can go on in the next one
 }
 
 func (driver) Open(name string, backend *accounting.Backend) (accounting.Connection, error) {
@@ -28,9 +45,15 @@ func (driver) Open(name string, backend *accounting.Backend) (accounting.Connect
 	}
 	conn := new(ledgerConnection)
 	conn.file = url.Path
+	if url.Scheme == "http" || url.Scheme == "https" {
+		conn.file = name
+	}
 	conn.backend = backend
 	conn.ledger = backend.Ledger
-	conn.readJournal()
+	conn.marks = make(map[ID]string)
+	if err := conn.readJournal(); err != nil {
+		return nil, err
+	}
 	return conn, nil
 }
 
@@ -38,32 +61,403 @@ func (conn *ledgerConnection) Close() error {
 	return nil
 }
 
+// Refresh reads the journal again if any of its files (or the directories
+// included from it) has been modified since it was read, and fills the ledger
+// with the new data.  If the new journal cannot be read or filled, the error
+// is reported and the previous data is kept.
 func (conn *ledgerConnection) Refresh() {
-	// TODO FIXME XXX: notifier
+	conn.reload()
+}
+
+// reload does the work of Refresh, returning whether the ledger was replaced.
+func (conn *ledgerConnection) reload() bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	next := conn.next()
+	if next == nil {
+		return false
+	}
+	*conn.ledger = *next.ledger
+	conn.mtimes = next.mtimes
+	conn.declared = next.declared
+	conn.named = next.named
+	conn.pending = next.pending
+	return true
+}
+
+// next reads the journal again, if any of its files has changed, in a
+// new ledger with the same options, and returns a new connection for it.
+// It returns nil, reporting the error if any, when the journal has not
+// changed or cannot be read.  Neither conn nor its ledger are modified.
+// conn.mu must be held.
+func (conn *ledgerConnection) next() *ledgerConnection {
+	if !conn.modified() {
+		return nil
+	}
+	if len(conn.marks) > 0 {
+		conn.backend.Errorf("%s: not reloading: there are changes not yet written", conn.file)
+		return nil
+	}
+	l := *conn.ledger
+	next := &ledgerConnection{
+		file:    conn.file,
+		backend: conn.backend,
+		ledger:  &l,
+		marks:   make(map[ID]string),
+	}
+	if err := next.readJournal(); err != nil {
+		conn.backend.Errorf("%s: %v", conn.file, err)
+		return nil
+	}
+	if err := l.Fill(); err != nil {
+		conn.backend.Errorf("%s: %v", conn.file, err)
+		return nil
+	}
+	return next
+}
+
+// modified tells whether any of the local files read has changed.
+// Remote journals are always considered to be modified.
+func (conn *ledgerConnection) modified() bool {
+	if isURL(conn.file) {
+		return true
+	}
+	for name, mtime := range conn.mtimes {
+		fi, err := os.Stat(name)
+		if err != nil || !fi.ModTime().Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// EditTransaction changes the state of a transaction and its splits.
+// Changes are written to the journal when calling Flush.
+// Only state changes are supported.
+func (conn *ledgerConnection) EditTransaction(t accounting.Transaction) (*accounting.Transaction, error) {
+	id, ok := t.ID.(*ID)
+	if !ok {
+		return nil, errors.New("EditTransaction: transaction not in journal")
+	}
+	var orig *accounting.Transaction
+	for _, tr := range conn.ledger.Transactions {
+		if tid, ok := tr.ID.(*ID); ok && *tid == *id {
+			orig = tr
+			break
+		}
+	}
+	if orig == nil {
+		return nil, fmt.Errorf("EditTransaction: %s: transaction not in journal", id)
+	}
+	if !orig.Time.Equal(t.Time) || orig.Description != t.Description {
+		return nil, fmt.Errorf("EditTransaction: %s: only state changes are supported", id)
+	}
+	orig.State = t.State
+	conn.marks[*id] = stateMark(t.State)
+	for _, s := range t.Splits {
+		sid, ok := s.ID.(*ID)
+		if !ok {
+			continue
+		}
+		for _, so := range orig.Splits {
+			if soid, ok := so.ID.(*ID); ok && *soid == *sid {
+				so.State = s.State
+			}
+		}
+		conn.marks[*sid] = ""
+		if s.State != t.State {
+			conn.marks[*sid] = stateMark(s.State)
+		}
+	}
+	return orig, nil
+}
+
+// Flush writes the pending state changes to the journal files,
+// modifying only the affected lines.
+func (conn *ledgerConnection) Flush() error {
+	files := make(map[string][]ID)
+	for id := range conn.marks {
+		files[id.filename] = append(files[id.filename], id)
+	}
+	for filename, ids := range files {
+		if isURL(filename) {
+			return fmt.Errorf("%s: cannot modify a remote journal", filename)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(string(data), "\n")
+		for _, id := range ids {
+			if id.lineNum < 1 || id.lineNum > len(lines) {
+				return fmt.Errorf("%s: line out of range", id)
+			}
+			lines[id.lineNum-1] = setStateMark(lines[id.lineNum-1], conn.marks[id])
+		}
+		if err = writeFile(filename, []byte(strings.Join(lines, "\n"))); err != nil {
+			return err
+		}
+	}
+	conn.marks = make(map[ID]string)
+	return nil
+}
+
+// setStateMark replaces the state mark in a transaction or split line.
+func setStateMark(line string, mark string) string {
+	if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+		text := strings.TrimLeft(line, " \t")
+		indent := line[:len(line)-len(text)]
+		_, text, _ = getState(text)
+		return indent + mark + text
+	}
+	date, rest := firstWord(line)
+	_, rest, _ = getState(rest)
+	return date + " " + mark + rest
+}
+
+// writeFile replaces the contents of a file atomically,
+// writing a temporary file and renaming it.
+func writeFile(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// ParseJournal reads a journal from r and returns the resulting ledger,
+// already filled.  The name is used in IDs and error messages, and as the base
+// for relative "include" directives.
+// The ledger is not associated to any backend: it can be examined or exported,
+// but not refreshed or modified with Flush.
+func ParseJournal(r io.Reader, name string) (*accounting.Ledger, error) {
+	conn := ledgerConnection{
+		file:   name,
+		ledger: new(accounting.Ledger),
+		marks:  make(map[ID]string),
+	}
+	s := NewScanner()
+	s.NewReader(r, name)
+	if err := conn.parseJournal(s); err != nil {
+		return nil, err
+	}
+	if err := conn.ledger.Fill(); err != nil {
+		return nil, err
+	}
+	return conn.ledger, nil
+}
+
+// ReadPriceDB reads a file with "P" directives and merges its prices
+// into a ledger.  Prices for the same day and pair of currencies
+// replace the ones already in the ledger.
+func ReadPriceDB(ledger *accounting.Ledger, filename string) error {
+	conn := ledgerConnection{ledger: ledger, declared: make(map[*accounting.Currency]bool)}
+	for _, c := range ledger.Currencies {
+		// do not change the display precision of known currencies
+		conn.declared[c] = true
+	}
+	s := NewScanner()
+	if err := s.NewFile(filename); err != nil {
+		return err
+	}
+	var prices []*accounting.Price
+	for {
+		line := s.Line()
+		if line.Err == io.EOF {
+			break
+		}
+		if line.Err != nil {
+			return line.Err
+		}
+		text := strings.TrimSpace(line.Text)
+		if text == "" || text[0] == '#' || text[0] == ';' || text[0] == '*' {
+			continue
+		}
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		word, rest := firstWord(text)
+		if word != "P" {
+			return fmt.Errorf("%s:%d: only price directives are allowed", line.Filename, line.LineNum)
+		}
+		price, err := conn.getPrice(line, rest)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
+		}
+		prices = append(prices, price)
+	}
+
+	sameDay := func(t1, t2 time.Time) bool {
+		y1, m1, d1 := t1.Date()
+		y2, m2, d2 := t2.Date()
+		return y1 == y2 && m1 == m2 && d1 == d2
+	}
+	var merged []*accounting.Price
+	for _, p := range ledger.Prices {
+		replaced := false
+		for _, np := range prices {
+			if p.Currency == np.Currency && p.Value.Currency == np.Value.Currency && sameDay(p.Time, np.Time) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, p)
+		}
+	}
+	ledger.Prices = merged
+	ledger.AddPrices(prices)
+	return nil
+}
+
+// ParseValue returns the value represented by a string, such as "1,000.00 EUR",
+// using (and adding, if needed) the currencies in a ledger.
+func ParseValue(ledger *accounting.Ledger, s string) (accounting.Value, error) {
+	conn := ledgerConnection{ledger: ledger}
+	v, err, _ := conn.getValue(s)
+	return v, err
+}
+
+// ExportFile writes the "Ledger" representation of an accounting ledger
+// to a file, replacing it atomically.
+func ExportFile(filename string, ledger *accounting.Ledger) error {
+	var buf bytes.Buffer
+	Export(&buf, ledger)
+	return writeFile(filename, buf.Bytes())
+}
+
+// quoteCurrency returns the name of a currency, between double quotes
+// if it could not be parsed back otherwise.
+func quoteCurrency(name string) string {
+	if name == "" {
+		return name
+	}
+	if strings.ContainsAny(name, " \t;=@\"") ||
+		strings.ContainsRune("-+0123456789.,_'", rune(name[0])) ||
+		strings.ContainsRune("-+0123456789.,_'", rune(name[len(name)-1])) {
+		return `"` + name + `"`
+	}
+	return name
+}
+
+// quoteAccount returns the full name of an account, between double quotes
+// if it could not be parsed back otherwise.
+func quoteAccount(a *accounting.Account) string {
+	name := a.FullName()
+	if strings.Contains(name, "  ") || strings.ContainsAny(name, "\t;\"") {
+		return `"` + name + `"`
+	}
+	return name
+}
+
+// exportValue returns the string representation of a value,
+// quoting its currency if needed.
+func exportValue(v accounting.Value) string {
+	if v.Currency != nil {
+		if name := quoteCurrency(v.Currency.Name); name != v.Currency.Name {
+			c := *v.Currency
+			c.Name = name
+			v.Currency = &c
+		}
+	}
+	return v.FullString()
+}
+
+// periodicHeader returns the "~" line of a periodic transaction,
+// without its comments.
+func periodicHeader(p *accounting.PeriodicTransaction) string {
+	var period Period = -1
+	for i := range periodNames {
+		if months, days := Period(i).Length(); months == p.Months && days == p.Days {
+			period = Period(i)
+		}
+	}
+	header := "~ " + period.String()
+	if !p.Template.Time.Equal(periodicStart(period)) {
+		header += " from " + p.Template.Time.Format("2006-01-02/15:04")
+	}
+	if !p.End.IsZero() {
+		header += " to " + p.End.Format("2006-01-02")
+	}
+	if p.Template.Description != "" {
+		header += "  " + p.Template.Description
+	}
+	return header
+}
+
+// FormatPrice returns the "P" directive for a price, without a trailing newline.
+func FormatPrice(p *accounting.Price) string {
+	return fmt.Sprintf("P %s %s %s", p.Time.Format("2006-01-02/15:04"), quoteCurrency(p.Currency.Name), exportValue(p.Value))
+}
+
+// writeFileComments writes the top-level comments which were before obj.
+func writeFileComments(out io.Writer, ledger *accounting.Ledger, obj interface{}) {
+	for _, c := range ledger.FileComments[obj] {
+		fmt.Fprintln(out, c)
+	}
 }
 
 // Export shows the "Ledger" representation of an accounting ledger.
 func Export(out io.Writer, ledger *accounting.Ledger) {
 	// fmt.Fprintln(out, "\n; Accounts:")
+	var accounts bool
 	for _, a := range ledger.Accounts {
-		fmt.Fprintf(out, "account %s", a.FullName())
-		if len(ledger.Comments[a]) > 0 {
-			fmt.Fprintf(out, " ; %s", ledger.Comments[a][0])
+		if a.IsTransferAccount() {
+			continue
+		}
+		accounts = true
+		writeFileComments(out, ledger, a)
+		fmt.Fprintf(out, "account %s", quoteAccount(a))
+		var comments []string
+		if a.Code != "" {
+			comments = append(comments, "code:"+a.Code)
+		}
+		if a.Alias != "" {
+			comments = append(comments, "alias:"+a.Alias)
+		}
+		if a.Type != accounting.Untyped {
+			comments = append(comments, "type:"+a.Type.String())
+		}
+		if a.DefaultCurrency != nil {
+			comments = append(comments, "commodity:"+quoteCurrency(a.DefaultCurrency.Name))
+		}
+		comments = append(comments, ledger.Comments[a]...)
+		if len(comments) > 0 {
+			fmt.Fprintf(out, " ; %s", comments[0])
 		}
 		fmt.Fprint(out, "\n")
-		if len(ledger.Comments[a]) > 1 {
-			for _, c := range ledger.Comments[a][1:] {
-				fmt.Fprintf(out, "\t: %s\n", c)
+		if len(comments) > 1 {
+			for _, c := range comments[1:] {
+				fmt.Fprintf(out, "\t; %s\n", c)
 			}
 		}
 	}
-	fmt.Fprintln(out)
+	if accounts {
+		fmt.Fprintln(out)
+	}
 	// fmt.Fprintln(out, "\n; Currencies:")
 	for _, cu := range ledger.Currencies {
 		var v accounting.Value
 		v.Amount = 1_000_000 * accounting.U
 		v.Currency = cu
-		fmt.Fprintf(out, "commodity %s", v.String())
+		writeFileComments(out, ledger, cu)
+		fmt.Fprintf(out, "commodity %s", exportValue(v))
 		if len(ledger.Comments[cu]) > 0 {
 			fmt.Fprintf(out, " ; %s", ledger.Comments[cu][0])
 		}
@@ -74,7 +468,27 @@ func Export(out io.Writer, ledger *accounting.Ledger) {
 			}
 		}
 	}
-	fmt.Fprintln(out)
+	if len(ledger.Currencies) > 0 {
+		fmt.Fprintln(out)
+	}
+	// fmt.Fprintln(out, "\n; Opening balances:")
+	var opening bool
+	for _, a := range ledger.Accounts {
+		for _, v := range a.StartBalance.Sorted() {
+			fmt.Fprintf(out, "open %s  %s\n", quoteAccount(a), exportValue(v))
+			opening = true
+		}
+	}
+	if opening {
+		fmt.Fprintln(out)
+	}
+	for _, p := range ledger.Periodic {
+		writeFileComments(out, ledger, p)
+		writeTransaction(out, ledger, &p.Template, periodicHeader(p))
+	}
+	if len(ledger.Periodic) > 0 {
+		fmt.Fprintln(out)
+	}
 	// fmt.Fprintln(out, "\n; Transactions and prices:")
 	var i, j int
 	for i < len(ledger.Transactions) || j < len(ledger.Prices) {
@@ -92,44 +506,16 @@ func Export(out io.Writer, ledger *accounting.Ledger) {
 		// fmt.Fprintf(out, "DEBUG: i=%d j=%d tt=%v tp=%v\n", i, j, tt, tp)
 		if p == nil || (t != nil && !tt.After(tp)) {
 			i++
-			fmt.Fprintf(out, "%s %s", t.Time.Format("2006-01-02/15:04"), t.Description)
-			if len(ledger.Comments[t]) > 0 {
-				fmt.Fprintf(out, " ; %s", ledger.Comments[t][0])
-			}
-			fmt.Fprint(out, "\n")
-			if len(ledger.Comments[t]) > 1 {
-				for _, c := range ledger.Comments[t][1:] {
-					fmt.Fprintf(out, "\t; %s\n", c)
-				}
-			}
-			for _, s := range t.Splits {
-				fmt.Fprintf(out, "  %-50s  %s", s.Account.FullName(), s.Value.FullString())
-				if v, ok := ledger.SplitPrices[s]; ok == true {
-					fmt.Fprintf(out, " @@ %s", v.FullString())
-				}
-				if v, ok := ledger.Assertions[s]; ok == true {
-					fmt.Fprintf(out, " = %s", v.FullString())
-				}
-				var comments []string
-				if *s.Time != t.Time {
-					comments = append(comments, "date:"+s.Time.Format("2006-01-02/15:04"))
-				}
-				if len(ledger.Comments[s]) > 0 {
-					comments = append(comments, ledger.Comments[s]...)
-				}
-				if len(comments) > 0 {
-					fmt.Fprintf(out, " ; %s", comments[0])
-				}
-				fmt.Fprint(out, "\n")
-				if len(comments) > 1 {
-					for _, c := range comments[1:] {
-						fmt.Fprintf(out, "\t; %s\n", c)
-					}
-				}
-			}
+			writeFileComments(out, ledger, t)
+			writeTransaction(out, ledger, t, t.Time.Format("2006-01-02/15:04")+" "+stateMark(t.State)+t.Description)
 		} else {
 			j++
-			fmt.Fprintf(out, "P %s %s %s", p.Time.Format("2006-01-02/15:04"), p.Currency.Name, p.Value.FullString())
+			if c := ledger.Comments[p]; p.ID == nil && len(c) == 1 && c[0] == "automatic" {
+				// added by Fill, which will add it again
+				continue
+			}
+			writeFileComments(out, ledger, p)
+			fmt.Fprint(out, FormatPrice(p))
 			if len(ledger.Comments[p]) > 0 {
 				fmt.Fprintf(out, " ; %s", ledger.Comments[p][0])
 			}
@@ -141,4 +527,73 @@ func Export(out io.Writer, ledger *accounting.Ledger) {
 			}
 		}
 	}
+	writeFileComments(out, ledger, nil)
+}
+
+// writeTransaction writes the header line of a transaction (or periodic
+// transaction), its comments and its splits.
+func writeTransaction(out io.Writer, ledger *accounting.Ledger, t *accounting.Transaction, header string) {
+	fmt.Fprint(out, header)
+	var comments []string
+	if t.Payee != "" && t.Payee != descriptionPayee(t.Description) {
+		comments = append(comments, "payee:"+t.Payee)
+	}
+	comments = append(comments, ledger.Comments[t]...)
+	if len(comments) > 0 {
+		fmt.Fprintf(out, " ; %s", comments[0])
+	}
+	fmt.Fprint(out, "\n")
+	if len(comments) > 1 {
+		for _, c := range comments[1:] {
+			fmt.Fprintf(out, "\t; %s\n", c)
+		}
+	}
+	for _, s := range t.Splits {
+		if s.Account.IsTransferAccount() {
+			continue
+		}
+		var mark string
+		if s.State != t.State {
+			mark = stateMark(s.State)
+		}
+		name := quoteAccount(s.Account)
+		switch s.Virtual {
+		case accounting.BalancedVirtual:
+			name = "[" + name + "]"
+		case accounting.UnbalancedVirtual:
+			name = "(" + name + ")"
+		}
+		if s.Value.Currency != nil {
+			fmt.Fprintf(out, "  %s%-50s  %s", mark, name, exportValue(s.Value))
+		} else {
+			// to be calculated by Fill
+			fmt.Fprintf(out, "  %s%s", mark, name)
+		}
+		if v, ok := ledger.LotPrices[s]; ok == true {
+			fmt.Fprintf(out, " {{%s}}", exportValue(v))
+		}
+		if v, ok := ledger.SplitPrices[s]; ok == true {
+			fmt.Fprintf(out, " @@ %s", exportValue(v))
+		}
+		if v, ok := ledger.Assertions[s]; ok == true {
+			fmt.Fprintf(out, " = %s", exportValue(v))
+		}
+		var comments []string
+		// s.Time is nil if the ledger has not been filled:
+		if s.Time != nil && *s.Time != t.Time {
+			comments = append(comments, "date:"+s.Time.Format("2006-01-02/15:04"))
+		}
+		if len(ledger.Comments[s]) > 0 {
+			comments = append(comments, ledger.Comments[s]...)
+		}
+		if len(comments) > 0 {
+			fmt.Fprintf(out, " ; %s", comments[0])
+		}
+		fmt.Fprint(out, "\n")
+		if len(comments) > 1 {
+			for _, c := range comments[1:] {
+				fmt.Fprintf(out, "\t; %s\n", c)
+			}
+		}
+	}
 }
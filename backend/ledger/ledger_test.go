@@ -0,0 +1,1473 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cespedes/accounting"
+	_ "github.com/cespedes/accounting/backend/memory"
+)
+
+func TestReadPriceDB(t *testing.T) {
+	f, err := ioutil.TempFile("", "pricedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("; market data\nP 2021-01-01 AAPL 130.00 USD\nP 2021-01-05 AAPL 140.00 USD\n")
+	f.Close()
+
+	l := new(accounting.Ledger)
+	aapl, _ := l.GetCurrency("AAPL")
+	usd, _ := l.GetCurrency("USD")
+	l.Prices = []*accounting.Price{
+		{Time: time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC), Currency: aapl, Value: accounting.Value{Amount: 120 * accounting.U, Currency: usd}},
+		{Time: time.Date(2021, 1, 3, 12, 0, 0, 0, time.UTC), Currency: aapl, Value: accounting.Value{Amount: 125 * accounting.U, Currency: usd}},
+	}
+	if err := ReadPriceDB(l, f.Name()); err != nil {
+		t.Fatalf("ReadPriceDB: %v", err)
+	}
+	expected := []int64{130, 125, 140}
+	if len(l.Prices) != len(expected) {
+		t.Fatalf("ReadPriceDB: got %d prices (expected %d)", len(l.Prices), len(expected))
+	}
+	for i, p := range l.Prices {
+		if p.Currency != aapl || p.Value.Currency != usd || p.Value.Amount != expected[i]*accounting.U {
+			t.Errorf("ReadPriceDB: price %d = %s %s (expected %d USD)", i, p.Currency.Name, p.Value, expected[i])
+		}
+	}
+}
+
+func TestParseJournal(t *testing.T) {
+	journal := `2021-01-01 * Groceries
+    Expenses:Food        10.50 EUR
+    Assets:Cash
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if len(l.Transactions) != 1 {
+		t.Fatalf("ParseJournal: got %d transactions (expected 1)", len(l.Transactions))
+	}
+	tr := l.Transactions[0]
+	if tr.Description != "Groceries" || tr.State != accounting.Cleared {
+		t.Errorf("ParseJournal: transaction = %q (state %d)", tr.Description, tr.State)
+	}
+	if id := tr.ID.String(); id != "test.journal:1" {
+		t.Errorf("ParseJournal: transaction ID = %s (expected test.journal:1)", id)
+	}
+	if len(tr.Splits) != 2 || tr.Splits[1].Value.Amount != -1050*accounting.U/100 {
+		t.Errorf("ParseJournal: splits not filled as expected")
+	}
+}
+
+func TestIncludeDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "d"), 0755)
+	files := map[string]string{
+		"d/2.journal": "2020-01-02 second\n    Expenses:Food  2 EUR\n    Assets:Cash\n",
+		"d/1.journal": "2020-01-01 first\n    Expenses:Food  1 EUR\n    Assets:Cash\n",
+		"d/notes.txt": "this is not a journal\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := ParseJournal(strings.NewReader("include d/\n"), filepath.Join(dir, "main.journal"))
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if len(l.Transactions) != 2 || l.Transactions[0].Description != "first" || l.Transactions[1].Description != "second" {
+		t.Errorf("include directory: got %d transactions", len(l.Transactions))
+	}
+}
+
+func TestAccountCode(t *testing.T) {
+	journal := `account Assets:Bank ; code:572
+account Expenses:Food ; code:600
+account Expenses:Fuel ; code:628
+account Expenses:Gas ; code:628
+
+2021-01-01 Groceries
+    600        10 EUR
+    572
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	tr := l.Transactions[0]
+	if len(tr.Splits) != 2 || tr.Splits[0].Account.FullName() != "Expenses:Food" || tr.Splits[1].Account.FullName() != "Assets:Bank" {
+		t.Errorf("account codes not resolved in %s", tr.ID)
+	}
+	conn := ledgerConnection{ledger: l}
+	if _, _, err := conn.getSplitAccount("test.journal", 10, "628"); err == nil {
+		t.Errorf("getSplitAccount: ambiguous account code accepted")
+	}
+}
+
+func TestVirtualSplits(t *testing.T) {
+	journal := `2021-01-01 Groceries
+    Expenses:Food        10 EUR
+    Assets:Cash         -10 EUR
+    (Budget:Food)       -10 EUR
+    [Budget:Other]       -2 EUR
+    [Budget:Available]
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	expected := []struct {
+		name    string
+		virtual accounting.Virtual
+		amount  int64
+	}{
+		{"Expenses:Food", accounting.NotVirtual, 10},
+		{"Assets:Cash", accounting.NotVirtual, -10},
+		{"Budget:Food", accounting.UnbalancedVirtual, -10},
+		{"Budget:Other", accounting.BalancedVirtual, -2},
+		{"Budget:Available", accounting.BalancedVirtual, 2},
+	}
+	splits := l.Transactions[0].Splits
+	if len(splits) != len(expected) {
+		t.Fatalf("ParseJournal: got %d splits (expected %d)", len(splits), len(expected))
+	}
+	for i, e := range expected {
+		s := splits[i]
+		if s.Account.FullName() != e.name || s.Virtual != e.virtual || s.Value.Amount != e.amount*accounting.U {
+			t.Errorf("split %d: %s %v %s (expected %s %v %d)", i, s.Account.FullName(), s.Virtual, s.Value, e.name, e.virtual, e.amount)
+		}
+	}
+}
+
+func TestOpeningAssertions(t *testing.T) {
+	journal := `2020-01-01 Opening balances
+    Assets:Bank         = 1000.00 EUR
+    Assets:Cash         = 50.00 EUR
+
+2020-01-01 Opening balances
+    Liabilities:Card    = -200.00 EUR
+
+2020-01-02 Groceries
+    Expenses:Food        10.00 EUR
+    Assets:Cash
+
+2020-01-03 Check
+    Assets:Cash          0 EUR = 40.00 EUR
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	expected := map[string]int64{
+		"Assets:Bank":      1000 * accounting.U,
+		"Assets:Cash":      40 * accounting.U,
+		"Liabilities:Card": -200 * accounting.U,
+	}
+	for _, a := range l.Accounts {
+		want, ok := expected[a.FullName()]
+		if !ok {
+			continue
+		}
+		b := l.GetBalance(a, time.Time{})
+		if len(b) != 1 || b[0].Amount != want {
+			t.Errorf("balance of %s = %s (expected %d)", a.FullName(), b, want/accounting.U)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	journal := `account Assets:Cash
+
+2021-01-01 Groceries
+    Expenses:Food        10.50 EUR
+    Assets:Cash
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	tests := []struct {
+		obj  interface{}
+		line int
+	}{
+		{l.Transactions[0], 3},
+		{l.Transactions[0].Splits[0], 4},
+		{l.Transactions[0].Splits[1].Account, 1},
+		{l.Transactions[0].Splits[0].Account, 4},
+	}
+	for _, test := range tests {
+		filename, line, ok := l.Source(test.obj)
+		if !ok || filename != "test.journal" || line != test.line {
+			t.Errorf("Source(%v) = %s, %d, %t (expected test.journal, %d, true)", test.obj, filename, line, ok, test.line)
+		}
+	}
+	if _, _, ok := l.Source(&accounting.TransferAccount); ok {
+		t.Errorf("Source(TransferAccount) should not be found")
+	}
+}
+
+func TestElidedWithPrices(t *testing.T) {
+	journal := `2020-01-01 Buy
+    Assets:Broker        10 AAPL @ 100.00 USD
+    Assets:Wallet        5.00 EUR @@ 6.00 USD
+    Assets:Cash
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	s := l.Transactions[0].Splits[2]
+	if s.Value.Currency == nil || s.Value.Currency.Name != "USD" || s.Value.Amount != -1006*accounting.U {
+		t.Errorf("elided split = %s (expected -1006.00 USD)", s.Value)
+	}
+}
+
+func TestMergeAccount(t *testing.T) {
+	journal := `open Expenses:food:Bar  100.00 EUR
+open Expenses:Food:Bar  50.00 EUR
+
+2020-01-01 Food
+    Expenses:food:Bar      5.00 EUR
+    Expenses:food          5.00 EUR = 5.00 EUR
+    Assets:Cash
+
+2020-01-02 Food
+    Expenses:Food         10.00 EUR
+    Assets:Cash
+
+2020-01-03 Food
+    Expenses:Food:Bar      5.00 EUR
+    Assets:Cash
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	find := func(name string) *accounting.Account {
+		for _, a := range l.Accounts {
+			if a.FullName() == name {
+				return a
+			}
+		}
+		return nil
+	}
+	src, dst := find("Expenses:food"), find("Expenses:Food")
+	assertion := l.Transactions[0].Splits[1]
+	if err := l.MergeAccount(src, dst); err != nil {
+		t.Fatalf("MergeAccount: %v", err)
+	}
+	if find("Expenses:food") != nil || find("Expenses:food:Bar") != nil {
+		t.Errorf("MergeAccount: source accounts were not removed")
+	}
+	if assertion.Account != dst || l.Assertions[assertion] != (accounting.Value{Amount: 5 * accounting.U, Currency: assertion.Value.Currency}) {
+		t.Errorf("MergeAccount: split with assertion not moved")
+	}
+	if b := l.GetBalance(dst, time.Time{}); len(b) != 1 || b[0].Amount != 15*accounting.U {
+		t.Errorf("balance of Expenses:Food = %s (expected 15.00 EUR)", b)
+	}
+	bar := find("Expenses:Food:Bar")
+	if b := bar.StartBalance; len(b) != 1 || b[0].Amount != 150*accounting.U {
+		t.Errorf("opening balance of Expenses:Food:Bar = %s (expected 150.00 EUR)", b)
+	}
+	if b := l.GetBalance(bar, time.Time{}); len(bar.Splits) != 2 || len(b) != 1 || b[0].Amount != 160*accounting.U {
+		t.Errorf("balance of Expenses:Food:Bar = %s (expected 160.00 EUR in 2 splits)", b)
+	}
+	if err := l.MergeAccount(dst, bar); err == nil {
+		t.Errorf("MergeAccount into a subaccount should fail")
+	}
+}
+
+func TestRefill(t *testing.T) {
+	journal := `2020-01-01 Buy
+    Assets:Broker        10 AAPL @ 100.00 USD
+    Assets:Cash        -1000.00 USD ; date:2020-01-03
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	l = l.Clone()
+	splits, prices, accounts := len(l.Transactions[0].Splits), len(l.Prices), len(l.Accounts)
+	if err := l.Fill(); err != nil {
+		t.Fatalf("Fill: %v", err)
+	}
+	if len(l.Transactions[0].Splits) != splits || len(l.Prices) != prices || len(l.Accounts) != accounts {
+		t.Errorf("Fill again: %d splits, %d prices, %d accounts (expected %d, %d, %d)",
+			len(l.Transactions[0].Splits), len(l.Prices), len(l.Accounts), splits, prices, accounts)
+	}
+}
+
+func TestFileComments(t *testing.T) {
+	journal := `; Personal journal
+
+;;; 2020 ;;;
+2020-01-01 Groceries
+    Expenses:Food        10.00 EUR
+    Assets:Cash
+# end of 2020
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	var buf bytes.Buffer
+	Export(&buf, l.Clone())
+	out := buf.String()
+	header := strings.Index(out, "; Personal journal\n;;; 2020 ;;;\n2020-01-01")
+	end := strings.Index(out, "# end of 2020")
+	if header < 0 || end < header {
+		t.Errorf("Export did not keep the file comments in place:\n%s", out)
+	}
+}
+
+func TestValidateDuplicateAccount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := `account Assets:Cash
+account Expenses:Food
+account Assets:Cash
+	; note of the duplicate
+	alias cash
+
+2020-01-01 Lunch
+    Expenses:Food         10.00 EUR
+    Assets:Cash          -9.00 EUR
+`
+	filename := filepath.Join(dir, "main.journal")
+	if err := ioutil.WriteFile(filename, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errs := accounting.Validate("ledger:" + filename)
+	expected := []string{"main.journal:3: account Assets:Cash already defined", "main.journal:7: could not balance"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Validate: got %d errors (expected %d): %v", len(errs), len(expected), errs)
+	}
+	for i, e := range expected {
+		if !strings.Contains(errs[i].Error(), e) {
+			t.Errorf("Validate: error %d = %q (expected %q)", i, errs[i], e)
+		}
+	}
+}
+
+func TestValidateContinuationAtEOF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journals := map[string]string{
+		"main.journal": `include other.journal
+2020-01-02 Fuel
+    Expenses:Fuel         30.00 EUR
+    Assets:Cash
+`,
+		"other.journal": `2020-01-01 Lunch
+    Expenses:Food         10.00 EUR
+    Assets:Cash
+2020-01-01 Dinner \
+`,
+	}
+	for name, data := range journals {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := accounting.Validate("ledger:" + filepath.Join(dir, "main.journal"))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "other.journal:4: description continues after the end of the file") {
+		t.Fatalf("Validate = %v (expected other.journal:4: description continues after the end of the file)", errs)
+	}
+	l, err := accounting.Open("ledger:" + filepath.Join(dir, "main.journal"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer l.Close()
+	if len(l.Transactions) != 3 || l.Transactions[1].Description != "Dinner" || l.Transactions[2].Description != "Fuel" {
+		t.Errorf("transactions = %v (expected Lunch, Dinner and Fuel)", l.Transactions)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := `2020-01-01 Opening
+    Assets:Cash          100.00 EUR
+    Equity:Opening
+
+2020-01-02 Unbalanced
+    Expenses:Food         10.00 EUR
+    Assets:Cash          -9.00 EUR
+
+2020-01-03 Syntax error
+    Expenses:Food         1,2345,67 EUR
+    Assets:Cash
+
+2020-01-04 Wrong assertion
+    Expenses:Food         10.00 EUR
+    Assets:Cash          -10.00 EUR = 50.00 EUR
+
+2020-01-05 Good
+    Expenses:Food         10.00 EUR
+    Assets:Cash
+`
+	filename := filepath.Join(dir, "main.journal")
+	if err := ioutil.WriteFile(filename, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errs := accounting.Validate("ledger:" + filename)
+	expected := []string{"main.journal:10: ", "main.journal:5: could not balance", "main.journal:15: wrong assertion"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Validate: got %d errors (expected %d): %v", len(errs), len(expected), errs)
+	}
+	for i, e := range expected {
+		if !strings.Contains(errs[i].Error(), e) {
+			t.Errorf("Validate: error %d = %q (expected %q)", i, errs[i], e)
+		}
+	}
+	var ae *accounting.AssertionError
+	if !errors.As(errs[2], &ae) {
+		t.Fatalf("Validate: error %q is not an AssertionError", errs[2])
+	}
+	if ae.Account.FullName() != "Assets:Cash" || ae.Expected.Amount != 50*accounting.U ||
+		ae.Actual.Amount != 90*accounting.U || ae.Difference.Amount != -40*accounting.U {
+		t.Errorf("Validate: AssertionError = %+v (expected Assets:Cash, 50, 90 and -40)", ae)
+	}
+	if !strings.Contains(ae.Error(), "off by -40.00 EUR") {
+		t.Errorf("Validate: error %q does not show the difference", ae)
+	}
+}
+
+func TestIncludeOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"accounts.journal": "account Assets:Cash\naccount Expenses:Food\n",
+		"master.journal":   "include accounts.journal\n2020-01-01 first\n    Expenses:Food  1 EUR\n    Assets:Cash\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	main := "include accounts.journal\ninclude ./master.journal\ninclude " + filepath.Join(dir, "master.journal") + "\n"
+	l, err := ParseJournal(strings.NewReader(main), filepath.Join(dir, "main.journal"))
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if len(l.Transactions) != 1 {
+		t.Errorf("got %d transactions (expected 1)", len(l.Transactions))
+	}
+
+	s := NewScanner()
+	master := filepath.Join(dir, "master.journal")
+	if err := s.NewFile(master); err != nil {
+		t.Fatal(err)
+	}
+	s.Line()
+	if err := s.NewFile(master); err == nil {
+		t.Errorf("including a file from itself should fail")
+	}
+}
+
+func TestDescriptionContinuation(t *testing.T) {
+	journal := `2021-01-01 Dinner with Alice, Bob \
+    and Carol at the \ ; birthday
+  new restaurant
+    Expenses:Food        10.50 EUR
+    Assets:Cash
+
+2021-01-02 Not continued
+    Expenses:Food        1 EUR
+    Assets:Cash
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if len(l.Transactions) != 2 {
+		t.Fatalf("got %d transactions (expected 2)", len(l.Transactions))
+	}
+	tr := l.Transactions[0]
+	if expected := "Dinner with Alice, Bob and Carol at the new restaurant"; tr.Description != expected {
+		t.Errorf("description = %q (expected %q)", tr.Description, expected)
+	}
+	if len(tr.Splits) != 2 || tr.Splits[0].ID.String() != "test.journal:4" {
+		t.Errorf("continuation lines taken as splits")
+	}
+	if c := l.Comments[tr]; len(c) != 1 || c[0] != "birthday" {
+		t.Errorf("comments = %v (expected [birthday])", c)
+	}
+	if tr := l.Transactions[1]; len(tr.Splits) != 2 {
+		t.Errorf("second transaction has %d splits (expected 2)", len(tr.Splits))
+	}
+}
+
+func TestAccountType(t *testing.T) {
+	journal := `account Revenue ; type:income
+account Revenue:Salary
+account Assets:Bank ; type:Asset
+
+2021-01-01 Salary
+    Assets:Bank        1000 EUR
+    Revenue:Salary
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	for _, a := range l.Accounts {
+		var expected accounting.AccountType
+		switch a.FullName() {
+		case "Revenue", "Revenue:Salary":
+			expected = accounting.Income
+		case "Assets:Bank":
+			expected = accounting.Asset
+		}
+		if got := a.EffectiveType(); got != expected {
+			t.Errorf("%s: type = %q (expected %q)", a.FullName(), got, expected)
+		}
+	}
+	var out strings.Builder
+	Export(&out, l)
+	if !strings.Contains(out.String(), "account Revenue ; type:income\n") {
+		t.Errorf("Export did not keep the account type:\n%s", out.String())
+	}
+}
+
+func TestExportUnfilled(t *testing.T) {
+	eur := &accounting.Currency{Name: "EUR", Precision: 2}
+	bank := &accounting.Account{Name: "Bank"}
+	food := &accounting.Account{Name: "Food"}
+	l := &accounting.Ledger{
+		Accounts:   []*accounting.Account{bank, food},
+		Currencies: []*accounting.Currency{eur},
+		Transactions: []*accounting.Transaction{{
+			Time:        time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			Description: "Groceries",
+			Splits: []*accounting.Split{
+				{Account: food, Value: accounting.Value{Amount: 10 * accounting.U, Currency: eur}},
+				{Account: bank, Value: accounting.Value{Amount: -10 * accounting.U, Currency: eur}},
+			},
+		}},
+	}
+	var out bytes.Buffer
+	Export(&out, l)
+	l2, err := ParseJournal(&out, "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if len(l2.Transactions) != 1 || len(l2.Transactions[0].Splits) != 2 {
+		t.Fatalf("exported journal has %d transactions (expected 1 with 2 splits)", len(l2.Transactions))
+	}
+	if s := l2.Transactions[0].Splits[0]; !s.Time.Equal(l2.Transactions[0].Time) {
+		t.Errorf("split time = %v (expected the transaction's)", s.Time)
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	files := map[string]string{
+		"/data/main.journal":      "include sub/other.journal\n\n2021-01-02 second\n    Expenses  20 EUR\n    Assets\n",
+		"/data/sub/other.journal": "2021-01-01 first\n    Expenses  10 EUR\n    Assets\n",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer ts.Close()
+
+	if _, err := accounting.Open(ts.URL + "/data/main.journal"); err == nil {
+		t.Errorf("Open without authorization: expected failure")
+	}
+	l, err := accounting.Open(ts.URL+"/data/main.journal",
+		WithHTTPHeader("Authorization", "Bearer secret"), WithHTTPTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if len(l.Transactions) != 2 || l.Transactions[0].Description != "first" || l.Transactions[1].Description != "second" {
+		t.Errorf("Open: got %d transactions (expected \"first\" and \"second\")", len(l.Transactions))
+	}
+
+	// the headers are not sent to other hosts:
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+		w.Write([]byte("2021-01-03 third\n    Expenses  30 EUR\n    Assets\n"))
+	}))
+	defer other.Close()
+	files["/data/main.journal"] += "\ninclude " + other.URL + "/third.journal\n"
+	l, err = accounting.Open(ts.URL+"/data/main.journal", WithHTTPHeader("Authorization", "Bearer secret"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if len(l.Transactions) != 3 {
+		t.Errorf("Open: got %d transactions (expected 3)", len(l.Transactions))
+	}
+	if leaked != "" {
+		t.Errorf("Open: header sent to another host: %q", leaked)
+	}
+}
+
+func TestOpeningBalance(t *testing.T) {
+	journal := `open Assets:Bank  1000 EUR
+open Assets:Cash  50 EUR
+open Equity:Opening  -1050 EUR
+
+2021-01-01 Groceries
+    Expenses:Food        10 EUR
+    Assets:Bank                = 990 EUR
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	for _, a := range l.Accounts {
+		var expected string
+		switch a.FullName() {
+		case "Assets:Bank":
+			expected = "990 EUR"
+		case "Assets:Cash":
+			expected = "50 EUR"
+		case "Equity:Opening":
+			expected = "-1050 EUR"
+		case "Expenses:Food":
+			expected = "10 EUR"
+		default:
+			continue
+		}
+		if got := l.GetBalance(a, time.Time{}).String(); got != expected {
+			t.Errorf("%s: balance = %s (expected %s)", a.FullName(), got, expected)
+		}
+	}
+	var out bytes.Buffer
+	Export(&out, l)
+	if !strings.Contains(out.String(), "open Assets:Cash  50 EUR\n") {
+		t.Errorf("Export did not keep the opening balances:\n%s", out.String())
+	}
+	if _, err := ParseJournal(&out, "export.journal"); err != nil {
+		t.Errorf("ParseJournal(Export()): %v", err)
+	}
+}
+
+func TestPriceTimes(t *testing.T) {
+	journal := `P 2021-01-01 09:00 EUR 1.10 USD
+P 2021-01-01 15:30:15 EUR 1.20 USD
+P 2021-01-01/18:00 EUR 1.30 USD
+P 2021-01-02 EUR 1.40 USD
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	expected := []time.Time{
+		time.Date(2021, 1, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2021, 1, 1, 15, 30, 15, 0, time.UTC),
+		time.Date(2021, 1, 1, 18, 0, 0, 0, time.UTC),
+		time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC),
+	}
+	if len(l.Prices) != len(expected) {
+		t.Fatalf("got %d prices (expected %d)", len(l.Prices), len(expected))
+	}
+	for i, p := range l.Prices {
+		if !p.Time.Equal(expected[i]) || p.Currency.Name != "EUR" {
+			t.Errorf("price %d: %s %s (expected %s EUR)", i, p.Time, p.Currency.Name, expected[i])
+		}
+	}
+	v, err := l.Convert(accounting.Value{Amount: 1 * accounting.U, Currency: l.Prices[0].Currency}, expected[1], l.Prices[0].Value.Currency)
+	if err != nil || v.Amount != 1.2*accounting.U {
+		t.Errorf("Convert at %s = %s, %v (expected 1.20 USD)", expected[1], v, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	main := filepath.Join(dir, "main.journal")
+	other := filepath.Join(dir, "other.journal")
+	write := func(name, data string, mtime time.Time) {
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Now().Add(-time.Hour)
+	write(main, "account Expenses\naccount Assets\ncommodity EUR\ninclude other.journal\n", mtime)
+	write(other, "2021-01-01 first\n    Expenses  10 EUR\n    Assets\n", mtime)
+	l, err := accounting.Open("ledger:" + main)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	l.Refresh()
+	if len(l.Transactions) != 1 {
+		t.Fatalf("Refresh without changes: got %d transactions (expected 1)", len(l.Transactions))
+	}
+	write(other, "2021-01-01 first\n    Expenses  10 EUR\n    Assets\n\n2021-01-02 second\n    Expenses  20 EUR\n    Assets\n", mtime.Add(time.Minute))
+	l.Refresh()
+	if len(l.Transactions) != 2 || l.Transactions[1].Description != "second" {
+		t.Fatalf("Refresh: got %d transactions (expected \"first\" and \"second\")", len(l.Transactions))
+	}
+	if b := l.GetBalance(l.Transactions[1].Splits[0].Account, time.Time{}); b.String() != "30 EUR" {
+		t.Errorf("Refresh: balance = %s (expected 30 EUR)", b)
+	}
+	write(other, "2021-01-03 unbalanced\n    Expenses  10 EUR\n    Assets  -5 EUR\n", mtime.Add(2*time.Minute))
+	l.Refresh()
+	if len(l.Transactions) != 2 {
+		t.Errorf("Refresh with errors: got %d transactions (expected previous 2)", len(l.Transactions))
+	}
+}
+
+type testNotifier struct {
+	events chan string
+	added  chan string
+}
+
+func (n *testNotifier) Add(name string) error {
+	n.added <- name
+	return nil
+}
+
+func (n *testNotifier) Events() <-chan string { return n.events }
+func (n *testNotifier) Errors() <-chan error  { return nil }
+func (n *testNotifier) Close() error          { return nil }
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	main := filepath.Join(dir, "main.journal")
+	first := "2021-01-01 first\n    Expenses  10 EUR\n    Assets\n"
+	if err := ioutil.WriteFile(main, []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour)
+	os.Chtimes(main, mtime, mtime)
+	n := &testNotifier{events: make(chan string), added: make(chan string, 10)}
+	l, err := accounting.Open("ledger:"+main, accounting.WithFileNotifier(func() (accounting.FileNotifier, error) {
+		return n, nil
+	}))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan *accounting.Ledger)
+	go l.Watch(ctx, func(nl *accounting.Ledger) { changed <- nl })
+
+	if added := <-n.added; added != dir {
+		t.Errorf("Watch: watching %q (expected %q)", added, dir)
+	}
+	if err := ioutil.WriteFile(main, []byte(first+"\n2021-01-02 second\n    Expenses  20 EUR\n    Assets\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// several events in a row cause just one reload:
+	for i := 0; i < 3; i++ {
+		n.events <- main
+	}
+	var nl *accounting.Ledger
+	select {
+	case nl = <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Watch: no reload after changing the journal")
+	}
+	if len(nl.Transactions) != 2 {
+		t.Errorf("Watch: got %d transactions (expected 2)", len(nl.Transactions))
+	}
+	if len(l.Transactions) != 1 {
+		t.Errorf("Watch: the original ledger was modified")
+	}
+	select {
+	case <-changed:
+		t.Errorf("Watch: reloaded twice")
+	case <-time.After(2 * watchDelay):
+	}
+}
+
+func TestPayees(t *testing.T) {
+	journal := `2021-01-01 Supermarket | weekly shopping
+    Expenses:Food  10 EUR
+    Assets:Cash
+
+2021-01-02 Dinner with friends ; payee:Restaurant
+    Expenses:Food  20 EUR
+    Assets:Cash
+
+2021-01-03 Supermarket|milk
+    Expenses:Food  2 EUR
+    Assets:Cash
+
+2021-01-04 Cash withdrawal
+    Assets:Cash  50 EUR
+    Assets:Bank
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	expected := []string{"Supermarket", "Restaurant", "Supermarket", ""}
+	for i, tr := range l.Transactions {
+		if tr.Payee != expected[i] {
+			t.Errorf("transaction %d: payee %q (expected %q)", i, tr.Payee, expected[i])
+		}
+	}
+	if p := l.Payees(); len(p) != 2 || p[0] != "Restaurant" || p[1] != "Supermarket" {
+		t.Errorf("Payees() = %q (expected [Restaurant Supermarket])", p)
+	}
+	if c := l.Comments[l.Transactions[1]]; len(c) != 0 {
+		t.Errorf("payee tag kept as a comment: %q", c)
+	}
+	var buf bytes.Buffer
+	Export(&buf, l)
+	if out := buf.String(); !strings.Contains(out, "Dinner with friends ; payee:Restaurant\n") || strings.Contains(out, "payee:Supermarket") {
+		t.Errorf("Export: wrong payee tags in:\n%s", out)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	journal := `2020-01-01 Opening balances
+    Assets:Cash         = 50.00 EUR
+
+2020-01-02 Groceries
+    Expenses:Food        10.00 EUR
+    Assets:Cash
+
+2020-01-03 Fuel
+    Expenses:Fuel        30.00 EUR
+    Assets:Cash          = 10.00 EUR
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	f, err := l.Filter(func(tr *accounting.Transaction) bool {
+		return tr.Description != "Groceries"
+	})
+	if err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	if len(l.Transactions) != 3 || len(f.Transactions) != 2 {
+		t.Fatalf("Filter: %d and %d transactions (expected 3 and 2)", len(l.Transactions), len(f.Transactions))
+	}
+	balances := func(l *accounting.Ledger) map[string]string {
+		res := make(map[string]string)
+		for _, a := range l.Accounts {
+			res[a.FullName()] = l.GetBalance(a, time.Time{}).String()
+		}
+		return res
+	}
+	if b := balances(l); b["Assets:Cash"] != "10.00 EUR" || b["Expenses:Food"] != "10.00 EUR" {
+		t.Errorf("original ledger changed: %v", b)
+	}
+	if b := balances(f); b["Assets:Cash"] != "20.00 EUR" || b["Expenses:Food"] != "0" || b["Expenses:Fuel"] != "30.00 EUR" {
+		t.Errorf("filtered balances: %v (expected Assets:Cash 20.00 EUR, Expenses:Food 0, Expenses:Fuel 30.00 EUR)", b)
+	}
+}
+
+func TestDateRangeBoundaries(t *testing.T) {
+	journal := `2020-01-31/23:59:59 last in January
+    Expenses  1 EUR
+    Assets
+
+2020-02-01/00:00 first in February
+    Expenses  2 EUR
+    Assets
+
+2020-02-01 February 1st at noon
+    Expenses  4 EUR
+    Assets
+
+2020-02-29/23:59:59 last in February
+    Expenses  8 EUR
+    Assets
+
+2020-03-01/00:00 first in March
+    Expenses  16 EUR
+    Assets
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	begin, end, err := ParseDateRange("2020-02", "2020-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2020, 2, 29, 23, 59, 59, 999999999, time.UTC); !end.Equal(expected) {
+		t.Errorf("end = %s (expected %s)", end, expected)
+	}
+	l.TrimTo(begin, end)
+	var got []string
+	for _, tr := range l.Transactions {
+		got = append(got, tr.Description)
+	}
+	expected := []string{"first in February", "February 1st at noon", "last in February"}
+	if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("TrimTo: got %q (expected %q)", got, expected)
+	}
+	for _, a := range l.Accounts {
+		if a.Name != "Expenses" {
+			continue
+		}
+		if a.StartBalance.String() != "1 EUR" {
+			t.Errorf("start balance = %s (expected 1 EUR)", a.StartBalance)
+		}
+		if len(a.Splits) != 3 {
+			t.Errorf("got %d splits (expected 3)", len(a.Splits))
+		}
+	}
+
+	// a time in the last second of the range is still included:
+	late := time.Date(2020, 2, 29, 23, 59, 59, 500000000, time.UTC)
+	if late.After(end) {
+		t.Errorf("%s is after the end of the range (%s)", late, end)
+	}
+}
+
+func TestStableCurrencyFormat(t *testing.T) {
+	journal := `2020-01-01 first
+    Assets:Cash  $1
+    Income
+
+2020-01-02 second
+    Assets:Cash  2 $
+    Income
+
+2020-01-03 third
+    Assets:Cash  1,000.50 $
+    Income
+
+2020-01-04 euros
+    Assets:Bank  10 EUR
+    Income
+
+commodity EUR 1,000.00
+
+2020-01-05 more euros
+    Assets:Bank  2,000.5 EUR
+    Income
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	usd, _ := l.GetCurrency("$")
+	if !usd.PrintBefore || !usd.WithoutSpace || usd.Thousand != "," || usd.Precision != 2 {
+		t.Errorf("$: %+v (expected the format of its first occurrence, with thousands and precision)", *usd)
+	}
+	if s := l.Transactions[0].Splits[0].Value.String(); s != "$1.00" {
+		t.Errorf("first value = %q (expected \"$1.00\")", s)
+	}
+	eur, _ := l.GetCurrency("EUR")
+	if !eur.PrintBefore || eur.WithoutSpace || eur.Thousand != "," || eur.Precision != 2 {
+		t.Errorf("EUR: %+v (expected the format of its commodity directive)", *eur)
+	}
+	if s := l.Transactions[4].Splits[0].Value.String(); s != "EUR 2,000.50" {
+		t.Errorf("EUR value = %q (expected \"EUR 2,000.50\")", s)
+	}
+}
+
+func TestElided(t *testing.T) {
+	journal := `2020-01-02 Food
+    Expenses:Food  10 EUR
+    Assets:Bank
+
+2020-01-03 Transfer
+    Assets:Cash  20 EUR
+    Assets:Bank  -20 EUR
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	for _, c := range []*accounting.Ledger{l, l.Clone()} {
+		if err := c.Fill(); err != nil {
+			t.Fatalf("Fill: %v", err)
+		}
+		if len(c.Elided) != 1 || !c.Elided[c.Transactions[0].Splits[1]] {
+			t.Errorf("Elided = %v (expected only the second split of the first transaction)", c.Elided)
+		}
+	}
+}
+
+func TestUnmatchedQuoteInDescription(t *testing.T) {
+	journal := `2020-01-01 Monitor 27" ; payee:Shop
+    Expenses:Home  200 EUR ; 24" was too small
+    Assets:Bank
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	tr := l.Transactions[0]
+	if tr.Description != `Monitor 27"` || tr.Payee != "Shop" {
+		t.Errorf("description %q, payee %q (expected %q and Shop)", tr.Description, tr.Payee, `Monitor 27"`)
+	}
+	if c := l.Comments[tr.Splits[0]]; len(c) != 1 || c[0] != `24" was too small` {
+		t.Errorf("comments of the split = %q (expected %q)", c, `24" was too small`)
+	}
+}
+
+func TestExportQuotedPrices(t *testing.T) {
+	journal := `P 2020-01-01 EUR/USD 1.1 USD
+P 2020-01-01 "S&P 500" 3000 USD
+P 2020-01-02 "A=B" 2 "S&P 500"
+P 2020-01-02 "1st @ home" 2 "EUR/USD"
+P 2020-01-02 "X;Y" 3 EUR/USD
+
+2020-01-03 Buy
+    Assets:Broker  2 "S&P 500" @ 3000 USD
+    Assets:Bank  -6000 USD
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	var buf bytes.Buffer
+	Export(&buf, l)
+	l2, err := ParseJournal(&buf, "export.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal(Export()): %v", err)
+	}
+	// automatic prices are exported too, so they are read twice:
+	exported := make(map[string]bool)
+	for _, p := range l2.Prices {
+		exported[FormatPrice(p)] = true
+	}
+	for _, p := range l.Prices {
+		if !exported[FormatPrice(p)] {
+			t.Errorf("price %q not read back after exporting", FormatPrice(p))
+		}
+	}
+}
+
+func TestClearedBalance(t *testing.T) {
+	journal := `2021-01-01 * Salary
+    Assets:Bank  1000 EUR
+    Income:Salary
+
+2021-01-05 ! Rent
+    Expenses:Rent  500 EUR
+    Assets:Bank
+
+2021-01-10 Shopping
+    Expenses:Food  50 EUR
+    * Assets:Bank
+
+2021-01-15 Dinner
+    Expenses:Food  30 EUR
+    Assets:Bank
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	bank := l.Transactions[0].Splits[0].Account
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 23, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		when             time.Time
+		balance, cleared int64
+	}{
+		{time.Time{}, 420, 950},
+		{day(1), 1000, 1000},
+		{day(9), 500, 1000},
+		{day(10), 450, 950},
+	}
+	for _, test := range tests {
+		if b := l.GetBalance(bank, test.when); len(b) != 1 || b[0].Amount != test.balance*accounting.U {
+			t.Errorf("GetBalance(%s) = %s (expected %d EUR)", test.when, b, test.balance)
+		}
+		if b := l.GetClearedBalance(bank, test.when); len(b) != 1 || b[0].Amount != test.cleared*accounting.U {
+			t.Errorf("GetClearedBalance(%s) = %s (expected %d EUR)", test.when, b, test.cleared)
+		}
+	}
+	l.TrimTo(day(12), time.Time{})
+	if b := l.GetClearedBalance(bank, day(12)); len(b) != 1 || b[0].Amount != 950*accounting.U {
+		t.Errorf("GetClearedBalance after TrimTo = %s (expected 950 EUR)", b)
+	}
+}
+
+func TestTagContinuation(t *testing.T) {
+	journal := `account Assets:Bank
+    ; note: main account, opened
+    ;   in 2015 at the branch office
+    ; plain comment
+
+2021-01-01 Dinner ; payee:The Long
+    ;    Restaurant Name
+    ; url: https://example.com/receipts/
+    ;   2021/0001.pdf
+    ;   next:line is another tag
+    Expenses:Food  30 EUR ; project: a
+    ;	b
+    ;  c
+    Assets:Bank
+
+2021-01-02 Lunch
+    ; note: short
+    ; not a continuation
+    ;  after an untagged comment
+    Expenses:Food  10 EUR
+    Assets:Bank
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	check := func(what string, got []string, expected ...string) {
+		if strings.Join(got, "|") != strings.Join(expected, "|") {
+			t.Errorf("%s comments = %q (expected %q)", what, got, expected)
+		}
+	}
+	bank := l.Transactions[0].Splits[1].Account
+	check("account", l.Comments[bank], "note: main account, opened in 2015 at the branch office", "plain comment")
+	dinner := l.Transactions[0]
+	if dinner.Payee != "The Long Restaurant Name" {
+		t.Errorf("payee = %q (expected %q)", dinner.Payee, "The Long Restaurant Name")
+	}
+	check("transaction", l.Comments[dinner], "url: https://example.com/receipts/ 2021/0001.pdf", "next:line is another tag")
+	check("split", l.Comments[dinner.Splits[0]], "project: a b c")
+	check("second transaction", l.Comments[l.Transactions[1]], "note: short", "not a continuation", "after an untagged comment")
+}
+
+func TestUnsorted(t *testing.T) {
+	journal := `P 2021-01-03 USD 0.90 EUR
+P 2021-01-01 USD 0.80 EUR
+    ; old price
+
+2021-01-05 Second
+    Assets:Cash  10 EUR
+    Income:Salary
+
+2021-01-01 First
+    Assets:Cash  5 USD @ 0.85 EUR
+    Income:Salary
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if len(l.Transactions) != 2 || l.Transactions[0].Description != "First" {
+		t.Errorf("transactions not sorted: %v", l.Transactions)
+	}
+	for i := 1; i < len(l.Prices); i++ {
+		if l.Prices[i].Time.Before(l.Prices[i-1].Time) {
+			t.Errorf("prices not sorted: %s after %s", FormatPrice(l.Prices[i]), FormatPrice(l.Prices[i-1]))
+		}
+	}
+	if c := l.Comments[l.Prices[0]]; len(c) != 1 || c[0] != "old price" {
+		t.Errorf("comments of the first price = %q (expected [\"old price\"])", c)
+	}
+	var buf bytes.Buffer
+	Export(&buf, l)
+	if strings.Contains(buf.String(), "automatic") {
+		t.Errorf("Export wrote automatic prices:\n%s", buf.String())
+	}
+}
+
+func TestAccountDefaultCurrency(t *testing.T) {
+	journal := `D 1,000.00 EUR
+account Assets:Broker:AAPL ; commodity:AAPL
+account Assets:Broker:MSFT
+    ; default: MSFT
+account Assets:Cash
+
+2021-01-01 Buy
+    Assets:Broker:AAPL  10 @ 120.50
+    Assets:Broker:MSFT  2.5 = 2.5
+    Assets:Broker:MSFT  1 USD
+    Assets:Cash  -1,205.00
+    Assets:Cash  -600.00
+    Assets:Cash  -1 USD
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	expected := []string{"10 AAPL", "2.5 MSFT", "1 USD", "-1,205.00 EUR", "-600.00 EUR", "-1 USD"}
+	for i, s := range l.Transactions[0].Splits {
+		if got := s.Value.String(); got != expected[i] {
+			t.Errorf("split %d = %s (expected %s)", i, got, expected[i])
+		}
+	}
+	msft := l.Transactions[0].Splits[1]
+	if a := l.Assertions[msft]; a.Currency != msft.Value.Currency {
+		t.Errorf("assertion = %s (expected 2.5 MSFT)", a)
+	}
+	if p := l.SplitPrices[l.Transactions[0].Splits[0]]; p.String() != "1,205.00 EUR" {
+		t.Errorf("price = %s (expected 1,205.00 EUR)", p)
+	}
+
+	var buf bytes.Buffer
+	Export(&buf, l)
+	if !strings.Contains(buf.String(), "account Assets:Broker:MSFT ; commodity:MSFT\n") {
+		t.Errorf("Export does not keep the default commodity:\n%s", buf.String())
+	}
+	l2, err := ParseJournal(&buf, "exported.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal(exported): %v", err)
+	}
+	if c := l2.Transactions[0].Splits[0].Account.DefaultCurrency; c == nil || c.Name != "AAPL" {
+		t.Errorf("exported default commodity = %v (expected AAPL)", c)
+	}
+}
+
+func TestNamedCurrencyFormat(t *testing.T) {
+	amounts := `2021-01-02 First
+    Assets:Cash  1000 JPY
+    Equity
+2021-01-03 Second
+    Assets:Cash  JPY10.555
+    Equity
+`
+	// a currency named in a "P" directive gets its format from its first
+	// amount, and then changes as if the directive was not there:
+	format := func(journal string) accounting.Currency {
+		l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+		if err != nil {
+			t.Fatalf("ParseJournal: %v", err)
+		}
+		c, _ := l.GetCurrency("JPY")
+		return *c
+	}
+	named := format("P 2021-01-01 JPY 0.0070 EUR\n" + amounts)
+	if named != format(amounts) {
+		t.Errorf("format of JPY = %+v (expected %+v)", named, format(amounts))
+	}
+	if named.PrintBefore || named.WithoutSpace || named.Precision != 3 {
+		t.Errorf("format of JPY = %+v (expected the one of 1000 JPY, with precision 3)", named)
+	}
+}
+
+func TestOpenAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first.journal")
+	second := filepath.Join(dir, "second.journal")
+	journals := map[string]string{
+		first: `D 1,000.00 EUR
+account Assets:Bank ; code:572
+    ; main account
+P 2021-01-01 USD 0.80 EUR
+
+2021-01-01 Salary
+    Assets:Bank  1,000.00
+    Income
+
+2021-01-03 Rent
+    Expenses:Home  500.00 EUR
+    Assets:Bank  = 470.00 EUR
+`,
+		second: `account Assets:Bank ; code:999
+    ; alias:Bank
+    ; from the second file
+account Expenses:Home ; type:expense
+P 2021-01-02 USD 0.90 EUR
+
+2021-01-02 Dinner
+    Expenses:Food  30 EUR
+    Assets:Bank  ; date:2021-01-02/20:00
+`,
+	}
+	for name, data := range journals {
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := accounting.OpenAll("ledger:"+first, "ledger:"+second, "memory:")
+	if err != nil {
+		t.Fatalf("OpenAll: %v", err)
+	}
+	defer l.Close()
+	var names []string
+	for _, t := range l.Transactions {
+		names = append(names, t.Description)
+	}
+	if strings.Join(names, ",") != "Salary,Dinner,Rent" {
+		t.Errorf("transactions = %v (expected Salary, Dinner and Rent)", names)
+	}
+	var banks []*accounting.Account
+	for _, a := range l.Accounts {
+		if a.FullName() == "Assets:Bank" {
+			banks = append(banks, a)
+		}
+	}
+	if len(banks) != 1 {
+		t.Fatalf("%d accounts named Assets:Bank (expected 1)", len(banks))
+	}
+	bank := banks[0]
+	if bank.Code != "572" || bank.Alias != "Bank" || len(bank.Splits) != 3 {
+		t.Errorf("Assets:Bank: code %q, alias %q, %d splits (expected 572, Bank, 3)", bank.Code, bank.Alias, len(bank.Splits))
+	}
+	if c := l.Comments[bank]; len(c) != 2 || c[0] != "main account" || c[1] != "from the second file" {
+		t.Errorf("Assets:Bank comments = %q (expected the ones in both files)", c)
+	}
+	if home := l.Transactions[2].Splits[0].Account; home.Type != accounting.Expense {
+		t.Errorf("Expenses:Home type = %v (expected expense)", home.Type)
+	}
+	if len(l.Currencies) != 2 {
+		t.Errorf("currencies = %v (expected EUR and USD)", l.Currencies)
+	}
+	if v := l.Transactions[1].Splits[0].Value; v.Currency != l.DefaultCurrency || v.String() != "30.00 EUR" {
+		t.Errorf("Dinner = %s (expected 30.00 EUR in the default currency)", v)
+	}
+	if len(l.Prices) < 2 || l.Prices[0].Value.String() != "0.80 EUR" || l.Prices[1].Value.String() != "0.90 EUR" {
+		t.Errorf("prices = %v (expected 0.80 EUR and 0.90 EUR)", l.Prices)
+	}
+	// the split with its own date is moved to TransferAccount until then:
+	if n := len(l.Transactions[1].Splits); n != 4 {
+		t.Errorf("Dinner has %d splits (expected 2, and 2 in TransferAccount)", n)
+	}
+	if b := l.GetBalance(bank, time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)); b.String() != "1,000.00 EUR" {
+		t.Errorf("Assets:Bank on 2021-01-02 12:00 = %s (expected 1,000.00 EUR)", b)
+	}
+	if b := l.GetBalance(bank, time.Time{}); b.String() != "470.00 EUR" {
+		t.Errorf("Assets:Bank = %s (expected 470.00 EUR)", b)
+	}
+	l.Refresh()
+}
+
+func TestPeriodic(t *testing.T) {
+	journal := `~ monthly  Salary
+    Assets:Bank  2,000.00 EUR
+    Income:Salary
+
+~ weekly from 2021-01-05 until 2021-02-28  Groceries ; payee:Market
+    ; weekly shopping
+    Expenses:Food  100.00 EUR
+    Assets:Bank
+
+2021-01-01 Opening
+    Assets:Bank  500.00 EUR
+    Equity
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if len(l.Periodic) != 2 || len(l.Transactions) != 1 {
+		t.Fatalf("got %d periodic and %d transactions (expected 2 and 1)", len(l.Periodic), len(l.Transactions))
+	}
+	monthly, weekly := l.Periodic[0], l.Periodic[1]
+	if monthly.Months != 1 || monthly.Days != 0 || monthly.Template.Time.Day() != 1 || len(monthly.Template.Splits) != 2 {
+		t.Errorf("monthly = %+v (expected every month, on the 1st, with 2 splits)", monthly)
+	}
+	if weekly.Days != 7 || weekly.Template.Payee != "Market" || weekly.End.Format("2006-01-02") != "2021-02-28" {
+		t.Errorf("weekly = %+v (expected every week until 2021-02-28, paid to Market)", weekly)
+	}
+	if c := l.Comments[&weekly.Template]; len(c) != 1 || c[0] != "weekly shopping" {
+		t.Errorf("weekly comments = %q (expected \"weekly shopping\")", c)
+	}
+
+	var buf bytes.Buffer
+	Export(&buf, l)
+	for _, s := range []string{"~ monthly  Salary\n", "~ weekly from 2021-01-05/12:00 to 2021-02-28  Groceries ; payee:Market\n", "  Income:Salary\n"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("Export does not contain %q:\n%s", s, buf.String())
+		}
+	}
+	l2, err := ParseJournal(&buf, "exported.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal(exported): %v", err)
+	}
+	if len(l2.Periodic) != 2 || !l2.Periodic[1].Template.Time.Equal(weekly.Template.Time) || !l2.Periodic[1].End.Equal(weekly.End) {
+		t.Errorf("exported periodic transactions are not the same")
+	}
+
+	// wrong periodic transactions are reported and left out:
+	if l, err := ParseJournal(strings.NewReader("~ fortnightly\n"), "test.journal"); err != nil || len(l.Periodic) != 0 {
+		t.Errorf("ParseJournal with an unknown period = %v, %v (expected no periodic transactions)", l.Periodic, err)
+	}
+}
+
+func TestLotPrices(t *testing.T) {
+	journal := `2020-01-01 Buy
+    Assets:Broker  10 AAPL {100 USD} @ 90 USD
+    Assets:Bank
+
+2020-01-02 Buy
+    Assets:Broker  10 AAPL {{1100 USD}}
+    Assets:Bank
+
+2020-01-03 Sell
+    Assets:Broker  -5 AAPL {110 USD} @ 120 USD
+    Assets:Bank
+`
+	l, err := ParseJournal(strings.NewReader(journal), "test.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal: %v", err)
+	}
+	if err := l.Fill(); err != nil {
+		t.Fatalf("Fill: %v", err)
+	}
+	for i, expected := range []string{"-900 USD", "-1100 USD", "600 USD"} {
+		if v := l.Transactions[i].Splits[1].Value.String(); v != expected {
+			t.Errorf("transaction %d: bank %s (expected %s)", i, v, expected)
+		}
+	}
+	for _, c := range []*accounting.Ledger{l, l.Clone()} {
+		broker := c.Transactions[0].Splits[0].Account
+		disposals, err := c.Disposals(broker, accounting.FIFO)
+		if err != nil {
+			t.Fatalf("Disposals: %v", err)
+		}
+		if len(disposals) != 1 || disposals[0].Basis.String() != "550 USD" || disposals[0].Gain().String() != "50 USD" {
+			t.Errorf("Disposals(%s) = %v (expected a basis of 550 USD and a gain of 50 USD)", broker.FullName(), disposals)
+		}
+	}
+
+	var buf bytes.Buffer
+	Export(&buf, l)
+	l2, err := ParseJournal(&buf, "export.journal")
+	if err != nil {
+		t.Fatalf("ParseJournal(Export()): %v", err)
+	}
+	for i, tr := range l2.Transactions {
+		s := tr.Splits[0]
+		if v := l2.LotPrices[s].String(); v != l.LotPrices[l.Transactions[i].Splits[0]].String() {
+			t.Errorf("transaction %d: lot price %s after exporting (expected %s)", i, v, l.LotPrices[l.Transactions[i].Splits[0]])
+		}
+	}
+}
@@ -2,13 +2,15 @@ package ledger
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
 	"math/big"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -20,43 +22,64 @@ import (
 /* Syntax of ledger files using EBNF:
 
 line    = ( directive | transaction_line | split_line ) .
-directive = ( include_line | account_line | price_line | default_currency_line | commodity_line ) .
+directive = ( include_line | account_line | price_line | default_currency_line | commodity_line | open_line | periodic_line ) .
 
 letter = unicode_letter .
 digit  = "0" … "9" .
 digits = digit { digit } .
 punct = "." | "," | "_" | "'" .
 currency_char = letter | digit | "$" | "/" | "_" | "-" | "." .
-currency = currency_char { currency_char } .
+currency = ( currency_char { currency_char } ) | ( '"' { unicode_char } '"' ) .
 integer = ( digit { digit} ) | ( digit [ digit [ digit ] ] { punct digit digit digit } ) .
 number = [ "-" ] integer [ punct digit { digit } ]
 value = ( currency number ) | ( currency " " number ) | ( number currency ) | (number " " currency ) .
 date = digit digit digit digit ( "-" | "/" | "." ) digit digit ( "-" | "/" | "." ) digit digit
 indent = " " { " " }
+lot_price = ( "{" value "}" ) | ( "{{" value "}}" ) .
 transaction_price = ( "@" | "@@" ) value .
 balance_assertion = ( "=" | "=*" | "==" | "==*" ) value [ transaction_price ] .
    (only "=" assertions are supported)
 
-include_line = "include" filename .
-price_line   = "P" date currency value .
+include_line = "include" ( filename | directory ) .
+price_line   = "P" date [ time ] currency value .
+time = digit digit ":" digit digit [ ":" digit digit ] .
 default_currency_line = "D" [ currency | value ] .
-transaction_line = date description .
-split_line = indent account_name [ "  " [ value [ transaction_price ] ] [ balance_assertion ] ] .
+state = "*" | "!" .
+transaction_line = date [ state ] description { "\" newline description } .
+   (a description ending in "\" goes on in the next line, indented or not)
+comment_line = indent ( ";" | "#" | "*" ) text .
+   (a comment line whose text starts with two or more spaces, or a tab,
+   right after a "name:value" tag, appends its text to the value of that tag,
+   unless it starts with another "name:")
+   (a "commodity:" or "default:" tag in an account sets the currency of
+   the amounts without one in its splits and opening balance)
+split_line = indent [ state ] ( account_name | "(" account_name ")" | "[" account_name "]" ) [ "  " [ value [ lot_price ] [ transaction_price ] ] [ balance_assertion ] ] .
 commodity_line = "commodity" value .
-account_name = ( letter | digit ) { letter | digit | ":" | " " } .
-account_line = "account" account_name
+open_line = "open" account_name "  " value .
+   (opening balance of an account, before all its splits)
+account_name = ( ( letter | digit ) { letter | digit | ":" | " " } ) | ( '"' { unicode_char } '"' ) .
+account_line = "account" account_name { newline indent ( "alias" | "note" ) text } .
+periodic_line = "~" period [ "from" date ] [ ( "to" | "until" ) date ] [ "  " description ] .
+period = "daily" | "weekly" | "monthly" | "quarterly" | "yearly" .
+   (followed by split lines, like a transaction; without a "from" date,
+   it happens at the beginning of every day, week, month, quarter or year,
+   and the "to" date is included)
 
 */
 
 type scannerFile struct {
-	f        *os.File
+	f        io.Closer // nil if the file has not been opened yet
 	s        *bufio.Scanner
 	filename string
+	key      string // resolved absolute path of the file (or its URL)
 	lineNum  int
 }
 
 type Scanner struct {
-	files []scannerFile
+	files  []scannerFile
+	seen   map[string]bool      // files already read, by resolved absolute path (or URL)
+	http   *httpGetter          // used to read journals from HTTP URLs
+	mtimes map[string]time.Time // modification times of the local files and directories read
 }
 
 type ScannerLine struct {
@@ -75,6 +98,11 @@ func (id ID) String() string {
 	return fmt.Sprintf("%s:%d", id.filename, id.lineNum)
 }
 
+// Source returns the file name and line number where an object was defined.
+func (id ID) Source() (string, int) {
+	return id.filename, id.lineNum
+}
+
 const (
 	lineNone = iota
 	lineAccount
@@ -84,32 +112,147 @@ const (
 	lineTransaction
 	lineSplit
 	lineInclude
+	lineSkipped // a wrong directive, whose indented lines are ignored
 )
 
 func NewScanner() *Scanner {
 	s := new(Scanner)
+	s.seen = make(map[string]bool)
+	s.mtimes = make(map[string]time.Time)
 	return s
 }
 
 func (s *Scanner) NewFile(filename string) error {
+	// files included from a remote journal are relative to its URL:
+	if len(s.files) > 0 && isURL(s.files[len(s.files)-1].filename) && !isURL(filename) {
+		u, err := resolveURL(s.files[len(s.files)-1].filename, filename)
+		if err != nil {
+			return err
+		}
+		filename = u
+	}
+	if isURL(filename) {
+		return s.open(filename)
+	}
 	if len(filename) > 0 && filename[0] != '/' && len(s.files) > 0 {
 		filename = path.Join(path.Dir(s.files[len(s.files)-1].filename), filename)
 	}
-	f, err := os.Open(filename)
+	fi, err := os.Stat(filename)
 	if err != nil {
 		return err
 	}
+	if fi.IsDir() {
+		return s.newDir(filename)
+	}
+	return s.open(filename)
+}
+
+// resolvePath returns the absolute path of a file, following symbolic links.
+func resolvePath(filename string) string {
+	p, err := filepath.Abs(filename)
+	if err != nil {
+		return filename
+	}
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		p = r
+	}
+	return p
+}
+
+// journalExtensions are the extensions of the files read when including a directory.
+var journalExtensions = []string{".journal", ".ledger", ".hledger", ".j"}
+
+// newDir makes the scanner read, in order, all the journal files in a directory.
+func (s *Scanner) newDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	s.mtimes[dir] = fi.ModTime()
+	var names []string
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		for _, ext := range journalExtensions {
+			if path.Ext(fi.Name()) == ext {
+				names = append(names, path.Join(dir, fi.Name()))
+				break
+			}
+		}
+	}
+	// the last file added is the first one to be read;
+	// they are opened when they are reached.
+	for i := len(names) - 1; i >= 0; i-- {
+		s.files = append(s.files, scannerFile{filename: names[i]})
+	}
+	return nil
+}
+
+// open makes the scanner read a file or URL, unless it has already been read.
+// Including a file which is still being read is an error.
+func (s *Scanner) open(filename string) error {
+	key := filename
+	if !isURL(filename) {
+		key = resolvePath(filename)
+	}
+	for _, file := range s.files {
+		if file.f != nil && file.key == key {
+			return fmt.Errorf("include cycle: %s", filename)
+		}
+	}
+	if s.seen[key] {
+		return nil
+	}
+	var f io.ReadCloser
+	if isURL(filename) {
+		if s.http == nil {
+			s.http = newHTTPGetter(nil, "")
+		}
+		resp, err := s.http.get(filename)
+		if err != nil {
+			return err
+		}
+		f = resp.Body
+	} else {
+		var err error
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		if fi, err := file.Stat(); err == nil {
+			s.mtimes[filename] = fi.ModTime()
+		}
+		f = file
+	}
+	s.seen[key] = true
 	s2 := bufio.NewScanner(f)
-	s.files = append(s.files, scannerFile{f: f, s: s2, filename: filename})
+	s.files = append(s.files, scannerFile{f: f, s: s2, filename: filename, key: key})
 	return nil
 }
 
+// NewReader makes the scanner read lines from r, using name as its filename.
+func (s *Scanner) NewReader(r io.Reader, name string) {
+	s.files = append(s.files, scannerFile{f: ioutil.NopCloser(r), s: bufio.NewScanner(r), filename: name})
+}
+
 func (s *Scanner) Line() ScannerLine {
 	if len(s.files) == 0 {
 		return ScannerLine{Err: io.EOF}
 	}
 	var line ScannerLine
 	file := s.files[len(s.files)-1]
+	if file.f == nil {
+		s.files = s.files[:len(s.files)-1]
+		if err := s.open(file.filename); err != nil {
+			return ScannerLine{Filename: file.filename, Err: err}
+		}
+		return s.Line()
+	}
 	line.Filename = file.filename
 	line.LineNum = file.lineNum
 	if file.s.Scan() {
@@ -127,6 +270,35 @@ func (s *Scanner) Line() ScannerLine {
 	return line
 }
 
+// lineInFile returns the next line of the file being read, like Line,
+// but without going on to the next file at its end: then it returns
+// false, and an error only if it could not be read.
+func (s *Scanner) lineInFile() (ScannerLine, bool) {
+	if len(s.files) == 0 || s.files[len(s.files)-1].f == nil {
+		return ScannerLine{}, false
+	}
+	file := &s.files[len(s.files)-1]
+	line := ScannerLine{Filename: file.filename, LineNum: file.lineNum}
+	if !file.s.Scan() {
+		line.Err = file.s.Err()
+		return line, false
+	}
+	file.lineNum++
+	line.LineNum++
+	line.Text = file.s.Text()
+	return line, true
+}
+
+// descriptionPayee returns the payee in a description with the form
+// "payee | memo", or "" if it has no "|".
+func descriptionPayee(description string) string {
+	i := strings.Index(description, "|")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(description[:i])
+}
+
 type tag struct {
 	Name  string
 	Value string
@@ -145,23 +317,90 @@ func getTag(s string) *tag {
 	return tag
 }
 
+// taggedComment is a "name:value" comment added to an account, currency,
+// price, transaction or split.
+type taggedComment struct {
+	where   interface{}
+	comment string
+}
+
+// tagPrefix matches the comments starting with a tag name.
+var tagPrefix = regexp.MustCompile(`^[a-z]+:`)
+
+// isTagContinuation tells whether the text of an indented comment line
+// (after the comment character) goes on with the tag in the previous line:
+// it must start with two spaces or a tab, and not with another tag.
+func isTagContinuation(text string) bool {
+	trimmed := strings.TrimLeft(text, " \t")
+	indent := text[:len(text)-len(trimmed)]
+	if len(indent) < 2 && !strings.Contains(indent, "\t") {
+		return false
+	}
+	return trimmed != "" && !tagPrefix.MatchString(trimmed)
+}
+
+// continueTag appends some text to the value of a tagged comment.
+func (l *ledgerConnection) continueTag(tc *taggedComment, text string) {
+	comment := tc.comment + " " + strings.TrimSpace(text)
+	if c := l.ledger.Comments[tc.where]; len(c) > 0 && c[len(c)-1] == tc.comment {
+		// unknown tag, stored verbatim:
+		c[len(c)-1] = comment
+		l.lastTag = &taggedComment{where: tc.where, comment: comment}
+		return
+	}
+	l.addComment(tc.where, comment)
+}
+
 func (l *ledgerConnection) addComment(where interface{}, comment string) {
 	tag := getTag(comment)
 	if tag == nil {
 		l.ledger.Comments[where] = append(l.ledger.Comments[where], comment)
+		l.lastTag = nil
 		return
 	}
+	l.lastTag = &taggedComment{where: where, comment: comment}
 	switch x := where.(type) {
 	case *accounting.Account:
 		if tag.Name == "code" {
-			x.Code = tag.Value
+			x.Code = strings.TrimSpace(tag.Value)
+			return
+		}
+		if tag.Name == "alias" {
+			x.Alias = strings.TrimSpace(tag.Value)
+			return
+		}
+		if tag.Name == "type" {
+			t, err := accounting.ParseAccountType(strings.TrimSpace(tag.Value))
+			if err != nil {
+				l.backend.Errorf("%s: Invalid account type: %s", x.ID, tag.Value)
+			} else {
+				x.Type = t
+			}
+			return
+		}
+		if tag.Name == "commodity" || tag.Name == "default" {
+			name := unquote(strings.TrimSpace(tag.Value))
+			if name == "" {
+				l.backend.Errorf("%s: Invalid default commodity: %s", x.ID, tag.Value)
+			} else {
+				var newCurrency bool
+				x.DefaultCurrency, newCurrency = l.ledger.GetCurrency(name)
+				if newCurrency {
+					l.nameCurrency(x.DefaultCurrency)
+				}
+			}
+			return
+		}
+	case *accounting.Transaction:
+		if tag.Name == "payee" {
+			x.Payee = strings.TrimSpace(tag.Value)
 			return
 		}
 	case *accounting.Split:
 		if tag.Name == "date" {
 			t, err := GetDate(tag.Value)
 			if err != nil {
-				log.Printf("%s: Invalid date: %s", x.ID, tag.Value)
+				l.backend.Errorf("%s: Invalid date: %s", x.ID, tag.Value)
 			} else {
 				x.Time = &t
 			}
@@ -179,24 +418,44 @@ func (l *ledgerConnection) addComment(where interface{}, comment string) {
 
 // Read fills a ledger with the data from a journal file.
 func (l *ledgerConnection) readJournal() error {
+	s := NewScanner()
+	s.http = newHTTPGetter(l.backend, l.file)
+	if err := s.NewFile(l.file); err != nil {
+		return err
+	}
+	l.mtimes = s.mtimes
+	return l.parseJournal(s)
+}
+
+// parseJournal fills a ledger with the lines read from a scanner.
+func (l *ledgerConnection) parseJournal(s *Scanner) error {
 	l.ledger.Accounts = nil
 	l.ledger.Transactions = nil
+	l.ledger.Periodic = nil
 	l.ledger.Currencies = nil
 	l.ledger.Prices = nil
 	l.ledger.Comments = make(map[interface{}][]string)
+	l.ledger.FileComments = make(map[interface{}][]string)
+	l.pending = nil
+	l.lastTag = nil
 	l.ledger.Assertions = make(map[*accounting.Split]accounting.Value)
 	l.ledger.SplitPrices = make(map[*accounting.Split]accounting.Value)
+	l.ledger.LotPrices = make(map[*accounting.Split]accounting.Value)
+	l.ledger.Elided = make(map[*accounting.Split]bool)
 	l.ledger.DefaultCurrency = nil
-	s := NewScanner()
-	s.NewFile(l.file)
+	l.declared = make(map[*accounting.Currency]bool)
+	l.named = nil
 
 	lastLine := lineNone
+	var lastPrice *accounting.Price     // not always the last one in Prices
+	var current *accounting.Transaction // the one getting the splits (maybe a periodic one)
 	for {
 		line := s.Line()
 		if line.Err != nil {
 			if line.Err != io.EOF {
 				return line.Err
 			}
+			l.attachFileComments(nil)
 			break
 		}
 		// fmt.Printf("%s:%d: \"%s\"\n", line.Filename, line.LineNum, line.Text)
@@ -207,14 +466,23 @@ func (l *ledgerConnection) readJournal() error {
 			indented = true
 		}
 		text = strings.TrimSpace(text)
+		lastTag := l.lastTag
+		l.lastTag = nil
 		if len(text) == 0 {
 			// empty line
 			continue
 		}
-		if text[0] == '*' || text[0] == '#' || text[0] == ';' {
+		isComment := text[0] == '*' || text[0] == '#' || text[0] == ';'
+		if text[0] == '*' && indented && (lastLine == lineTransaction || lastLine == lineSplit) {
+			// not a comment, but a cleared split
+			isComment = false
+		}
+		if isComment {
 			comment = strings.TrimSpace(text[1:])
 			if !indented {
-				//fmt.Printf("%s:%d: File comment: \"%s\"\n", line.Filename, line.LineNum, comment)
+				l.pending = append(l.pending, text)
+			} else if lastTag != nil && isTagContinuation(text[1:]) {
+				l.continueTag(lastTag, comment)
 			} else {
 				switch lastLine {
 				case lineAccount:
@@ -224,22 +492,26 @@ func (l *ledgerConnection) readJournal() error {
 					var currency *accounting.Currency = l.ledger.Currencies[len(l.ledger.Currencies)-1]
 					l.addComment(currency, comment)
 				case linePrice:
-					var price *accounting.Price = l.ledger.Prices[len(l.ledger.Prices)-1]
-					l.addComment(price, comment)
+					l.addComment(lastPrice, comment)
 				case lineTransaction:
-					var transaction *accounting.Transaction = l.ledger.Transactions[len(l.ledger.Transactions)-1]
-					l.addComment(transaction, comment)
+					l.addComment(current, comment)
 				case lineSplit:
-					var transaction *accounting.Transaction = l.ledger.Transactions[len(l.ledger.Transactions)-1]
-					var split *accounting.Split = transaction.Splits[len(transaction.Splits)-1]
+					var split *accounting.Split = current.Splits[len(current.Splits)-1]
 					l.addComment(split, comment)
+				case lineSkipped:
 				default:
-					fmt.Printf("%s:%d: Wrong indented comment: \"%s\"\n", line.Filename, line.LineNum, comment)
+					l.backend.Errorf("%s:%d: Wrong indented comment: \"%s\"", line.Filename, line.LineNum, comment)
 				}
 			}
 			continue
 		}
-		if i := strings.IndexByte(text, ';'); i >= 0 {
+		// only the lines with amounts can have quoted commodities
+		// (with ";" inside); in the rest, a '"' is just a character:
+		commentStart := strings.IndexByte(text, ';')
+		if word, _ := firstWord(text); indented || withAmount[word] {
+			commentStart = indexUnquoted(text, ";")
+		}
+		if i := commentStart; i >= 0 {
 			comment = strings.TrimSpace(text[i+1:])
 			text = strings.TrimSpace(text[0:i])
 		}
@@ -249,106 +521,177 @@ func (l *ledgerConnection) readJournal() error {
 			newFile := rest
 			err := s.NewFile(newFile)
 			if err != nil {
-				log.Printf("%s:%d: couldn't include file: %s\n", line.Filename, line.LineNum, err.Error())
+				l.backend.Errorf("%s:%d: couldn't include file: %s", line.Filename, line.LineNum, err.Error())
 			}
 			continue
 		}
 		if !indented && word == "P" {
-			var price accounting.Price
-			var err error
-			// set price
-			date, rest := firstWord(rest)
-			price.Time, err = GetDate(date)
+			price, err := l.getPrice(line, rest)
 			if err != nil {
-				log.Printf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
+				l.backend.Errorf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
 				continue
 			}
 			if len(l.ledger.Prices) > 0 && l.ledger.Prices[len(l.ledger.Prices)-1].Time.After(price.Time) {
-				log.Fatalf("%s:%d: price is not chronologically sorted", line.Filename, line.LineNum)
+				l.backend.Errorf("%s:%d: price is not chronologically sorted", line.Filename, line.LineNum)
 			}
-			currency, rest := firstWord(rest)
-			price.ID = &ID{filename: line.Filename, lineNum: line.LineNum}
-			var newCurrency bool
-			price.Currency, newCurrency = l.ledger.GetCurrency(currency)
-			if newCurrency {
-				log.Printf("%s:%d undefined currency %s", line.Filename, line.LineNum, price.Currency.Name)
-			}
-			price.Value, err, newCurrency = l.getValue(rest)
 			if comment != "" {
-				l.addComment(&price, comment)
+				l.addComment(price, comment)
 			}
-			if err != nil {
-				log.Printf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
-				continue
-			}
-			if newCurrency {
-				log.Printf("%s:%d undefined currency %s", line.Filename, line.LineNum, price.Value.Currency.Name)
-			}
-			l.ledger.Prices = append(l.ledger.Prices, &price)
+			// out-of-order prices are kept, in their place:
+			l.ledger.AddPrice(price)
+			lastPrice = price
+			l.attachFileComments(price)
 			lastLine = linePrice
 			continue
 		}
 		if !indented && word == "D" {
 			lastLine = lineDefaultCurrency
-			price, err, _ := l.getValue(rest)
+			price, format, err, _ := l.parseValue(rest)
 			if err != nil {
-				log.Printf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
+				l.backend.Errorf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
 				continue
 			}
 			l.ledger.DefaultCurrency = price.Currency
+			l.declare(price.Currency, format)
 			continue
 		}
 		if !indented && word == "commodity" {
 			lastLine = lineCommodity
-			_, err, _ := l.getValue(rest)
+			value, format, err, _ := l.parseValue(rest)
 			if err != nil {
-				log.Printf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
+				l.backend.Errorf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
+				continue
+			}
+			l.declare(value.Currency, format)
+			l.attachFileComments(value.Currency)
+			continue
+		}
+		if !indented && word == "open" {
+			lastLine = lineNone
+			i := indexUnquoted(rest, "  ")
+			if i < 0 {
+				l.backend.Errorf("%s:%d: Syntax error: open: missing amount", line.Filename, line.LineNum)
 				continue
 			}
+			account, newAccount, err := l.getSplitAccount(line.Filename, line.LineNum, strings.TrimSpace(rest[:i]))
+			if err != nil {
+				l.backend.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
+				continue
+			}
+			if newAccount {
+				log.Printf("%s:%d undefined account %s", line.Filename, line.LineNum, account.FullName())
+			}
+			value, _, err, newCurrency := l.parseValueIn(strings.TrimSpace(rest[i+2:]), account.DefaultCurrency)
+			if err != nil {
+				l.backend.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
+				continue
+			}
+			if newCurrency {
+				log.Printf("%s:%d undefined currency %s", line.Filename, line.LineNum, value.Currency.Name)
+			}
+			account.StartBalance.Add(value)
 			continue
 		}
 		if !indented && word == "account" {
-			lastLine = lineAccount
-			_, new := l.getAccount(line.Filename, line.LineNum, rest)
+			account, new := l.getAccount(line.Filename, line.LineNum, rest)
 			if new == false {
-				log.Fatalf("%s:%d: account already defined", line.Filename, line.LineNum)
+				l.backend.Errorf("%s:%d: account %s already defined", line.Filename, line.LineNum, account.FullName())
+				lastLine = lineSkipped
+				continue
+			}
+			lastLine = lineAccount
+			l.attachFileComments(account)
+			if comment != "" {
+				l.addComment(account, comment)
 			}
 			continue
 		}
+		if indented && lastLine == lineSkipped {
+			continue
+		}
+		if indented && lastLine == lineAccount {
+			// sub-directives "alias" and "note" are handled as tags
+			word = strings.TrimSuffix(word, ":")
+			if word == "alias" || word == "note" {
+				l.addComment(l.ledger.Accounts[len(l.ledger.Accounts)-1], word+":"+rest)
+				continue
+			}
+		}
 		if !indented {
 			date, err := GetDate(word)
 			if err == nil {
 				if len(l.ledger.Transactions) > 0 && l.ledger.Transactions[len(l.ledger.Transactions)-1].Time.After(date) {
-					log.Fatalf("%s:%d: transaction is not chronologically sorted", line.Filename, line.LineNum)
+					// it is kept anyway: Fill sorts the transactions
+					l.backend.Errorf("%s:%d: transaction is not chronologically sorted", line.Filename, line.LineNum)
 				}
 				var transaction accounting.Transaction
 				transaction.ID = &ID{filename: line.Filename, lineNum: line.LineNum}
 				transaction.Time = date
-				transaction.Description = rest
+				transaction.State, transaction.Description, _ = getState(rest)
 				if comment != "" {
 					l.addComment(&transaction, comment)
 				}
+				for strings.HasSuffix(transaction.Description, `\`) {
+					transaction.Description = strings.TrimSpace(strings.TrimSuffix(transaction.Description, `\`))
+					next, ok := s.lineInFile()
+					if !ok {
+						l.backend.Errorf("%s:%d: description continues after the end of the file", line.Filename, line.LineNum)
+						break
+					}
+					cont := strings.TrimSpace(next.Text)
+					if i := strings.IndexByte(cont, ';'); i >= 0 {
+						l.addComment(&transaction, strings.TrimSpace(cont[i+1:]))
+						cont = strings.TrimSpace(cont[:i])
+					}
+					transaction.Description = strings.TrimSpace(transaction.Description + " " + cont)
+				}
+				if transaction.Payee == "" {
+					transaction.Payee = descriptionPayee(transaction.Description)
+				}
 				l.ledger.Transactions = append(l.ledger.Transactions, &transaction)
+				l.attachFileComments(&transaction)
+				current = &transaction
 				lastLine = lineTransaction
 				continue
 			}
 		}
+		if !indented && word == "~" {
+			p, err := l.getPeriodic(line, rest)
+			if err != nil {
+				l.backend.Errorf("%s:%d: Syntax error: %s", line.Filename, line.LineNum, err.Error())
+				lastLine = lineNone
+				continue
+			}
+			if comment != "" {
+				l.addComment(&p.Template, comment)
+			}
+			l.ledger.Periodic = append(l.ledger.Periodic, p)
+			l.attachFileComments(p)
+			current = &p.Template
+			lastLine = lineTransaction
+			continue
+		}
 		if indented && (lastLine == lineTransaction || lastLine == lineSplit) {
 			// this is a split
-			t := l.ledger.Transactions[len(l.ledger.Transactions)-1]
+			t := current
 			s := new(accounting.Split)
 			s.ID = &ID{filename: line.Filename, lineNum: line.LineNum}
 			if comment != "" {
 				l.addComment(s, comment)
 			}
+			s.State = t.State
+			if state, rest, ok := getState(text); ok {
+				s.State, text = state, rest
+			}
 
 			var err error
 			var accountEnd int
-			var hasValue, hasPriceAbs, hasPriceRel, hasAssertion bool
+			var hasValue, hasLot, lotTotal, hasPriceAbs, hasPriceRel, hasAssertion bool
 			var valueStart, valueEnd int
+			var lotText string
 			var priceStart, priceEnd int
 			var assertionStart, assertionEnd int
-			if i := strings.Index(text, "  "); i > 0 {
+			if i := indexUnquoted(text, "  "); i > 0 {
 				accountEnd = i
 				hasValue = true
 				valueStart = i + 2
@@ -356,24 +699,36 @@ func (l *ledgerConnection) readJournal() error {
 			} else {
 				accountEnd = len(text)
 			}
+			accountName := text[:accountEnd]
+			if n := len(accountName); n > 2 && accountName[0] == '(' && accountName[n-1] == ')' {
+				s.Virtual = accounting.UnbalancedVirtual
+				accountName = accountName[1 : n-1]
+			} else if n > 2 && accountName[0] == '[' && accountName[n-1] == ']' {
+				s.Virtual = accounting.BalancedVirtual
+				accountName = accountName[1 : n-1]
+			}
 			var newAccount bool
-			s.Account, newAccount = l.getAccount(line.Filename, line.LineNum, text[:accountEnd])
+			s.Account, newAccount, err = l.getSplitAccount(line.Filename, line.LineNum, accountName)
+			if err != nil {
+				l.backend.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
+				continue
+			}
 			if newAccount == true {
 				log.Printf("%s:%d undefined account %s", line.Filename, line.LineNum, s.Account.FullName())
 			}
 			if hasValue {
-				if i := strings.Index(text[valueStart:], "@@"); i > 0 {
+				if i := indexUnquoted(text[valueStart:], "@@"); i > 0 {
 					valueEnd = valueStart + i
 					hasPriceAbs = true
 					priceStart = valueStart + i + 2
 					priceEnd = len(text)
-				} else if i := strings.Index(text[valueStart:], "@"); i > 0 {
+				} else if i := indexUnquoted(text[valueStart:], "@"); i > 0 {
 					valueEnd = valueStart + i
 					hasPriceRel = true
 					priceStart = valueStart + i + 1
 					priceEnd = len(text)
 				}
-				if i := strings.Index(text[valueStart:], "="); i >= 0 {
+				if i := indexUnquoted(text[valueStart:], "="); i >= 0 {
 					hasAssertion = true
 					assertionStart = valueStart + i + 1
 					assertionEnd = len(text)
@@ -382,10 +737,25 @@ func (l *ledgerConnection) readJournal() error {
 						valueEnd = valueStart + i
 					}
 				}
+				valueText := strings.TrimSpace(text[valueStart:valueEnd])
+				if i := indexUnquoted(valueText, "{"); i >= 0 {
+					lot := strings.TrimSpace(valueText[i:])
+					valueText = strings.TrimSpace(valueText[:i])
+					hasLot = true
+					if strings.HasPrefix(lot, "{{") && strings.HasSuffix(lot, "}}") {
+						lotTotal = true
+						lotText = lot[2 : len(lot)-2]
+					} else if strings.HasSuffix(lot, "}") {
+						lotText = lot[1 : len(lot)-1]
+					} else {
+						l.backend.Errorf("%s:%d: invalid lot price %q", line.Filename, line.LineNum, lot)
+						continue
+					}
+				}
 				var newCurrency bool
-				s.Value, err, newCurrency = l.getValue(strings.TrimSpace(text[valueStart:valueEnd]))
+				s.Value, _, err, newCurrency = l.parseValueIn(valueText, s.Account.DefaultCurrency)
 				if err != nil {
-					log.Printf("%s:%d: %s\n", line.Filename, line.LineNum, err.Error())
+					l.backend.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
 					continue
 				}
 				if newCurrency {
@@ -395,24 +765,45 @@ func (l *ledgerConnection) readJournal() error {
 			if hasPriceRel || hasPriceAbs {
 				value, err, newCurrency := l.getValue(strings.TrimSpace(text[priceStart:priceEnd]))
 				if err != nil {
-					log.Printf("%s:%d: %s\n", line.Filename, line.LineNum, err.Error())
+					l.backend.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
 					continue
 				}
 				if newCurrency {
 					log.Printf("%s:%d undefined currency %s", line.Filename, line.LineNum, value.Currency.Name)
 				}
 				if hasPriceRel {
-					k := big.NewInt(s.Value.Amount)
-					k.Mul(k, big.NewInt(value.Amount))
-					k.Quo(k, big.NewInt(accounting.U))
-					value.Amount = k.Int64()
+					if value, err = totalPrice(s.Value, value); err != nil {
+						l.backend.Errorf("%s:%d: total price: %v", line.Filename, line.LineNum, err)
+						continue
+					}
 				}
 				l.ledger.SplitPrices[s] = value
 			}
+			if hasLot {
+				value, err, newCurrency := l.getValue(strings.TrimSpace(lotText))
+				if err != nil {
+					l.backend.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
+					continue
+				}
+				if newCurrency {
+					log.Printf("%s:%d undefined currency %s", line.Filename, line.LineNum, value.Currency.Name)
+				}
+				if !lotTotal {
+					if value, err = totalPrice(s.Value, value); err != nil {
+						l.backend.Errorf("%s:%d: lot price: %v", line.Filename, line.LineNum, err)
+						continue
+					}
+				}
+				l.ledger.LotPrices[s] = value
+				if !hasPriceRel && !hasPriceAbs {
+					// without a transaction price, the split is balanced at its cost
+					l.ledger.SplitPrices[s] = value
+				}
+			}
 			if hasAssertion {
-				value, err, newCurrency := l.getValue(strings.TrimSpace(text[assertionStart:assertionEnd]))
+				value, _, err, newCurrency := l.parseValueIn(strings.TrimSpace(text[assertionStart:assertionEnd]), s.Account.DefaultCurrency)
 				if err != nil {
-					log.Printf("%s:%d: %s\n", line.Filename, line.LineNum, err.Error())
+					l.backend.Errorf("%s:%d: %s", line.Filename, line.LineNum, err.Error())
 					continue
 				}
 				if newCurrency {
@@ -424,12 +815,115 @@ func (l *ledgerConnection) readJournal() error {
 			lastLine = lineSplit
 			continue
 		}
-		log.Printf("%s:%d: UNIMPLEMENTED: \"%s\" (%s)\n", line.Filename, line.LineNum, text, comment)
+		l.backend.Errorf("%s:%d: UNIMPLEMENTED: \"%s\" (%s)", line.Filename, line.LineNum, text, comment)
 	}
 	return nil
 }
 
+// attachFileComments keeps the pending top-level comments,
+// to be written by Export before obj.
+func (l *ledgerConnection) attachFileComments(obj interface{}) {
+	if len(l.pending) > 0 {
+		l.ledger.FileComments[obj] = append(l.ledger.FileComments[obj], l.pending...)
+		l.pending = nil
+	}
+}
+
+// getSplitAccount returns the account in a split, given its full name
+// or, if there is no account with that name, its code.
+func (l *ledgerConnection) getSplitAccount(filename string, lineNum int, str string) (*accounting.Account, bool, error) {
+	name := unquote(str)
+	for _, a := range l.ledger.Accounts {
+		if a.FullName() == name {
+			return a, false, nil
+		}
+	}
+	var found *accounting.Account
+	for _, a := range l.ledger.Accounts {
+		if a.Code != "" && a.Code == name {
+			if found != nil {
+				return nil, false, fmt.Errorf("ambiguous account code %s (%s or %s)", name, found.FullName(), a.FullName())
+			}
+			found = a
+		}
+	}
+	if found != nil {
+		return found, false, nil
+	}
+	acc, new := l.getAccount(filename, lineNum, str)
+	return acc, new, nil
+}
+
+// getPrice parses the arguments of a "P" directive: date, currency and value.
+func (l *ledgerConnection) getPrice(line ScannerLine, s string) (*accounting.Price, error) {
+	var price accounting.Price
+	var err error
+	date, rest := firstWord(s)
+	// the time can also be a separate word ("P 2006-01-02 15:04:05 EUR ..."):
+	if clock, r := firstWord(rest); len(date) == len("2006-01-02") && isClock(clock) {
+		date, rest = date+"/"+clock, r
+	}
+	price.Time, err = GetDate(date)
+	if err != nil {
+		return nil, err
+	}
+	currency, rest := firstWord(rest)
+	price.ID = &ID{filename: line.Filename, lineNum: line.LineNum}
+	var newCurrency bool
+	price.Currency, newCurrency = l.ledger.GetCurrency(unquote(currency))
+	if newCurrency {
+		l.nameCurrency(price.Currency)
+		log.Printf("%s:%d undefined currency %s", line.Filename, line.LineNum, price.Currency.Name)
+	}
+	price.Value, err, newCurrency = l.getValue(rest)
+	if err != nil {
+		return nil, err
+	}
+	if newCurrency {
+		log.Printf("%s:%d undefined currency %s", line.Filename, line.LineNum, price.Value.Currency.Name)
+	}
+	return &price, nil
+}
+
+// getPeriodic parses the rest of a "~" line: its period, its optional
+// "from" and "to" (or "until") dates, and its description.
+func (l *ledgerConnection) getPeriodic(line ScannerLine, s string) (*accounting.PeriodicTransaction, error) {
+	var description string
+	if i := strings.Index(s, "  "); i >= 0 {
+		s, description = s[:i], strings.TrimSpace(s[i:])
+	}
+	word, rest := firstWord(s)
+	period, err := ParsePeriod(word)
+	if err != nil {
+		return nil, err
+	}
+	p := new(accounting.PeriodicTransaction)
+	p.Template.ID = &ID{filename: line.Filename, lineNum: line.LineNum}
+	p.Template.Time = periodicStart(period)
+	p.Template.Description = description
+	p.Template.Payee = descriptionPayee(description)
+	p.Months, p.Days = period.Length()
+	for rest != "" {
+		var date string
+		word, rest = firstWord(rest)
+		date, rest = firstWord(rest)
+		switch word {
+		case "from":
+			p.Template.Time, err = GetDate(date)
+		case "to", "until":
+			_, p.End, err = ParseDateRange("", date)
+		default:
+			return nil, fmt.Errorf("unexpected %q in periodic transaction", word)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
 func (l *ledgerConnection) getAccount(filename string, lineNum int, str string) (acc *accounting.Account, new bool) {
+	str = unquote(str)
 	for i := range l.ledger.Accounts {
 		if str == l.ledger.Accounts[i].FullName() {
 			return l.ledger.Accounts[i], false
@@ -448,13 +942,56 @@ func (l *ledgerConnection) getAccount(filename string, lineNum int, str string)
 	return &account, true
 }
 
+// ValueError is a syntax error in a value.
+type ValueError struct {
+	Offset int    // Byte offset of the offending character in the value
+	Msg    string // Description of the error
+}
+
+func (e *ValueError) Error() string {
+	return "syntax error: " + e.Msg
+}
+
+func valueError(offset int, format string, a ...interface{}) error {
+	return &ValueError{Offset: offset, Msg: fmt.Sprintf(format, a...)}
+}
+
+// getValue parses a value, returning it and whether its currency is new.
+// Errors are of type *ValueError.
 func (l *ledgerConnection) getValue(s string) (accounting.Value, error, bool) {
+	value, _, err, newCurrency := l.parseValue(s)
+	return value, err, newCurrency
+}
+
+// totalPrice returns the price of v, given the price of one unit of it.
+func totalPrice(v accounting.Value, unit accounting.Value) (accounting.Value, error) {
+	k := big.NewInt(v.Amount)
+	k.Mul(k, big.NewInt(unit.Amount))
+	k.Quo(k, big.NewInt(accounting.U))
+	if !k.IsInt64() {
+		return accounting.Value{}, accounting.ErrOverflow
+	}
+	unit.Amount = k.Int64()
+	return unit, nil
+}
+
+// parseValue is like getValue, but it also returns the format
+// of the currency as written in s.
+func (l *ledgerConnection) parseValue(s string) (accounting.Value, *accounting.Currency, error, bool) {
+	return l.parseValueIn(s, nil)
+}
+
+// parseValueIn is like parseValue, but amounts without a currency
+// are in def (or, if it is nil, in the default currency of the ledger).
+func (l *ledgerConnection) parseValueIn(s string, def *accounting.Currency) (accounting.Value, *accounting.Currency, error, bool) {
 	var value accounting.Value
 	value.Currency = new(accounting.Currency)
+	format := value.Currency // format of the currency, as written in s
 	var sAmount string
+	var amountStart int // offset of sAmount in s
 
 	if s == "" {
-		return accounting.Value{}, nil, false // empty value == zero value
+		return accounting.Value{}, nil, nil, false // empty value == zero value
 	}
 	if s[0] == '-' || s[0] == '+' || (s[0] >= '0' && s[0] <= '9') {
 		// first amount, then currency
@@ -478,63 +1015,74 @@ func (l *ledgerConnection) getValue(s string) (accounting.Value, error, bool) {
 					value.Currency.WithoutSpace = true
 				}
 				sAmount = s[i+1:]
+				amountStart = i + 1
 				value.Currency.Name = strings.TrimSpace(s[0 : i+1])
 				break
 			}
 		}
 		if sAmount == "" {
-			return value, errors.New("syntax error: currency without amount"), false
+			return value, format, valueError(len(s), "currency without amount"), false
 		}
 	}
 done:
-	if strings.ContainsAny(value.Currency.Name, "=@") {
-		return value, errors.New("syntax error: invalid character in currency"), false
+	if name := unquote(value.Currency.Name); name != value.Currency.Name {
+		value.Currency.Name = name
+	} else if strings.ContainsAny(value.Currency.Name, "=@\"") {
+		return value, format, valueError(strings.IndexAny(s, "=@\""), "invalid character in currency"), false
 	}
+	// The amount is parsed with its own format, starting with the separators
+	// already known for its currency.  The display attributes of a currency
+	// are the ones in its first occurrence (or in its commodity directive),
+	// so they do not depend on how it is written later.
+	var c *accounting.Currency
 	newCurrency := true
 	if value.Currency.Name == "" {
-		if l.ledger.DefaultCurrency == nil {
-			l.ledger.DefaultCurrency = value.Currency
-		} else {
-			value.Currency = l.ledger.DefaultCurrency
-			newCurrency = false
+		c = def
+		if c == nil {
+			c = l.ledger.DefaultCurrency
 		}
+		newCurrency = c == nil
 	} else {
-		for _, c := range l.ledger.Currencies {
-			if c.Name == value.Currency.Name {
-				value.Currency = c
-				newCurrency = false
-				goto done2
-			}
-		}
-		l.ledger.Currencies = append(l.ledger.Currencies, value.Currency)
+		c, newCurrency = l.ledger.GetCurrency(value.Currency.Name)
+	}
+	// currencies named in a "P" directive or as the default commodity
+	// of an account get their format from their first amount:
+	unformatted := !newCurrency && l.named[c]
+	delete(l.named, c)
+	if !newCurrency && !unformatted {
+		format.Thousand, format.Decimal = c.Thousand, c.Decimal
 	}
-done2:
 	var sign int64 = 1
 	if sAmount[0] == '-' {
 		sign = -1
 		sAmount = sAmount[1:]
+		amountStart++
 	} else if sAmount[0] == '+' {
 		sAmount = sAmount[1:]
+		amountStart++
 	}
 	if len(sAmount) == 0 {
-		return value, errors.New("syntax error: empty amount"), newCurrency
+		return value, format, valueError(amountStart, "empty amount"), newCurrency
 	}
 	var punct string
 	punctPos, thousandPos, decimalPos := -1, -1, -1
 	if c := sAmount[len(sAmount)-1]; c < '0' || c > '9' {
-		return value, errors.New("syntax error: amount must end with a digit"), newCurrency
+		return value, format, valueError(amountStart+len(sAmount)-1, "amount must end with a digit"), newCurrency
 	}
 	for i, c := range sAmount {
 		if c >= '0' && c <= '9' {
+			if value.Amount > (math.MaxInt64-int64(c-'0'))/10 {
+				return value, format, valueError(amountStart, "amount too big"), newCurrency
+			}
 			value.Amount *= 10
 			value.Amount += int64(c - '0')
 			continue
 		}
 		if i == 0 {
-			return value, fmt.Errorf("syntax error: wrong position for punctuation mark '%c'", c), newCurrency
+			return value, format, valueError(amountStart+i, "wrong position for punctuation mark '%c'", c), newCurrency
 		}
 		if c == '-' || c == '+' {
-			return value, fmt.Errorf("syntax error: wrong punctuation mark '%c'", c), newCurrency
+			return value, format, valueError(amountStart+i, "wrong punctuation mark '%c'", c), newCurrency
 		}
 		if punct == string(c) {
 			// we have seen this before: this must be a thousand sign
@@ -544,8 +1092,8 @@ done2:
 		}
 		if value.Currency.Thousand == string(c) || (value.Currency.Thousand == "" && value.Currency.Decimal != "" && value.Currency.Decimal != string(c)) {
 			value.Currency.Thousand = string(c)
-			if (thousandPos == -1 && i > 3) || i-thousandPos != 4 || decimalPos > -1 {
-				return value, fmt.Errorf("syntax error: wrong position for thousand sign '%s'", value.Currency.Thousand), newCurrency
+			if (thousandPos == -1 && i > 3) || (thousandPos > -1 && i-thousandPos != 4) || decimalPos > -1 {
+				return value, format, valueError(amountStart+i, "wrong position for thousand sign '%s'", value.Currency.Thousand), newCurrency
 			}
 			thousandPos = i
 			continue
@@ -560,16 +1108,16 @@ done2:
 		if value.Currency.Decimal == string(c) || (value.Currency.Decimal == "" && value.Currency.Thousand != "" && value.Currency.Thousand != string(c)) {
 			value.Currency.Decimal = string(c)
 			if decimalPos > -1 {
-				return value, fmt.Errorf("syntax error: more than one decimal sign '%s'", value.Currency.Decimal), newCurrency
+				return value, format, valueError(amountStart+i, "more than one decimal sign '%s'", value.Currency.Decimal), newCurrency
 			}
 			if thousandPos > -1 && i-thousandPos != 4 {
-				return value, fmt.Errorf("syntax error: wrong position for thousand sign '%s'", value.Currency.Thousand), newCurrency
+				return value, format, valueError(amountStart+thousandPos, "wrong position for thousand sign '%s'", value.Currency.Thousand), newCurrency
 			}
 			decimalPos = i
 			continue
 		}
 		if value.Currency.Decimal != "" && value.Currency.Thousand != "" {
-			return value, fmt.Errorf("syntax error: unknown punctuacion '%c' (thousand='%s', decimal='%s')", c, value.Currency.Thousand, value.Currency.Decimal), newCurrency
+			return value, format, valueError(amountStart+i, "unknown punctuacion '%c' (thousand='%s', decimal='%s')", c, value.Currency.Thousand, value.Currency.Decimal), newCurrency
 		}
 		// 'c' could be a decimal sign or a thousand sign
 		if i > 3 {
@@ -586,36 +1134,120 @@ done2:
 		punct, punctPos = "", -1
 	}
 	if punct != "" {
-		return value, fmt.Errorf("syntax error: punctuation '%s' can be a thousand or a decimal", punct), newCurrency
+		return value, format, valueError(amountStart+punctPos, "punctuation '%s' can be a thousand or a decimal", punct), newCurrency
 	}
 	shift := 0
 	if decimalPos == -1 {
 		shift = 8
 	} else {
 		shift = len(sAmount) - decimalPos - 1
-		if newCurrency {
-			value.Currency.Precision = shift
-		}
+		format.Precision = shift
 		shift = 8 - shift
 	}
 	if shift < 0 || shift > 8 {
-		return value, fmt.Errorf("syntax error: too many decimal numbers"), newCurrency
+		return value, format, valueError(amountStart+decimalPos, "too many decimal numbers"), newCurrency
 	}
 	for i := 0; i < shift; i++ {
+		if value.Amount > math.MaxInt64/10 {
+			return value, format, valueError(amountStart, "amount too big"), newCurrency
+		}
 		value.Amount *= 10
 	}
 	value.Amount *= sign
-	if value.Currency.Decimal == "" {
-		if value.Currency.Thousand != "." {
-			value.Currency.Decimal = "."
+	if format.Decimal == "" {
+		if format.Thousand != "." {
+			format.Decimal = "."
 		} else {
-			value.Currency.Decimal = ","
+			format.Decimal = ","
 		}
 	}
-	return value, nil, newCurrency
+	l.setFormat(c, format, newCurrency || unformatted)
+	if c == nil {
+		l.ledger.DefaultCurrency = format
+		c = format
+	}
+	value.Currency = c
+	return value, format, nil, newCurrency
+}
+
+// nameCurrency records that a new currency was named without an amount,
+// so it has no format yet: it will get the one of its first amount.
+func (l *ledgerConnection) nameCurrency(c *accounting.Currency) {
+	if l.named == nil {
+		l.named = make(map[*accounting.Currency]bool)
+	}
+	l.named[c] = true
+}
+
+// declare sets the format of a currency from a "commodity" or "D" directive,
+// even if it was already used, unless it had been declared before.
+func (l *ledgerConnection) declare(c, format *accounting.Currency) {
+	if l.declared[c] {
+		return
+	}
+	l.declared[c] = true
+	delete(l.named, c)
+	c.PrintBefore = format.PrintBefore
+	c.WithoutSpace = format.WithoutSpace
+	c.Thousand = format.Thousand
+	c.Decimal = format.Decimal
+	c.Precision = format.Precision
+}
+
+// setFormat updates the format of a currency after reading a value
+// written in format.  New currencies take all of it; for the rest,
+// only the thousand separator (if it was unknown) and, for currencies
+// without an explicit format, the maximum precision seen in the journal.
+func (l *ledgerConnection) setFormat(c, format *accounting.Currency, newCurrency bool) {
+	if c == nil {
+		return
+	}
+	if newCurrency {
+		name := c.Name
+		*c = *format
+		c.Name = name
+		return
+	}
+	if c.Thousand == "" && format.Thousand != "" && format.Thousand != c.Decimal {
+		c.Thousand = format.Thousand
+	}
+	if !l.declared[c] && format.Precision > c.Precision {
+		c.Precision = format.Precision
+	}
+}
+
+// getState returns the state of a transaction or split, if its text
+// begins with a state mark, and the rest of the text.
+func getState(s string) (accounting.State, string, bool) {
+	if len(s) > 0 && (len(s) == 1 || s[1] == ' ' || s[1] == '\t') {
+		switch s[0] {
+		case '*':
+			return accounting.Cleared, strings.TrimSpace(s[1:]), true
+		case '!':
+			return accounting.Pending, strings.TrimSpace(s[1:]), true
+		}
+	}
+	return accounting.Uncleared, s, false
+}
+
+// stateMark returns the mark used in a journal for a state,
+// followed by a space, or an empty string for uncleared ones.
+func stateMark(state accounting.State) string {
+	switch state {
+	case accounting.Cleared:
+		return "* "
+	case accounting.Pending:
+		return "! "
+	}
+	return ""
 }
 
 func firstWord(s string) (string, string) {
+	if len(s) > 0 && s[0] == '"' {
+		if i := strings.IndexByte(s[1:], '"'); i >= 0 {
+			return s[:i+2], strings.TrimSpace(s[i+2:])
+		}
+	}
 	i := strings.IndexByte(s, ' ')
 	if i > 0 {
 		return s[:i], strings.TrimSpace(s[i+1:])
@@ -623,6 +1255,47 @@ func firstWord(s string) (string, string) {
 	return s, ""
 }
 
+// unquote removes the double quotes around a commodity or account name, if any.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// withAmount lists the directives which are followed by an amount,
+// whose commodity may be quoted.
+var withAmount = map[string]bool{
+	"P":         true,
+	"D":         true,
+	"commodity": true,
+	"open":      true,
+}
+
+// indexUnquoted is like strings.Index, but ignores the text between double quotes.
+func indexUnquoted(s, substr string) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			quoted = !quoted
+			continue
+		}
+		if !quoted && strings.HasPrefix(s[i:], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isClock tells whether a word is a time of the day ("15:04" or "15:04:05").
+func isClock(s string) bool {
+	if _, err := time.Parse("15:04", s); err == nil {
+		return true
+	}
+	_, err := time.Parse("15:04:05", s)
+	return err == nil
+}
+
 // GetDate returns a time from a string.
 func GetDate(s string) (time.Time, error) {
 	s = strings.ReplaceAll(s, "/", "-")
@@ -642,3 +1315,51 @@ func GetDate(s string) (time.Time, error) {
 	}
 	return d, e
 }
+
+// ParseDateRange returns the times for a begin and an end date,
+// which can be partial: "2006" and "2006-01" are the whole year or month.
+// Both are meant to be included in the range: the begin time is the first
+// instant of its day (or month, or year), and the end time is the last one
+// (one nanosecond before the next day), so that every time in the last day
+// is before it.  Dates with a time of the day are used as given.
+// Empty strings return the zero time.
+func ParseDateRange(begin, end string) (time.Time, time.Time, error) {
+	var beginTime, endTime time.Time
+	var err error
+	if begin != "" {
+		switch len(begin) {
+		case 4:
+			begin += "-01-01/00:00:00"
+		case 7:
+			begin += "-01/00:00:00"
+		case 10:
+			begin += "/00:00:00"
+		}
+		beginTime, err = GetDate(begin)
+		if err != nil {
+			return beginTime, endTime, err
+		}
+	}
+	if end != "" {
+		var years, months, days int
+		switch len(end) {
+		case 4:
+			end += "-01-01/00:00:00"
+			years = 1
+		case 7:
+			end += "-01/00:00:00"
+			months = 1
+		case 10:
+			end += "/00:00:00"
+			days = 1
+		}
+		endTime, err = GetDate(end)
+		if err != nil {
+			return beginTime, endTime, err
+		}
+		if years != 0 || months != 0 || days != 0 {
+			endTime = endTime.AddDate(years, months, days).Add(-time.Nanosecond)
+		}
+	}
+	return beginTime, endTime, nil
+}
@@ -1,6 +1,7 @@
 package ledger
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/cespedes/accounting"
@@ -46,7 +47,21 @@ var testValues [][]testValue = [][]testValue{
 	},
 	{
 		{"$1.23", "$1.23", false},
-		{"1.2345 $", "$1.23", false},
+		{"1.2345 $", "$1.2345", false},
+	},
+	{
+		{`1.5 "Big Fund"`, "1.5 Big Fund", false},
+		{`"Mutual Fund" 12.34`, "Mutual Fund 12.34", false},
+		{`2 "VANGUARD 500"`, "2 VANGUARD 500", false},
+		{`3 "A@B"`, "3 A@B", false},
+		{`3 A@B`, "", true},
+	},
+	{
+		{"92233720368 BTC", "92233720368 BTC", false},
+		{"-92233720368 BTC", "-92233720368 BTC", false},
+		{"92233720369 BTC", "", true},
+		{"92233720368.55 BTC", "", true},
+		{"99999999999999999999 BTC", "", true},
 	},
 }
 
@@ -78,3 +93,157 @@ func TestGetValue(t *testing.T) {
 		}
 	}
 }
+
+func TestSignedZero(t *testing.T) {
+	l := ledgerConnection{ledger: new(accounting.Ledger)}
+	zero, _, _ := l.getValue("0 EUR")
+	for _, s := range []string{"+0 EUR", "-0 EUR", "+0.00 EUR"} {
+		v, err, _ := l.getValue(s)
+		if err != nil || v.Amount != 0 || v.Currency != zero.Currency {
+			t.Errorf("getValue(%q) = %v, %v (expected the same as \"0 EUR\")", s, v, err)
+		}
+	}
+	l = ledgerConnection{ledger: new(accounting.Ledger)}
+	v, _, _ := l.getValue("+1.00")
+	if s := v.String(); s != "1.00" {
+		t.Errorf("getValue(\"+1.00\").String() = %q (expected \"1.00\")", s)
+	}
+}
+
+func TestValueErrorOffset(t *testing.T) {
+	tests := []struct {
+		input  string
+		offset int
+	}{
+		{"1.0.0", 3},
+		{"100.000", 3},
+		{"-1.2.3 EUR", 4},
+		{"EUR 1,2345,67", 10},
+		{"EUR", 3},
+		{"1 A@B", 3},
+		{"12.", 2},
+		{"1.123456789", 1},
+		{"92233720369 EUR", 0},
+		{"EUR -92233720368.55", 5},
+	}
+	for _, c := range tests {
+		l := ledgerConnection{ledger: new(accounting.Ledger)}
+		_, err, _ := l.getValue(c.input)
+		e, ok := err.(*ValueError)
+		if !ok {
+			t.Errorf("getValue(%q): error %v is not a *ValueError", c.input, err)
+			continue
+		}
+		if e.Offset != c.offset {
+			t.Errorf("getValue(%q): error %q at offset %d (expected %d)", c.input, e.Msg, e.Offset, c.offset)
+		}
+	}
+}
+
+func TestStateMark(t *testing.T) {
+	tests := []struct {
+		line  string
+		mark  string
+		state accounting.State
+		want  string
+	}{
+		{"2020-01-02 coffee", "* ", accounting.Uncleared, "2020-01-02 * coffee"},
+		{"2020-01-02 ! coffee", "", accounting.Pending, "2020-01-02 coffee"},
+		{"    Assets:Bank  -3 EUR", "! ", accounting.Uncleared, "    ! Assets:Bank  -3 EUR"},
+		{"\t* Assets:Bank", "* ", accounting.Cleared, "\t* Assets:Bank"},
+	}
+	for _, test := range tests {
+		text := strings.TrimSpace(test.line)
+		if test.line[0] != ' ' && test.line[0] != '\t' {
+			_, text = firstWord(text)
+		}
+		if state, _, _ := getState(text); state != test.state {
+			t.Errorf("getState(%q) = %v (expected %v)", text, state, test.state)
+		}
+		if got := setStateMark(test.line, test.mark); got != test.want {
+			t.Errorf("setStateMark(%q, %q) = %q (expected %q)", test.line, test.mark, got, test.want)
+		}
+	}
+}
+
+func TestInferredPrecision(t *testing.T) {
+	l := ledgerConnection{ledger: new(accounting.Ledger)}
+	v1, _, _ := l.getValue("10 AAPL")
+	v2, _, _ := l.getValue("10.5 AAPL")
+	l.getValue("3.25 AAPL")
+	if v1.Currency != v2.Currency {
+		t.Fatalf("getValue: different currencies for AAPL")
+	}
+	if got := v2.String(); got != "10.50 AAPL" {
+		t.Errorf("Value(10.5 AAPL) = %q (expected %q)", got, "10.50 AAPL")
+	}
+
+	l = ledgerConnection{ledger: new(accounting.Ledger), declared: make(map[*accounting.Currency]bool)}
+	v1, _, _ = l.getValue("1.00 EUR")
+	l.declared[v1.Currency] = true
+	v2, _, _ = l.getValue("1.2345 EUR")
+	if got := v2.String(); got != "1.23 EUR" {
+		t.Errorf("Value(1.2345 EUR) = %q (expected %q)", got, "1.23 EUR")
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		begin, end string
+		from, to   string
+	}{
+		{"2020", "2020", "2020-01-01 00:00:00", "2020-12-31 23:59:59"},
+		{"2020-02", "2020-02", "2020-02-01 00:00:00", "2020-02-29 23:59:59"},
+		{"2020-02-03", "2020-02-04", "2020-02-03 00:00:00", "2020-02-04 23:59:59"},
+		{"", "", "0001-01-01 00:00:00", "0001-01-01 00:00:00"},
+	}
+	for _, test := range tests {
+		from, to, err := ParseDateRange(test.begin, test.end)
+		if err != nil {
+			t.Errorf("ParseDateRange(%q, %q): %v", test.begin, test.end, err)
+			continue
+		}
+		if got := from.Format("2006-01-02 15:04:05"); got != test.from {
+			t.Errorf("ParseDateRange(%q, %q): begin = %s (expected %s)", test.begin, test.end, got, test.from)
+		}
+		if got := to.Format("2006-01-02 15:04:05"); got != test.to {
+			t.Errorf("ParseDateRange(%q, %q): end = %s (expected %s)", test.begin, test.end, got, test.to)
+		}
+	}
+	if _, _, err := ParseDateRange("2020-13", ""); err == nil {
+		t.Errorf("ParseDateRange(%q): expected failure", "2020-13")
+	}
+}
+
+func TestIndexUnquoted(t *testing.T) {
+	tests := []struct {
+		s, substr string
+		index     int
+	}{
+		{`1.5 "S&P 500" @ 10 EUR`, "@", 14},
+		{`2 "A@B" @@ 3 EUR`, "@@", 8},
+		{`"Assets:My  Broker"  1 EUR`, "  ", 19},
+		{`1 "X=Y"`, "=", -1},
+	}
+	for _, c := range tests {
+		if i := indexUnquoted(c.s, c.substr); i != c.index {
+			t.Errorf("indexUnquoted(%q, %q) = %d (expected %d)", c.s, c.substr, i, c.index)
+		}
+	}
+}
+
+func TestQuoteCurrency(t *testing.T) {
+	tests := map[string]string{
+		"EUR":     "EUR",
+		"$":       "$",
+		"NYSE:T":  "NYSE:T",
+		"S&P 500": `"S&P 500"`,
+		"A@B":     `"A@B"`,
+		"500":     `"500"`,
+	}
+	for name, expected := range tests {
+		if got := quoteCurrency(name); got != expected {
+			t.Errorf("quoteCurrency(%q) = %s (expected %s)", name, got, expected)
+		}
+	}
+}
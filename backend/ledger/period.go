@@ -0,0 +1,111 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Period is the length of every interval in a periodic report.
+type Period int
+
+// Possible values for Period.
+const (
+	Daily Period = iota
+	Weekly
+	Monthly
+	Quarterly
+	Yearly
+)
+
+var periodNames = []string{"daily", "weekly", "monthly", "quarterly", "yearly"}
+
+func (p Period) String() string {
+	if p < 0 || int(p) >= len(periodNames) {
+		return fmt.Sprintf("Period(%d)", int(p))
+	}
+	return periodNames[p]
+}
+
+// ParsePeriod returns the Period with a given name: "daily", "weekly",
+// "monthly", "quarterly" or "yearly".
+func ParsePeriod(s string) (Period, error) {
+	for i, name := range periodNames {
+		if strings.EqualFold(s, name) {
+			return Period(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown period %q", s)
+}
+
+// Length returns the number of months and days in a period.
+func (p Period) Length() (months, days int) {
+	switch p {
+	case Daily:
+		return 0, 1
+	case Weekly:
+		return 0, 7
+	case Monthly:
+		return 1, 0
+	case Quarterly:
+		return 3, 0
+	case Yearly:
+		return 12, 0
+	}
+	return 0, 0
+}
+
+// start returns the beginning of the period which contains t.
+// Weeks start on weekStart.
+func (p Period) start(t time.Time, weekStart time.Weekday) time.Time {
+	year, month, day := t.Date()
+	switch p {
+	case Weekly:
+		day -= (int(t.Weekday()) - int(weekStart) + 7) % 7
+	case Monthly:
+		day = 1
+	case Quarterly:
+		day = 1
+		month -= (month - 1) % 3
+	case Yearly:
+		day = 1
+		month = time.January
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// periodicStart returns the time of an occurrence of a periodic transaction
+// without a "from" date: noon at the beginning of a day, week (on Monday),
+// month, quarter or year.
+func periodicStart(p Period) time.Time {
+	if p == Weekly {
+		return time.Date(2000, 1, 3, 12, 0, 0, 0, time.UTC)
+	}
+	return time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+// Interval is a range of time, including both ends.
+type Interval struct {
+	Begin time.Time
+	End   time.Time
+}
+
+// Intervals returns the consecutive intervals of a period needed to
+// cover from begin to end. The first one starts at the beginning of the
+// day, week, month, quarter or year containing begin, and every interval
+// ends one nanosecond before the next one begins (as in ParseDateRange),
+// so that every time belongs to exactly one of them.
+// Weeks start on weekStart.
+func Intervals(p Period, begin, end time.Time, weekStart time.Weekday) []Interval {
+	months, days := p.Length()
+	if months == 0 && days == 0 {
+		return nil
+	}
+	var intervals []Interval
+	for t := p.start(begin, weekStart); !t.After(end); {
+		next := t.AddDate(0, months, days)
+		intervals = append(intervals, Interval{Begin: t, End: next.Add(-time.Nanosecond)})
+		t = next
+	}
+	return intervals
+}
@@ -0,0 +1,57 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	for _, p := range []Period{Daily, Weekly, Monthly, Quarterly, Yearly} {
+		got, err := ParsePeriod(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParsePeriod(%q) = %v, %v (expected %v)", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParsePeriod("fortnightly"); err == nil {
+		t.Errorf("ParsePeriod(\"fortnightly\") should fail")
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		period    Period
+		weekStart time.Weekday
+		begin     string
+		end       string
+		expected  []string // begin of every interval
+	}{
+		{Daily, time.Monday, "2021-01-30", "2021-02-01", []string{"2021-01-30", "2021-01-31", "2021-02-01"}},
+		{Weekly, time.Monday, "2021-01-06", "2021-01-18", []string{"2021-01-04", "2021-01-11", "2021-01-18"}},
+		{Weekly, time.Sunday, "2021-01-06", "2021-01-18", []string{"2021-01-03", "2021-01-10", "2021-01-17"}},
+		{Monthly, time.Monday, "2021-01-15", "2021-03-01", []string{"2021-01-01", "2021-02-01", "2021-03-01"}},
+		{Quarterly, time.Monday, "2021-02-15", "2021-07-01", []string{"2021-01-01", "2021-04-01", "2021-07-01"}},
+		{Yearly, time.Monday, "2020-06-01", "2021-01-01", []string{"2020-01-01", "2021-01-01"}},
+	}
+	for _, test := range tests {
+		intervals := Intervals(test.period, day(test.begin), day(test.end), test.weekStart)
+		if len(intervals) != len(test.expected) {
+			t.Errorf("Intervals(%s, %s, %s): got %d intervals (expected %d)", test.period, test.begin, test.end, len(intervals), len(test.expected))
+			continue
+		}
+		for i, in := range intervals {
+			if !in.Begin.Equal(day(test.expected[i])) {
+				t.Errorf("Intervals(%s, %s, %s)[%d]: begin = %s (expected %s)", test.period, test.begin, test.end, i, in.Begin, test.expected[i])
+			}
+			if i > 0 && !intervals[i-1].End.Equal(in.Begin.Add(-time.Nanosecond)) {
+				t.Errorf("Intervals(%s, %s, %s)[%d]: end = %s", test.period, test.begin, test.end, i-1, intervals[i-1].End)
+			}
+		}
+	}
+}
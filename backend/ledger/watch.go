@@ -0,0 +1,123 @@
+package ledger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cespedes/accounting"
+)
+
+// watchDelay is the time to wait after a file changes before reloading the
+// journal, so that several writes in a row (as done by some editors) cause
+// just one reload.
+const watchDelay = 200 * time.Millisecond
+
+// pollInterval is how often the files are checked without a FileNotifier.
+const pollInterval = 2 * time.Second
+
+// Watch reads the journal again every time one of its files changes, until
+// ctx is done, calling onChange with a new ledger and connection after every
+// successful reload.  The ledger of conn is never modified.
+// It uses the FileNotifier given with accounting.WithFileNotifier or,
+// if there is none, it checks the modification times of the files every
+// pollInterval.
+func (conn *ledgerConnection) Watch(ctx context.Context, onChange func(*accounting.Ledger, accounting.Connection)) {
+	if isURL(conn.file) {
+		conn.backend.Errorf("%s: cannot watch a remote journal", conn.file)
+		<-ctx.Done()
+		return
+	}
+	notifier, err := conn.backend.FileNotifier()
+	if err != nil {
+		conn.backend.Errorf("%s: %v", conn.file, err)
+	}
+	// current is the connection of the last ledger read:
+	current := conn
+	update := func() bool {
+		current.mu.Lock()
+		next := current.next()
+		current.mu.Unlock()
+		if next == nil {
+			return false
+		}
+		current = next
+		onChange(next.ledger, next)
+		return true
+	}
+	if notifier == nil {
+		poll(ctx, update)
+		return
+	}
+	defer notifier.Close()
+
+	watched := make(map[string]bool)
+	current.watchFiles(notifier, watched)
+	timer := time.NewTimer(watchDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-notifier.Events():
+			if !ok {
+				return
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(watchDelay)
+		case err, ok := <-notifier.Errors():
+			if !ok {
+				return
+			}
+			conn.backend.Errorf("%s: %v", conn.file, err)
+		case <-timer.C:
+			if update() {
+				current.watchFiles(notifier, watched)
+			}
+		}
+	}
+}
+
+// poll calls update every pollInterval, until ctx is done.
+// update reads the journal again if any of its files has changed.
+func poll(ctx context.Context, update func() bool) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			update()
+		}
+	}
+}
+
+// watchFiles adds to the notifier the directories of the files read and
+// the directories included, if they are not already watched.
+// Watching directories instead of files keeps on working with editors
+// which save a file by writing a new one and renaming it.
+func (conn *ledgerConnection) watchFiles(notifier accounting.FileNotifier, watched map[string]bool) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	for name := range conn.mtimes {
+		dir := filepath.Dir(name)
+		if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+			dir = name
+		}
+		if watched[dir] {
+			continue
+		}
+		if err := notifier.Add(dir); err != nil {
+			conn.backend.Errorf("%s: %v", dir, err)
+			continue
+		}
+		watched[dir] = true
+	}
+}
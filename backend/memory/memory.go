@@ -0,0 +1,131 @@
+/*
+Package memory is a driver for the github.com/cespedes/accounting package
+which keeps all the data in memory, without reading or writing anything.
+
+It is useful to build a ledger from scratch, as done by tests or importers,
+and then write it somewhere else, for example with ledger.Export:
+
+	import (
+		"github.com/cespedes/accounting"
+		"github.com/cespedes/accounting/backend/ledger"
+		_ "github.com/cespedes/accounting/backend/memory"
+	)
+
+	func main() {
+		l, err := accounting.Open("memory:")
+		...
+		cash, err := l.NewAccount(accounting.Account{Name: "Cash"})
+		...
+		_, err = l.NewTransaction(accounting.Transaction{...})
+		...
+		if err := l.Flush(); err != nil {
+			...
+		}
+		ledger.Export(os.Stdout, l)
+	}
+
+New accounts, transactions and splits get a new accounting.SeqID,
+given by the ledger.
+*/
+package memory
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/cespedes/accounting"
+)
+
+type driver struct{}
+
+func init() {
+	accounting.Register("memory", driver{})
+}
+
+type conn struct {
+	backend *accounting.Backend
+	ledger  *accounting.Ledger
+}
+
+// Open returns an empty ledger.  The rest of the data source name is ignored.
+func (driver) Open(name string, backend *accounting.Backend) (accounting.Connection, error) {
+	conn := new(conn)
+	conn.backend = backend
+	conn.ledger = backend.Ledger
+	conn.ledger.Comments = make(map[interface{}][]string)
+	conn.ledger.FileComments = make(map[interface{}][]string)
+	conn.ledger.SplitPrices = make(map[*accounting.Split]accounting.Value)
+	conn.ledger.Assertions = make(map[*accounting.Split]accounting.Value)
+	return conn, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+// Refresh does nothing: there is nowhere to read the data from.
+func (c *conn) Refresh() {
+}
+
+// Flush updates the accounts with the transactions added since the last
+// call, returning an error if any of them cannot be balanced.
+func (c *conn) Flush() error {
+	return c.ledger.Fill()
+}
+
+// hasAccount tells whether an account belongs to the ledger.
+func (c *conn) hasAccount(a *accounting.Account) bool {
+	for _, b := range c.ledger.Accounts {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAccount adds an account to the ledger, without an ID, so that
+// Ledger.NewAccount gives it a new one.
+// Its Parent, if any, must already be in the ledger.
+func (c *conn) NewAccount(a accounting.Account) (*accounting.Account, error) {
+	if a.Parent != nil && !c.hasAccount(a.Parent) {
+		return nil, errors.New("memory.NewAccount: parent account is not in the ledger")
+	}
+	account := &a
+	account.ID = nil
+	account.Children = nil
+	account.Splits = nil
+	account.Level = 0
+	if account.Parent != nil {
+		account.Level = account.Parent.Level + 1
+		account.Parent.Children = append(account.Parent.Children, account)
+	}
+	c.ledger.Accounts = append(c.ledger.Accounts, account)
+	return account, nil
+}
+
+// NewTransaction adds a transaction to the ledger, after any other one with
+// the same Time, without IDs in it and its splits, so that
+// Ledger.NewTransaction gives them new ones.
+// The accounts of all the splits must already be in the ledger.
+// Their balances are not updated until Flush is called.
+func (c *conn) NewTransaction(t accounting.Transaction) (*accounting.Transaction, error) {
+	for _, s := range t.Splits {
+		if s.Account == nil || !c.hasAccount(s.Account) {
+			return nil, errors.New("memory.NewTransaction: split account is not in the ledger")
+		}
+	}
+	transaction := &t
+	transaction.ID = nil
+	for _, s := range transaction.Splits {
+		s.ID = nil
+		s.Transaction = transaction
+	}
+	l := c.ledger
+	i := sort.Search(len(l.Transactions), func(i int) bool {
+		return l.Transactions[i].Time.After(t.Time)
+	})
+	l.Transactions = append(l.Transactions, nil)
+	copy(l.Transactions[i+1:], l.Transactions[i:])
+	l.Transactions[i] = transaction
+	return transaction, nil
+}
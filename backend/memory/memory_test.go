@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cespedes/accounting"
+	"github.com/cespedes/accounting/backend/ledger"
+)
+
+func TestMemory(t *testing.T) {
+	l, err := accounting.Open("memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eur, _ := l.GetCurrency("EUR")
+	eur.Precision = 2
+	assets, _ := l.NewAccount(accounting.Account{Name: "Assets"})
+	cash, _ := l.NewAccount(accounting.Account{Name: "Cash", Parent: assets})
+	food, _ := l.NewAccount(accounting.Account{Name: "Food"})
+	if cash.FullName() != "Assets:Cash" || cash.ID != accounting.SeqID(2) {
+		t.Errorf("NewAccount = %s (ID %v) (expected Assets:Cash, ID 2)", cash.FullName(), cash.ID)
+	}
+	if _, err := l.NewAccount(accounting.Account{Name: "X", Parent: &accounting.Account{}}); err == nil {
+		t.Errorf("NewAccount with a parent from elsewhere did not fail")
+	}
+	transaction := func(day int, amount int64) accounting.Transaction {
+		return accounting.Transaction{
+			Time:        time.Date(2020, 1, day, 12, 0, 0, 0, time.UTC),
+			Description: "Lunch",
+			Splits: []*accounting.Split{
+				{Account: food, Value: accounting.Value{Amount: amount * accounting.U, Currency: eur}},
+				{Account: cash, Value: accounting.Value{Amount: -amount * accounting.U, Currency: eur}},
+			},
+		}
+	}
+	t2, _ := l.NewTransaction(transaction(2, 20))
+	t1, err := l.NewTransaction(transaction(1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Transactions[0] != t1 || l.Transactions[1] != t2 {
+		t.Errorf("NewTransaction did not keep the transactions sorted")
+	}
+	if t1.ID.String() != "7" || t1.Splits[1].ID.String() != "9" {
+		t.Errorf("NewTransaction IDs = %v and %v (expected 7 and 9)", t1.ID, t1.Splits[1].ID)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if b := cash.Splits[1].Balance; len(b) != 1 || b[0].Amount != -30*accounting.U {
+		t.Errorf("balance = %v (expected -30 EUR)", b)
+	}
+
+	var buf bytes.Buffer
+	ledger.Export(&buf, l)
+	for _, s := range []string{"2020-01-01/12:00 Lunch", "Assets:Cash", "-20.00 EUR"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("Export does not contain %q:\n%s", s, buf.String())
+		}
+	}
+
+	unbalanced := transaction(3, 5)
+	unbalanced.Splits[1].Value.Amount = -4 * accounting.U
+	if _, err := l.NewTransaction(unbalanced); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Flush(); err == nil {
+		t.Errorf("Flush with an unbalanced transaction did not fail")
+	}
+}
@@ -42,7 +42,18 @@ The database to connect must already exist, and must have these tables:
 	CREATE TABLE split (
 	  transaction_id INTEGER NOT NULL REFERENCES transaction(id),
 	  account_id     INTEGER NOT NULL REFERENCES account(id),
-	  value          NUMERIC
+	  value          NUMERIC,
+	  currency       TEXT
 	);
+
+The currency column was added to support ledgers with more than one currency:
+existing databases can add it with
+
+	ALTER TABLE split ADD COLUMN currency TEXT;
+
+No other tables or views are needed: the balances are calculated when reading the data.
+
+Splits with a NULL currency use a default one, without name and with two decimals.
+The other currencies are shown with as many decimals as their values have.
 */
 package postgres
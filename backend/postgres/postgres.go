@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math/big"
+	"strings"
 	"time"
 
 	"github.com/cespedes/accounting"
@@ -16,10 +18,11 @@ type driver struct{}
 type ID int
 
 type conn struct {
-	db      *sql.DB
-	updated time.Time
-	backend *accounting.Backend
-	ledger  *accounting.Ledger
+	db       *sql.DB
+	updated  time.Time
+	backend  *accounting.Backend
+	ledger   *accounting.Ledger
+	currency accounting.Currency // just one currency for now
 }
 
 func (id ID) String() string {
@@ -43,10 +46,15 @@ func (driver) Open(name string, backend *accounting.Backend) (accounting.Connect
 	// TODO I should check the SQL schema...
 	conn := new(conn)
 	conn.db = db
+	conn.currency.Precision = 2
 	conn.backend = backend
 	conn.ledger = backend.Ledger
-	getAccounts(conn)
-	getTransactions(conn)
+	if err = getAccounts(conn); err != nil {
+		return nil, errors.New("psql.Open: " + err.Error())
+	}
+	if err = getTransactions(conn); err != nil {
+		return nil, errors.New("psql.Open: " + err.Error())
+	}
 	return conn, nil
 }
 
@@ -58,62 +66,75 @@ func (c *conn) Close() error {
 	return c.db.Close()
 }
 
-func getAccounts(c *conn) {
+func getAccounts(c *conn) error {
 	ledger := c.ledger
 	query := `
-		SELECT a.id, a.name, COALESCE(a.code, '') AS code,
-			COALESCE((100*sum(s.value))::integer, 0) AS balance
+		SELECT a.id, COALESCE(a.parent_id, 0) AS parent_id, a.name, COALESCE(a.code, '') AS code
 		FROM account a
-		LEFT JOIN split s ON a.id=s.account_id GROUP BY a.id
 	`
 	rows, err := c.db.Query(query)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer rows.Close()
 	ledger.Accounts = nil
+	parents := make(map[*accounting.Account]ID)
 	for rows.Next() {
 		var (
-			id      int
-			name    string
-			code    string
-			balance int
-			acc     accounting.Account
+			id       int
+			parentID int
+			name     string
+			code     string
+			acc      accounting.Account
 		)
-		if err := rows.Scan(&id, &name, &code, &balance); err != nil {
-			panic(err)
+		if err := rows.Scan(&id, &parentID, &name, &code); err != nil {
+			return err
 		}
 		acc.ID = ID(id)
 		acc.Name = name
 		acc.Code = code
-		// acc.Balance = balance
+		if parentID != 0 {
+			parents[&acc] = ID(parentID)
+		}
 		ledger.Accounts = append(ledger.Accounts, &acc)
 	}
+	for _, a := range ledger.Accounts {
+		if id, ok := parents[a]; ok {
+			a.Parent = ledger.Account(id)
+		}
+	}
+	return rows.Err()
 }
 
-func getTransactions(c *conn) {
+func getTransactions(c *conn) error {
 	ledger := c.ledger
 	idAccount := make(map[accounting.ID]*accounting.Account)
 	for i, a := range ledger.Accounts {
 		idAccount[a.ID] = ledger.Accounts[i]
 	}
 	query := `
-		SELECT datetime,transaction_id,account_id,description,(100*value)::integer,(100*balance)::integer FROM money
+		SELECT t.datetime, s.transaction_id, s.account_id, COALESCE(t.description, ''),
+			COALESCE(s.value, 0)::text, COALESCE(s.currency, '')
+		FROM split s
+		JOIN transaction t ON t.id=s.transaction_id
+		ORDER BY t.datetime, t.id
 	`
 	rows, err := c.db.Query(query)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var (
-			date    time.Time
-			tid     ID
-			aid     ID
-			desc    string
-			value   int64
-			balance int
+			date     time.Time
+			tid      ID
+			aid      ID
+			desc     string
+			value    string
+			currency string
 		)
-		if err := rows.Scan(&date, &tid, &aid, &desc, &value, &balance); err != nil {
-			panic(err)
+		if err := rows.Scan(&date, &tid, &aid, &desc, &value, &currency); err != nil {
+			return err
 		}
 		if l := len(ledger.Transactions); l == 0 || ledger.Transactions[l-1].ID != tid {
 			ledger.Transactions = append(ledger.Transactions, &accounting.Transaction{
@@ -123,12 +144,51 @@ func getTransactions(c *conn) {
 		}
 		split := new(accounting.Split)
 		split.Account = idAccount[aid]
-		split.Value.Currency = nil
-		split.Value.Amount = value
+		// Every split has an amount: a nil currency would make
+		// Fill take it as a split without amount.
+		split.Value.Currency = &c.currency
+		if currency != "" {
+			split.Value.Currency, _ = ledger.GetCurrency(currency)
+		}
+		amount, decimals, err := parseAmount(value)
+		if err != nil {
+			// the split is skipped, so its transaction will not balance:
+			c.backend.Errorf("transaction %d: %v", tid, err)
+			continue
+		}
+		split.Value.Amount = amount
+		if decimals > split.Value.Currency.Precision {
+			split.Value.Currency.Precision = decimals
+		}
 		tra := ledger.Transactions[len(ledger.Transactions)-1]
 		tra.Splits = append(tra.Splits, split)
 	}
-	return
+	return rows.Err()
+}
+
+// parseAmount converts a NUMERIC in text form to an amount times U,
+// returning also its number of decimal places, without trailing zeros
+// (NUMERIC columns with a scale keep them).
+func parseAmount(s string) (int64, int, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid amount %q", s)
+	}
+	r.Mul(r, big.NewRat(accounting.U, 1))
+	if !r.IsInt() {
+		return 0, 0, fmt.Errorf("amount %q has more than 8 decimal places", s)
+	}
+	if !r.Num().IsInt64() {
+		return 0, 0, fmt.Errorf("amount %q is too big", s)
+	}
+	decimals := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		decimals = len(strings.TrimRight(s[i+1:], "0"))
+	}
+	if decimals > 8 {
+		decimals = 8
+	}
+	return r.Num().Int64(), decimals, nil
 }
 
 // Flush is a no-op in SQL: all the writes to the database are unbuffered
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/cespedes/accounting"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s        string
+		amount   int64
+		decimals int
+	}{
+		{"10.25", 1025 * accounting.U / 100, 2},
+		{"10.50", 1050 * accounting.U / 100, 1},
+		{"-3", -3 * accounting.U, 0},
+		{"0.00012345", 12345, 8},
+		{"10.500000000", 1050 * accounting.U / 100, 1},
+		{"-2.000000000", -2 * accounting.U, 0},
+	}
+	for _, test := range tests {
+		amount, decimals, err := parseAmount(test.s)
+		if err != nil || amount != test.amount || decimals != test.decimals {
+			t.Errorf("parseAmount(%q) = %d, %d, %v (expected %d, %d)", test.s, amount, decimals, err, test.amount, test.decimals)
+		}
+	}
+	for _, s := range []string{"abc", "0.000000001"} {
+		if _, _, err := parseAmount(s); err == nil {
+			t.Errorf("parseAmount(%q) should fail", s)
+		}
+	}
+}
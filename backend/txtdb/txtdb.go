@@ -172,7 +172,7 @@ func (c *conn) read() error {
 				sign = -1
 				offset = 1
 			}
-			f, err := strconv.ParseFloat(fields[6][offset:], 64)
+			f, err := parseFloat(fields[6][offset:])
 			if err != nil {
 				log.Printf("transactions line %d: invalid balance (%s)", i, fields[6])
 				continue
@@ -192,14 +192,20 @@ func (c *conn) read() error {
 				log.Printf("transaction line %d: invalid value (%s)", i, fields[5])
 				continue
 			}
-			f, err := strconv.ParseFloat(fields[5][1:], 64)
+			f, err := parseFloat(fields[5][1:])
 			if err != nil {
 				log.Printf("transaction line %d: invalid value (%s)", i, fields[5])
 				continue
 			}
+			amount := sign * int64(math.Round(100*f)) * 1000_000
+			sum := balance + amount
+			if (balance > 0 && amount > 0 && sum < 0) || (balance < 0 && amount < 0 && sum >= 0) {
+				log.Printf("transaction line %d: invalid value (%s): %v", i, fields[5], accounting.ErrOverflow)
+				continue
+			}
 			sp.Value.Currency = &c.currency
-			sp.Value.Amount = sign * int64(math.Round(100*f)) * 1000_000
-			balance += sp.Value.Amount
+			sp.Value.Amount = amount
+			balance = sum
 		}
 		tr.Splits = append(tr.Splits, sp)
 		sp.Account.Splits = append(sp.Account.Splits, sp)
@@ -215,6 +221,22 @@ func (c *conn) read() error {
 	return nil
 }
 
+// parseFloat parses an amount without its sign, failing if it is not
+// a number or if it is too big to be stored in a Value.
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) {
+		return 0, fmt.Errorf("%s: not a number", s)
+	}
+	if math.Abs(f) >= math.MaxInt64/accounting.U {
+		return 0, accounting.ErrOverflow
+	}
+	return f, nil
+}
+
 func init() {
 	accounting.Register("txtdb", driver{})
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cespedes/accounting"
+	"github.com/cespedes/accounting/backend/ledger"
+)
+
+// runCheck shows the transactions which may have data-entry problems:
+// those with only one split, those with a split whose amount was
+// calculated to balance them, and those from which prices were
+// automatically added.
+func runCheck(L *accounting.Ledger, flags flags, args []string) error {
+	// automatic prices, by time:
+	automatic := make(map[int64][]*accounting.Price)
+	for _, p := range L.Prices {
+		if c := L.Comments[p]; p.ID == nil && len(c) > 0 && c[0] == "automatic" {
+			automatic[p.Time.UnixNano()] = append(automatic[p.Time.UnixNano()], p)
+		}
+	}
+	for _, t := range L.Transactions {
+		var splits []*accounting.Split
+		for _, s := range t.Splits {
+			if !s.Account.IsTransferAccount() {
+				splits = append(splits, s)
+			}
+		}
+		if len(splits) == 1 {
+			fmt.Printf("%v: transaction with only one split\n", t.ID)
+		}
+		times := []int64{t.Time.UnixNano()}
+		for _, s := range splits {
+			if L.Elided[s] {
+				id := s.ID
+				if id == nil {
+					id = t.ID
+				}
+				fmt.Printf("%v: amount of %s calculated as %s\n", id, s.Account.FullName(), s.Value)
+			}
+			if s.Time != nil && !containsTime(times, s.Time.UnixNano()) {
+				times = append(times, s.Time.UnixNano())
+			}
+		}
+		for _, when := range times {
+			for _, p := range automatic[when] {
+				if hasCurrency(splits, p.Currency) && hasCurrency(splits, p.Value.Currency) {
+					fmt.Printf("%v: automatic price: %s\n", t.ID, ledger.FormatPrice(p))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// containsTime tells whether a time (in nanoseconds) is in a list.
+func containsTime(times []int64, t int64) bool {
+	for _, x := range times {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCurrency tells whether any of the splits has a value in currency c.
+func hasCurrency(splits []*accounting.Split, c *accounting.Currency) bool {
+	for _, s := range splits {
+		if s.Value.Currency == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cespedes/accounting"
+)
+
+// config holds the user settings read from the configuration file
+// and from the environment.
+type config struct {
+	defaultCurrency string       // reporting currency, if the journal does not declare one
+	dateFormat      string       // Go layout used to display dates
+	precision       int          // decimal places of the reporting currency (-1 to keep it)
+	splitWindow     int          // max days between a split and its transaction (0 to disable)
+	incomePrefix    string       // start of the names of income accounts without a type
+	expensePrefix   string       // start of the names of expense accounts without a type
+	weekStart       time.Weekday // first day of the weeks in periodic reports
+}
+
+// configEnv maps every configuration key to the environment variable
+// which can be used instead.
+var configEnv = map[string]string{
+	"default-currency": "LEDGER_DEFAULT_CURRENCY",
+	"date-format":      "LEDGER_DATE_FORMAT",
+	"precision":        "LEDGER_PRECISION",
+	"split-window":     "LEDGER_SPLIT_WINDOW",
+	"income-prefix":    "LEDGER_INCOME_PREFIX",
+	"expense-prefix":   "LEDGER_EXPENSE_PREFIX",
+	"week-start":       "LEDGER_WEEK_START",
+}
+
+// configFile returns the name of the configuration file:
+// $LEDGER_CONFIG, or "ledger/config" in the user configuration directory.
+func configFile() string {
+	if name := os.Getenv("LEDGER_CONFIG"); name != "" {
+		return name
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ledger", "config")
+}
+
+// readConfig reads the configuration file, consisting in "key = value" lines,
+// and then the environment variables, which take precedence.
+func readConfig() (config, error) {
+	values := make(map[string]string)
+	if name := configFile(); name != "" {
+		f, err := os.Open(name)
+		if err != nil && !os.IsNotExist(err) {
+			return config{}, err
+		}
+		if err == nil {
+			defer f.Close()
+			s := bufio.NewScanner(f)
+			for lineNum := 1; s.Scan(); lineNum++ {
+				line := strings.TrimSpace(s.Text())
+				if line == "" || line[0] == '#' || line[0] == ';' {
+					continue
+				}
+				i := strings.IndexByte(line, '=')
+				if i < 0 {
+					return config{}, fmt.Errorf("%s:%d: syntax error", name, lineNum)
+				}
+				key := strings.TrimSpace(line[:i])
+				if _, ok := configEnv[key]; !ok {
+					return config{}, fmt.Errorf("%s:%d: unknown setting %q", name, lineNum, key)
+				}
+				values[key] = strings.TrimSpace(line[i+1:])
+			}
+			if err := s.Err(); err != nil {
+				return config{}, err
+			}
+		}
+	}
+	for key, env := range configEnv {
+		if value := os.Getenv(env); value != "" {
+			values[key] = value
+		}
+	}
+
+	conf := config{
+		defaultCurrency: values["default-currency"],
+		dateFormat:      values["date-format"],
+		precision:       -1,
+		splitWindow:     90,
+		incomePrefix:    "Income:",
+		expensePrefix:   "Expense:",
+		weekStart:       time.Monday,
+	}
+	if p := values["income-prefix"]; p != "" {
+		conf.incomePrefix = p
+	}
+	if p := values["expense-prefix"]; p != "" {
+		conf.expensePrefix = p
+	}
+	if p := values["precision"]; p != "" {
+		var err error
+		conf.precision, err = strconv.Atoi(p)
+		if err != nil || conf.precision < 0 || conf.precision > 8 {
+			return config{}, fmt.Errorf("invalid precision %q (must be between 0 and 8)", p)
+		}
+	}
+	if w := values["split-window"]; w != "" {
+		var err error
+		conf.splitWindow, err = strconv.Atoi(w)
+		if err != nil || conf.splitWindow < 0 {
+			return config{}, fmt.Errorf("invalid split-window %q (must be a number of days)", w)
+		}
+	}
+	if d := values["week-start"]; d != "" {
+		var err error
+		if conf.weekStart, err = parseWeekday(d); err != nil {
+			return config{}, err
+		}
+	}
+	return conf, nil
+}
+
+// parseWeekday returns the day of the week with a name
+// ("monday" or "mon", ignoring case).
+func parseWeekday(s string) (time.Weekday, error) {
+	name := strings.ToLower(s)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if day := strings.ToLower(d.String()); name == day || name == day[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid week-start %q (must be the name of a day)", s)
+}
+
+// apply sets the reporting currency and its precision in a ledger.
+// A default currency declared in the journal (with "D") takes precedence,
+// unless override is not empty.
+func (conf config) apply(L *accounting.Ledger, override string) {
+	name := override
+	if name == "" && (L.DefaultCurrency == nil || L.DefaultCurrency.Name == "") {
+		name = conf.defaultCurrency
+	}
+	if name != "" {
+		L.DefaultCurrency, _ = L.GetCurrency(name)
+	}
+	if conf.precision >= 0 && L.DefaultCurrency != nil {
+		L.DefaultCurrency.Precision = conf.precision
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/cespedes/accounting"
+)
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	r1, r2 := []rune(a), []rune(b)
+	prev := make([]int, len(r2)+1)
+	cur := make([]int, len(r2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(r1); i++ {
+		cur[0] = i
+		for j := 1; j <= len(r2); j++ {
+			cost := 1
+			if r1[i-1] == r2[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(r2)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// runLint shows the accounts with very few splits whose name is very similar
+// to the name of another account with more splits: they are probably typos.
+func runLint(L *accounting.Ledger, flags flags, args []string) error {
+	var maxSplits int
+	f := flag.NewFlagSet("lint", flag.ExitOnError)
+	f.IntVar(&maxSplits, "max-splits", 2, "only check accounts with at most this number of splits")
+	f.Parse(args)
+
+	for _, a := range L.Accounts {
+		if len(a.Splits) == 0 || len(a.Splits) > maxSplits {
+			continue
+		}
+		name := a.FullName()
+		var best *accounting.Account
+		bestDistance := 0
+		for _, b := range L.Accounts {
+			if b == a || b.Level != a.Level || len(b.Splits) <= len(a.Splits) {
+				continue
+			}
+			d := editDistance(strings.ToLower(name), strings.ToLower(b.FullName()))
+			if d > 2 || 4*d > len(name) {
+				continue
+			}
+			if best == nil || d < bestDistance || (d == bestDistance && len(b.Splits) > len(best.Splits)) {
+				best, bestDistance = b, d
+			}
+		}
+		if best == nil {
+			continue
+		}
+		fmt.Printf("%s: %d splits; did you mean %s (%d splits)?\n", name, len(a.Splits), best.FullName(), len(best.Splits))
+		for _, s := range a.Splits {
+			id := s.ID
+			if id == nil {
+				id = s.Transaction.ID
+			}
+			fmt.Printf("\t%v\n", id)
+		}
+	}
+	return nil
+}
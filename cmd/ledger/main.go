@@ -1,42 +1,91 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/cespedes/accounting"
 	"github.com/cespedes/accounting/backend/ledger"
+	"github.com/cespedes/accounting/provider/frankfurter"
 	"github.com/cespedes/tableview"
 )
 
 type flags struct {
-	total     bool // Show only total amounts
-	market    bool // Show market prices (all prices converted to default currency)
-	negate    bool // Display negate results in delta
-	batch     bool // Show computer-ready results
-	debug     bool
-	pivot     sliceString
-	currency  sliceString
-	beginDate time.Time
-	endDate   time.Time
+	total         bool   // Show only total amounts
+	market        bool   // Show market prices (all prices converted to default currency)
+	negate        bool   // Display negate results in delta
+	cleared       bool   // Only include cleared splits
+	pending       bool   // Only include pending splits
+	real          bool   // Do not include virtual splits
+	batch         bool   // Show computer-ready results
+	json          bool   // Show results in JSON
+	full          bool   // Show amounts with all their digits, not just the precision of their currency
+	width         int    // Maximum width of the reports (0: no limit)
+	priceDB       string // File with additional prices
+	debug         bool
+	dateFormat    string // Go layout used to display dates (empty for the default)
+	incomePrefix  string // Start of the names of income accounts without a type
+	expensePrefix string // Start of the names of expense accounts without a type
+	pivot         sliceString
+	currency      sliceString
+	tags          sliceString // Only include splits with these tags (name or name=value)
+	beginDate     time.Time
+	endDate       time.Time
+	openEnd       bool               // No end date was given, and endDate is just the current time
+	periodic      bool               // Show the balance changes in every interval of period (-p)
+	period        ledger.Period      // Length of the intervals, with periodic
+	weekStart     time.Weekday       // First day of the weekly intervals
+	untrimmed     *accounting.Ledger // Ledger before applying the begin and end dates
+	filename      string             // Journal file being read
 }
 
 var commands = map[string]func(ledger *accounting.Ledger, flags flags, args []string) error{
 	"accounts":        runAccounts,
 	"a":               runAccounts,
+	"payees":          runPayees,
 	"balance":         runBalance,
 	"bal":             runBalance,
 	"b":               runBalance,
 	"stats":           runStats,
 	"print":           runPrint,
+	"register":        runRegister,
+	"sort":            runSort,
+	"reg":             runRegister,
 	"incomestatement": runIncomeStatement,
 	"is":              runIncomeStatement,
 	"delta":           runDelta,
 	"price":           runPrice,
+	"prices":          runPrices,
+	"diff":            runDiff,
+	"reconcile":       runReconcile,
+	"gains":           runGains,
+	"lint":            runLint,
+	"check":           runCheck,
+	"merge-account":   runMergeAccount,
+}
+
+// needsHistory lists the commands which need all the transactions,
+// not only those between the begin and end dates.
+var needsHistory = map[string]bool{
+	"gains":         true,
+	"merge-account": true,
+}
+
+// trimsItself lists the commands which look at the begin and end dates
+// themselves, instead of having the ledger trimmed to them.
+var trimsItself = map[string]bool{
+	"balance": true,
+	"bal":     true,
+	"b":       true,
 }
 
 func runAccounts(L *accounting.Ledger, flags flags, args []string) error {
@@ -45,7 +94,7 @@ func runAccounts(L *accounting.Ledger, flags flags, args []string) error {
 	f.BoolVar(&treeFlag, "tree", false, "show short account names, as a tree")
 	f.Parse(args)
 
-	for _, a := range L.Accounts {
+	for _, a := range shownAccounts(L) {
 		if treeFlag {
 			fmt.Printf("%*.0s%s\n", 2*a.Level, " ", a.FullName())
 		} else {
@@ -55,11 +104,20 @@ func runAccounts(L *accounting.Ledger, flags flags, args []string) error {
 	return nil
 }
 
+func runPayees(L *accounting.Ledger, flags flags, args []string) error {
+	for _, p := range L.Payees() {
+		fmt.Println(p)
+	}
+	return nil
+}
+
 type account struct {
 	Name    string
 	Level   int
 	Account *accounting.Account
 	Balance accounting.Balance
+
+	Projected bool // Balance includes transactions generated by Forecast
 }
 
 func insertAccount(where *[]account, name string, level int, a *accounting.Account) {
@@ -74,33 +132,104 @@ func insertAccount(where *[]account, name string, level int, a *accounting.Accou
 		Account: a,
 	})
 	for _, b := range a.Children {
-		insertAccount(where, b.Name, level+1, b)
+		name := b.Name
+		if b.Alias != "" {
+			name = b.Alias
+		}
+		insertAccount(where, name, level+1, b)
 	}
 }
 
+// balanceUntil returns the balance of an account after its last split
+// not later than end (a zero end means there is no limit).
+func balanceUntil(a *accounting.Account, end time.Time) accounting.Balance {
+	n := len(a.Splits)
+	if !end.IsZero() {
+		n = sort.Search(n, func(i int) bool {
+			return a.Splits[i].Time.After(end)
+		})
+	}
+	if n == 0 {
+		return a.StartBalance
+	}
+	return a.Splits[n-1].Balance
+}
+
 func runBalance(L *accounting.Ledger, flags flags, args []string) error {
-	var maxLength int
+	column := amountColumn{full: flags.full}
 	var total accounting.Balance
 	var accounts []account
+	var projected bool // some balance includes forecast transactions
+	var naturalFlag, emptyFlag bool
+	var assertFlag, forecastFlag, commodityFlag string
+	f := flag.NewFlagSet("balance", flag.ExitOnError)
+	f.BoolVar(&naturalFlag, "natural", false, "show liabilities, equity and income as positive amounts")
+	f.BoolVar(&emptyFlag, "empty", false, "show accounts with a zero balance")
+	f.StringVar(&assertFlag, "assert", "", "fail unless the total in the currency of `amount` is that amount")
+	f.StringVar(&forecastFlag, "forecast", "", "add the periodic transactions from now until `date`")
+	f.StringVar(&commodityFlag, "commodity", "", "only show the amounts in this `commodity`, and the accounts with any of it")
+	f.Parse(args)
+	args = f.Args()
+	if forecastFlag != "" {
+		_, until, err := ledger.ParseDateRange("", forecastFlag)
+		if err != nil {
+			return fmt.Errorf("-forecast: %v", err)
+		}
+		if L, err = L.Forecast(time.Now(), until); err != nil {
+			return err
+		}
+	}
+	if flags.periodic {
+		return runPeriodicBalance(L, flags, args)
+	}
+	var commodity *accounting.Currency
+	if commodityFlag != "" {
+		var isNew bool
+		if commodity, isNew = L.GetCurrency(commodityFlag); isNew {
+			return fmt.Errorf("-commodity: unknown commodity %q", commodityFlag)
+		}
+	}
+	var expected accounting.Value
+	if assertFlag != "" {
+		var err error
+		if expected, err = ledger.ParseValue(L, assertFlag); err != nil {
+			return fmt.Errorf("-assert: %v", err)
+		}
+	}
+
 	if len(args) == 0 {
-		for _, a := range L.Accounts {
-			accounts = append(accounts, account{Name: a.Name, Level: a.Level, Account: a})
+		for _, a := range shownAccounts(L) {
+			name := a.Name
+			if a.Alias != "" {
+				name = a.Alias
+			}
+			accounts = append(accounts, account{Name: name, Level: a.Level, Account: a})
 		}
 	} else {
-		for _, a := range L.Accounts {
+		for _, a := range shownAccounts(L) {
 			for _, b := range args {
 				if strings.Contains(strings.ToLower(a.FullName()), strings.ToLower(b)) {
-					insertAccount(&accounts, a.FullName(), 0, a)
+					insertAccount(&accounts, a.DisplayName(), 0, a)
 					break
 				}
 			}
 		}
 	}
+	end := flags.endDate
+	if flags.openEnd {
+		end = time.Time{}
+	}
 	for i, a := range accounts {
-		accounts[i].Balance = a.Account.StartBalance
-		if len(a.Account.Splits) > 0 {
-			accounts[i].Balance = a.Account.Splits[len(a.Account.Splits)-1].Balance
+		// with a begin or end date, accounts without splits between
+		// them are left out, unless they have a balance from before:
+		balance := balanceUntil(a.Account, end)
+		if (!flags.beginDate.IsZero() || !end.IsZero()) && len(balance) == 0 &&
+			!a.Account.HasPostingsInInterval(flags.beginDate, end) {
+			continue
 		}
+		accounts[i].Balance = balance
+		accounts[i].Projected = forecastUntil(L, a.Account, end)
+		projected = projected || accounts[i].Projected
 		if len(flags.currency) > 0 {
 			var bal accounting.Balance
 			for _, v := range accounts[i].Balance {
@@ -112,6 +241,11 @@ func runBalance(L *accounting.Ledger, flags flags, args []string) error {
 			}
 			accounts[i].Balance = bal
 		}
+		if commodity != nil {
+			var bal accounting.Balance
+			bal.Add(accounts[i].Balance.TotalByCurrency(commodity))
+			accounts[i].Balance = bal
+		}
 		if flags.market {
 			var bal accounting.Balance
 			for _, v := range accounts[i].Balance {
@@ -124,43 +258,141 @@ func runBalance(L *accounting.Ledger, flags flags, args []string) error {
 			accounts[i].Balance = bal
 		}
 		for _, v := range accounts[i].Balance {
-			length := len(v.String())
-			if length > maxLength {
-				maxLength = length
-			}
 			total.Add(v)
 		}
+		// The total is the sum of the balances with their real signs:
+		if naturalFlag && a.Account.NaturalSign() < 0 {
+			accounts[i].Balance = accounts[i].Balance.Negate()
+		}
+		for _, v := range accounts[i].Balance {
+			column.fit(v)
+		}
+	}
+	// the report is shown even if the assertion fails:
+	var assertErr error
+	if assertFlag != "" {
+		if actual := total.TotalByCurrency(expected.Currency); actual.Cmp(expected) != 0 {
+			diff := actual
+			diff.Amount -= expected.Amount
+			assertErr = fmt.Errorf("wrong total: %s != %s (off by %s)", actual, expected, diff)
+		}
+	}
+	if !emptyFlag || commodity != nil {
+		// accounts with a zero balance are shown only if
+		// some of their descendants have a balance:
+		nonzero := make(map[*accounting.Account]bool)
+		for _, a := range accounts {
+			if len(a.Balance) > 0 {
+				for b := a.Account; b != nil; b = b.Parent {
+					nonzero[b] = true
+				}
+			}
+		}
+		var shown []account
+		for _, a := range accounts {
+			if nonzero[a.Account] {
+				shown = append(shown, a)
+			}
+		}
+		accounts = shown
+	}
+	if flags.json {
+		type jsonAccount struct {
+			Account   string             `json:"account"`
+			Balance   accounting.Balance `json:"balance"`
+			Projected bool               `json:"projected,omitempty"`
+		}
+		var out struct {
+			Accounts  []jsonAccount      `json:"accounts"`
+			Total     accounting.Balance `json:"total"`
+			Projected bool               `json:"projected,omitempty"`
+		}
+		out.Accounts = []jsonAccount{}
+		if !flags.total {
+			for _, a := range accounts {
+				out.Accounts = append(out.Accounts, jsonAccount{a.Account.FullName(), a.Balance, a.Projected})
+			}
+		}
+		out.Total = total
+		out.Projected = projected
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(out); err != nil {
+			return err
+		}
+		return assertErr
 	}
 	for _, v := range total {
-		length := len(v.String())
-		if length > maxLength {
-			maxLength = length
+		column.fit(v)
+	}
+	maxLength := column.width()
+	if maxLength < 1 {
+		maxLength = 1 // the width of "0", shown as the total of nothing
+	}
+	// nameWidth is the room left for an account name after its amount
+	// and indentation, to fit in flags.width (0: no limit).
+	nameWidth := func(level int) int {
+		if flags.width <= 0 {
+			return 0
+		}
+		if w := flags.width - maxLength - 1 - 2*level; w > 1 {
+			return w
 		}
+		return 1
 	}
-	if !flags.total {
+	if !flags.total && len(accounts) > 0 {
 		for _, a := range accounts {
-			if len(a.Account.Splits) > 0 {
-				for i, v := range a.Balance {
-					fmt.Printf("%*.*s", maxLength, maxLength, v.String())
+			if len(a.Account.Splits) > 0 && len(a.Balance) == 0 {
+				// shown with -empty:
+				fmt.Printf("%s %*.0s%s%s\n", padLeft("0", maxLength), 2*a.Level, " ", elide(a.Name, nameWidth(a.Level)), projectedMark(a.Projected))
+			} else if len(a.Account.Splits) > 0 || len(a.Balance) > 0 {
+				for i, v := range a.Balance.Sorted() {
 					if i == len(a.Balance)-1 {
-						fmt.Printf(" %*.0s%s\n", 2*a.Level, " ", a.Name)
+						fmt.Printf("%s %*.0s%s%s\n", column.format(v), 2*a.Level, " ", elide(a.Name, nameWidth(a.Level)), projectedMark(a.Projected))
 					} else {
-						fmt.Println()
+						fmt.Println(strings.TrimRight(column.format(v), " "))
 					}
 				}
 			} else {
-				fmt.Printf("%*.0s%s\n", maxLength+1+2*a.Level, " ", a.Name)
+				fmt.Printf("%*.0s%s\n", maxLength+1+2*a.Level, " ", elide(a.Name, nameWidth(a.Level)))
 			}
 		}
 		fmt.Println(strings.Repeat("-", maxLength))
 	}
 	if len(total) == 0 {
-		fmt.Println("0")
+		fmt.Println("0" + projectedMark(projected))
 	}
-	for _, v := range total {
-		fmt.Printf("%*.*s\n", maxLength, maxLength, v.String())
+	for i, v := range total.Sorted() {
+		var mark string
+		if i == len(total)-1 {
+			mark = projectedMark(projected)
+		}
+		fmt.Println(strings.TrimRight(column.format(v), " ") + mark)
 	}
-	return nil
+	return assertErr
+}
+
+// forecastUntil tells whether the balance of an account until end
+// (zero: with no limit) includes transactions generated by Forecast.
+func forecastUntil(L *accounting.Ledger, a *accounting.Account, end time.Time) bool {
+	for _, s := range a.Splits {
+		if !end.IsZero() && s.Time.After(end) {
+			break
+		}
+		if L.IsForecast(s.Transaction) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectedMark is shown after the balances which include transactions
+// generated by Forecast.
+func projectedMark(projected bool) string {
+	if projected {
+		return " (projected)"
+	}
+	return ""
 }
 
 func runStats(L *accounting.Ledger, flags flags, args []string) error {
@@ -175,9 +407,14 @@ func runStats(L *accounting.Ledger, flags flags, args []string) error {
 		start := time.Date(firstYear, firstMonth, firstDay, 0, 0, 0, 0, time.UTC)
 		days := int(end.Sub(start).Hours()/24.0) + 1
 
-		fmt.Printf("Transaction span : %s to %s (%d days)\n", first.Format("2006-01-02"),
-			last.Format("2006-01-02"), days)
+		format := flags.dateFormat
+		if format == "" {
+			format = "2006-01-02"
+		}
+		fmt.Printf("Transaction span : %s to %s (%d days)\n", first.Format(format),
+			last.Format(format), days)
 		fmt.Printf("Transactions     : %d (%.1f per day)\n", len(L.Transactions), float64(len(L.Transactions))/float64(days))
+		fmt.Printf("Splits           : %d (%.1f per transaction)\n", L.SplitCount(), float64(L.SplitCount())/float64(len(L.Transactions)))
 		fmt.Printf("Accounts         : %d\n", len(L.Accounts))
 		fmt.Printf("Commodities      : %d (", len(L.Currencies))
 		for i, c := range L.Currencies {
@@ -193,32 +430,154 @@ func runStats(L *accounting.Ledger, flags flags, args []string) error {
 }
 
 func runPrint(L *accounting.Ledger, flags flags, args []string) error {
-	ledger.Export(os.Stdout, L)
-	return nil
+	var output string
+	f := flag.NewFlagSet("print", flag.ExitOnError)
+	f.StringVar(&output, "o", "", "write to this file instead of standard output")
+	f.Parse(args)
+
+	return writeJournal(L, flags, output)
+}
+
+// runSort writes the journal with its transactions and prices sorted by
+// time, keeping the original order of the ones with the same time.
+func runSort(L *accounting.Ledger, flags flags, args []string) error {
+	var output string
+	f := flag.NewFlagSet("sort", flag.ExitOnError)
+	f.StringVar(&output, "o", "", "write to this file instead of standard output")
+	f.Parse(args)
+
+	sort.SliceStable(L.Transactions, func(i, j int) bool {
+		return L.Transactions[i].Time.Before(L.Transactions[j].Time)
+	})
+	sort.SliceStable(L.Prices, func(i, j int) bool {
+		return L.Prices[i].Time.Before(L.Prices[j].Time)
+	})
+	return writeJournal(L, flags, output)
+}
+
+// writeJournal exports a ledger to standard output or, if not empty,
+// to the output file, which must not be the input journal.
+func writeJournal(L *accounting.Ledger, flags flags, output string) error {
+	if output == "" {
+		ledger.Export(os.Stdout, L)
+		return nil
+	}
+	if fi1, err := os.Stat(output); err == nil {
+		if fi2, err := os.Stat(flags.filename); err == nil && os.SameFile(fi1, fi2) {
+			return fmt.Errorf("refusing to overwrite input file %s", output)
+		}
+	}
+	return ledger.ExportFile(output, L)
+}
+
+// isType tells whether an account is of a given type: the one given
+// to it (or to an ancestor) with a "type:" tag, or else the one
+// implied by its full name starting with prefix.
+func isType(a *accounting.Account, t accounting.AccountType, prefix string) bool {
+	if at := a.EffectiveType(); at != accounting.Untyped {
+		return at == t
+	}
+	return strings.HasPrefix(a.FullName(), prefix)
+}
+
+// isTypeRoot is like isType, but an account without a type also matches
+// if its name is prefix without the final ":" (as "Income" for "Income:").
+func isTypeRoot(a *accounting.Account, t accounting.AccountType, prefix string) bool {
+	if a.EffectiveType() == accounting.Untyped && a.FullName() == strings.TrimSuffix(prefix, ":") {
+		return true
+	}
+	return isType(a, t, prefix)
+}
+
+// shownAccounts returns the accounts to be listed in the reports:
+// all of them but TransferAccount, unless Fill has used it.
+func shownAccounts(L *accounting.Ledger) []*accounting.Account {
+	var accounts []*accounting.Account
+	for _, a := range L.Accounts {
+		if !a.IsTransferAccount() || len(a.Splits) > 0 {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts
+}
+
+// treeRoots returns the accounts in a list with no ancestors in it.
+func treeRoots(accounts []*accounting.Account) []*accounting.Account {
+	in := make(map[*accounting.Account]bool)
+	for _, a := range accounts {
+		in[a] = true
+	}
+	var roots []*accounting.Account
+	for _, a := range accounts {
+		root := true
+		for p := a.Parent; p != nil; p = p.Parent {
+			if in[p] {
+				root = false
+				break
+			}
+		}
+		if root {
+			roots = append(roots, a)
+		}
+	}
+	return roots
+}
+
+// subtreeHasSplits tells whether an account or any of its descendants
+// has any split.
+func subtreeHasSplits(a *accounting.Account) bool {
+	if len(a.Splits) > 0 {
+		return true
+	}
+	for _, c := range a.Children {
+		if subtreeHasSplits(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// subtreeStartBalance returns the sum of the StartBalance
+// of an account and all its descendants.
+func subtreeStartBalance(a *accounting.Account) accounting.Balance {
+	b := a.StartBalance.Dup()
+	for _, c := range a.Children {
+		b.AddBalance(subtreeStartBalance(c))
+	}
+	return b
 }
 
 func runIncomeStatement(L *accounting.Ledger, flags flags, args []string) error {
-	var incomeAccounts, expenseAccounts []*accounting.Account
-	var incomes, expenses []struct {
+	type row struct {
 		name    string
-		balance string
+		balance accounting.Balance
 	}
+	var incomeAccounts, expenseAccounts []*accounting.Account
+	var incomes, expenses []row
 	var income, expense, net accounting.Balance
 	var nameLen = 8
 	var balanceLen = 1
+	var treeFlag bool
+
+	f := flag.NewFlagSet("incomestatement", flag.ExitOnError)
+	f.BoolVar(&treeFlag, "tree", false, "show accounts as a tree, with subtotals")
+	f.StringVar(&flags.incomePrefix, "income-prefix", flags.incomePrefix, "start of the names of income accounts")
+	f.StringVar(&flags.expensePrefix, "expense-prefix", flags.expensePrefix, "start of the names of expense accounts")
+	f.Parse(args)
+	args = f.Args()
 
 	if len(args) == 0 {
 		for _, a := range L.Accounts {
-			if strings.HasPrefix(a.FullName(), "Income:") {
+			if isType(a, accounting.Income, flags.incomePrefix) {
 				incomeAccounts = append(incomeAccounts, a)
 			}
-			if strings.HasPrefix(a.FullName(), "Expense:") {
+			if isType(a, accounting.Expense, flags.expensePrefix) {
 				expenseAccounts = append(expenseAccounts, a)
 			}
 		}
 	} else {
 		for _, a := range L.Accounts {
-			if !strings.HasPrefix(a.FullName(), "Income") {
+			if !isTypeRoot(a, accounting.Income, flags.incomePrefix) {
 				continue
 			}
 			for _, b := range args {
@@ -229,7 +588,7 @@ func runIncomeStatement(L *accounting.Ledger, flags flags, args []string) error
 			}
 		}
 		for _, a := range L.Accounts {
-			if !strings.HasPrefix(a.FullName(), "Expense") {
+			if !isTypeRoot(a, accounting.Expense, flags.expensePrefix) {
 				continue
 			}
 			for _, b := range args {
@@ -241,72 +600,115 @@ func runIncomeStatement(L *accounting.Ledger, flags flags, args []string) error
 		}
 	}
 
-	for _, a := range incomeAccounts {
-		if len(a.Splits) > 0 {
-			b := a.Splits[0].Balance.Dup()
-			b.SubBalance(a.Splits[len(a.Splits)-1].Balance)
-			b.Sub(a.Splits[0].Value)
-			incomes = append(incomes, struct {
-				name    string
-				balance string
-			}{a.FullName(), b.String()})
-			income.AddBalance(b)
+	if treeFlag {
+		// addTree appends an account and its descendants to rows, indented
+		// by level, with what every subtree has changed in the period.
+		// It returns the change in the account's subtree.
+		var addTree func(rows *[]row, name string, a *accounting.Account, level int, negate bool) accounting.Balance
+		addTree = func(rows *[]row, name string, a *accounting.Account, level int, negate bool) accounting.Balance {
+			if !subtreeHasSplits(a) {
+				return nil
+			}
+			b := a.SubtreeBalance()
+			b.SubBalance(subtreeStartBalance(a))
+			if negate {
+				b = b.Negate()
+			}
+			*rows = append(*rows, row{strings.Repeat("  ", level) + name, b})
+			for _, c := range a.Children {
+				name := c.Name
+				if c.Alias != "" {
+					name = c.Alias
+				}
+				addTree(rows, name, c, level+1, negate)
+			}
+			return b
 		}
-	}
-	for _, a := range expenseAccounts {
-		if len(a.Splits) > 0 {
-			b := a.Splits[len(a.Splits)-1].Balance.Dup()
-			b.SubBalance(a.Splits[0].Balance)
-			b.Add(a.Splits[0].Value)
-			expenses = append(expenses, struct {
-				name    string
-				balance string
-			}{a.FullName(), b.String()})
-			expense.AddBalance(b)
+		for _, a := range treeRoots(incomeAccounts) {
+			income.AddBalance(addTree(&incomes, a.DisplayName(), a, 0, true))
+		}
+		for _, a := range treeRoots(expenseAccounts) {
+			expense.AddBalance(addTree(&expenses, a.DisplayName(), a, 0, false))
+		}
+	} else {
+		for _, a := range incomeAccounts {
+			if len(a.Splits) > 0 {
+				b := a.Splits[0].Balance.Dup()
+				b.SubBalance(a.Splits[len(a.Splits)-1].Balance)
+				b.Sub(a.Splits[0].Value)
+				incomes = append(incomes, row{a.DisplayName(), b})
+				income.AddBalance(b)
+			}
+		}
+		for _, a := range expenseAccounts {
+			if len(a.Splits) > 0 {
+				b := a.Splits[len(a.Splits)-1].Balance.Dup()
+				b.SubBalance(a.Splits[0].Balance)
+				b.Add(a.Splits[0].Value)
+				expenses = append(expenses, row{a.DisplayName(), b})
+				expense.AddBalance(b)
+			}
 		}
 	}
 	net = income.Dup()
 	net.SubBalance(expense)
-	for _, i := range incomes {
-		if len(i.name) > nameLen {
-			nameLen = len(i.name)
+	balances := []accounting.Balance{income, expense, net}
+	for _, i := range append(incomes, expenses...) {
+		if w := textWidth(i.name); w > nameLen {
+			nameLen = w
 		}
-		if len(i.balance) > balanceLen {
-			balanceLen = len(i.balance)
+		balances = append(balances, i.balance)
+	}
+	for _, b := range balances {
+		for _, v := range b {
+			if w := textWidth(valueString(v, flags.full)); w > balanceLen {
+				balanceLen = w
+			}
 		}
 	}
-	for _, i := range expenses {
-		if len(i.name) > nameLen {
-			nameLen = len(i.name)
+	// long names are elided to fit in flags.width, keeping the amounts:
+	if flags.width > 0 && nameLen+balanceLen+6 > flags.width {
+		nameLen = flags.width - balanceLen - 6
+		if nameLen < 1 {
+			nameLen = 1
 		}
-		if len(i.balance) > balanceLen {
-			balanceLen = len(i.balance)
+	}
+	// printRow shows a name and a balance, with every currency in its own line:
+	printRow := func(name string, b accounting.Balance) {
+		name = elide(name, nameLen)
+		if len(b) == 0 {
+			fmt.Printf(" %s || %s\n", padRight(name, nameLen), padLeft("0", balanceLen))
+			return
+		}
+		for _, v := range b.Sorted() {
+			fmt.Printf(" %s || %s\n", padRight(name, nameLen), padLeft(valueString(v, flags.full), balanceLen))
+			name = ""
 		}
 	}
 	if flags.total {
-		fmt.Println(net)
+		fmt.Println(balanceString(net, flags.full))
 		return nil
 	}
 	fmt.Println("Income Statement")
 	fmt.Println()
 	fmt.Print(strings.Repeat("=", nameLen+2), "++", strings.Repeat("=", balanceLen+2), "\n")
-	fmt.Printf(" %-*s ||\n", nameLen, "Revenues")
+	fmt.Printf(" %s ||\n", padRight(elide("Revenues", nameLen), nameLen))
 	fmt.Print(strings.Repeat("-", nameLen+2), "++", strings.Repeat("-", balanceLen+2), "\n")
 	for _, i := range incomes {
-		fmt.Printf(" %-*s || %*s\n", nameLen, i.name, balanceLen, i.balance)
+		printRow(i.name, i.balance)
 	}
 	fmt.Print(strings.Repeat("-", nameLen+2), "++", strings.Repeat("-", balanceLen+2), "\n")
-	fmt.Printf(" %s || %*s\n", strings.Repeat(" ", nameLen), balanceLen, income)
+	printRow("", income)
 	fmt.Print(strings.Repeat("=", nameLen+2), "++", strings.Repeat("=", balanceLen+2), "\n")
-	fmt.Printf(" %-*s ||\n", nameLen, "Expenses")
+	fmt.Printf(" %s ||\n", padRight(elide("Expenses", nameLen), nameLen))
 	fmt.Print(strings.Repeat("-", nameLen+2), "++", strings.Repeat("-", balanceLen+2), "\n")
 	for _, e := range expenses {
-		fmt.Printf(" %-*s || %*s\n", nameLen, e.name, balanceLen, e.balance)
+		printRow(e.name, e.balance)
 	}
 	fmt.Print(strings.Repeat("-", nameLen+2), "++", strings.Repeat("-", balanceLen+2), "\n")
-	fmt.Printf(" %s || %*s\n", strings.Repeat(" ", nameLen), balanceLen, expense)
+	printRow("", expense)
 	fmt.Print(strings.Repeat("=", nameLen+2), "++", strings.Repeat("=", balanceLen+2), "\n")
-	fmt.Printf(" %-*s || %*s\n", nameLen, "Net:", balanceLen, net)
+	printRow("Net:", net)
 	return nil
 }
 
@@ -353,11 +755,9 @@ func runDelta(L *accounting.Ledger, flags flags, args []string) error {
 		balanceDelta.SubBalance(bal1)
 	}
 	if flags.negate {
-		var b2 accounting.Balance
-		b2.SubBalance(balanceDelta)
-		balanceDelta = b2
+		balanceDelta = balanceDelta.Negate()
 	}
-	fmt.Println(balanceDelta)
+	fmt.Println(balanceString(balanceDelta, flags.full))
 	return nil
 }
 
@@ -379,6 +779,275 @@ func runPrice(L *accounting.Ledger, flags flags, args []string) error {
 	return nil
 }
 
+func runPrices(L *accounting.Ledger, flags flags, args []string) error {
+	var fillFlag bool
+	f := flag.NewFlagSet("prices", flag.ExitOnError)
+	f.BoolVar(&fillFlag, "fill", false, "add interpolated daily prices")
+	f.Parse(args)
+
+	if f.Arg(0) == "fetch" {
+		return runFetch(L, flags, f.Args()[1:])
+	}
+	if fillFlag {
+		L.InterpolatePrices()
+	}
+	for _, p := range L.Prices {
+		if p.Time.Before(flags.beginDate) || p.Time.After(flags.endDate) {
+			continue
+		}
+		if len(f.Args()) > 0 {
+			found := false
+			for _, c := range f.Args() {
+				if p.Currency.Name == c {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		fmt.Println(ledger.FormatPrice(p))
+	}
+	return nil
+}
+
+// runFetch gets the exchange rate between two currencies from the Internet
+// and writes it as a "P" directive.
+func runFetch(L *accounting.Ledger, flags flags, args []string) error {
+	var output string
+	f := flag.NewFlagSet("fetch", flag.ExitOnError)
+	f.StringVar(&output, "o", flags.priceDB, "append the price to this file instead of showing it")
+	f.Parse(args)
+	if f.NArg() != 2 {
+		return errors.New("usage: prices fetch [-o file] <from> <to>")
+	}
+
+	var provider accounting.PriceProvider = frankfurter.Provider{}
+	from, _ := L.GetCurrency(f.Arg(0))
+	to, _ := L.GetCurrency(f.Arg(1))
+	value, err := provider.Fetch(from, to, flags.endDate)
+	if err != nil {
+		return err
+	}
+	date := flags.endDate
+	price := &accounting.Price{
+		Time:     time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC),
+		Currency: from,
+		Value:    value,
+	}
+	line := ledger.FormatPrice(price) + "\n"
+	if output == "" {
+		fmt.Print(line)
+		return nil
+	}
+	file, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func runDiff(L *accounting.Ledger, flags flags, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: diff <journal>")
+	}
+	L2, err := accounting.Open(args[0])
+	if err != nil {
+		return err
+	}
+	for _, c := range accounting.Diff(L, L2) {
+		sign := map[accounting.ChangeKind]string{
+			accounting.Added:    "+",
+			accounting.Removed:  "-",
+			accounting.Modified: "~",
+		}[c.Kind]
+		obj := c.New
+		if obj == nil {
+			obj = c.Old
+		}
+		switch x := obj.(type) {
+		case *accounting.Account:
+			fmt.Printf("%s account %s (%s)\n", sign, x.FullName(), x.ID)
+		case *accounting.Transaction:
+			fmt.Printf("%s transaction %s %s (%s)\n", sign, x.Time.Format("2006-01-02"), x.Description, x.ID)
+		}
+	}
+	return nil
+}
+
+// findAccount returns the account with that full name or, if there is none,
+// the only account whose name contains it.
+func findAccount(L *accounting.Ledger, name string) (*accounting.Account, error) {
+	var found *accounting.Account
+	accounts := shownAccounts(L)
+	for _, a := range accounts {
+		if a.FullName() == name {
+			return a, nil
+		}
+	}
+	for _, a := range accounts {
+		if strings.Contains(strings.ToLower(a.FullName()), strings.ToLower(name)) {
+			if found != nil {
+				return nil, fmt.Errorf("ambiguous account %q", name)
+			}
+			found = a
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("account %q not found", name)
+	}
+	return found, nil
+}
+
+// balanceIs returns whether a balance consists exactly in one value.
+func balanceIs(b accounting.Balance, v accounting.Value) bool {
+	if len(b) == 0 {
+		return v.Amount == 0
+	}
+	return len(b) == 1 && b[0] == v
+}
+
+func runReconcile(L *accounting.Ledger, flags flags, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: reconcile <account> <statement balance>")
+	}
+	account, err := findAccount(L, args[0])
+	if err != nil {
+		return err
+	}
+	target, err := ledger.ParseValue(L, args[1])
+	if err != nil {
+		return err
+	}
+	if target.Currency == nil || target.Currency.Name == "" {
+		// no currency given: use the one in the account
+		for _, s := range account.Splits {
+			if s.Value.Currency != nil {
+				target.Currency = s.Value.Currency
+				break
+			}
+		}
+	}
+	var cleared accounting.Balance
+	var uncleared []*accounting.Split
+	for _, s := range account.Splits {
+		if s.State == accounting.Cleared {
+			cleared.Add(s.Value)
+		} else {
+			uncleared = append(uncleared, s)
+		}
+	}
+	balance := cleared.Dup()
+	n := -1
+	if balanceIs(balance, target) {
+		n = 0
+	}
+	for i := 0; n < 0 && i < len(uncleared); i++ {
+		balance.Add(uncleared[i].Value)
+		if balanceIs(balance, target) {
+			n = i + 1
+		}
+	}
+	if n < 0 {
+		diff := accounting.Balance{target}
+		diff.SubBalance(balance)
+		fmt.Printf("Cleared balance   : %s\n", balanceString(cleared, flags.full))
+		fmt.Printf("Total balance     : %s\n", balanceString(balance, flags.full))
+		fmt.Printf("Statement balance : %s\n", valueString(target, flags.full))
+		fmt.Printf("Difference        : %s\n", balanceString(diff, flags.full))
+		return fmt.Errorf("no uncleared splits in %s add up to %s", account.FullName(), target)
+	}
+	var transactions []*accounting.Transaction
+	for _, s := range uncleared[:n] {
+		s.State = accounting.Cleared
+		fmt.Printf("%s %-40s %s\n", s.Time.Format("2006-01-02"), s.Transaction.Description, valueString(s.Value, flags.full))
+		if len(transactions) == 0 || transactions[len(transactions)-1] != s.Transaction {
+			transactions = append(transactions, s.Transaction)
+		}
+	}
+	for _, t := range transactions {
+		if _, err := L.EditTransaction(*t); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d splits cleared; cleared balance is now %s\n", n, valueString(target, flags.full))
+	return L.Flush()
+}
+
+// runMergeAccount moves all the splits and subaccounts of one account
+// to another one, and prints the resulting journal.
+func runMergeAccount(L *accounting.Ledger, flags flags, args []string) error {
+	var output string
+	f := flag.NewFlagSet("merge-account", flag.ExitOnError)
+	f.StringVar(&output, "o", "", "write to this file instead of standard output")
+	f.Parse(args)
+	if f.NArg() != 2 {
+		return errors.New("usage: merge-account [-o file] <src> <dst>")
+	}
+
+	L = flags.untrimmed
+	src, err := findAccount(L, f.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, err := findAccount(L, f.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := L.MergeAccount(src, dst); err != nil {
+		return err
+	}
+	return writeJournal(L, flags, output)
+}
+
+func runGains(L *accounting.Ledger, flags flags, args []string) error {
+	var methodName string
+	f := flag.NewFlagSet("gains", flag.ExitOnError)
+	f.StringVar(&methodName, "method", "fifo", "cost basis method: fifo, lifo or average")
+	f.Parse(args)
+	args = f.Args()
+
+	method, err := accounting.ParseCostMethod(methodName)
+	if err != nil {
+		return err
+	}
+	var total accounting.Balance
+	for _, a := range flags.untrimmed.Accounts {
+		if len(args) > 0 {
+			found := false
+			for _, b := range args {
+				if strings.Contains(strings.ToLower(a.FullName()), strings.ToLower(b)) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		disposals, err := flags.untrimmed.Disposals(a, method)
+		if err != nil {
+			return err
+		}
+		for _, d := range disposals {
+			if d.Split.Time.Before(flags.beginDate) || d.Split.Time.After(flags.endDate) {
+				continue
+			}
+			fmt.Printf("%s %-30s %12s  proceeds %12s  basis %12s  gain %12s\n",
+				d.Split.Time.Format("2006-01-02"), a.FullName(), valueString(d.Split.Value.Negate(), flags.full),
+				valueString(d.Proceeds, flags.full), valueString(d.Basis, flags.full), valueString(d.Gain(), flags.full))
+			total.Add(d.Gain())
+		}
+	}
+	fmt.Printf("Total gain: %s\n", balanceString(total, flags.full))
+	return nil
+}
+
 func Usage() {
 	log.Fatalln("usage: ledger [options] <command> [args]")
 }
@@ -404,31 +1073,117 @@ func transactionInPivot(t *accounting.Transaction, pivot sliceString) bool {
 	return false
 }
 
-func doPivot(L *accounting.Ledger, pivot sliceString) {
-	if len(pivot) == 0 {
-		return
+// doState removes the splits whose state is not in states.
+func doState(L *accounting.Ledger, states ...accounting.State) {
+	doFilter(L, func(s *accounting.Split) bool {
+		for _, st := range states {
+			if s.State == st {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// doTags removes the splits which do not have all the given tags.
+// Every tag is "name" or "name=value", and splits inherit
+// the tags of their transaction.
+func doTags(L *accounting.Ledger, tags sliceString) {
+	doFilter(L, func(s *accounting.Split) bool {
+		for _, tag := range tags {
+			name, value := tag, ""
+			if i := strings.IndexByte(tag, '='); i > -1 {
+				name, value = tag[:i], tag[i+1:]
+			}
+			v, ok := L.TagValue(s, name)
+			if !ok || (value != "" && v != value) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// doFilter removes the splits for which keep returns false,
+// and the transactions left without splits, and recomputes
+// the balances of every account.
+func doFilter(L *accounting.Ledger, keep func(*accounting.Split) bool) {
+	var transactions []*accounting.Transaction
+	for _, t := range L.Transactions {
+		var splits []*accounting.Split
+		for _, s := range t.Splits {
+			if keep(s) {
+				splits = append(splits, s)
+			}
+		}
+		if len(splits) > 0 {
+			t.Splits = splits
+			transactions = append(transactions, t)
+		}
 	}
-	for i := 0; i < len(L.Transactions); i++ {
-		if !transactionInPivot(L.Transactions[i], pivot) {
-			L.Transactions = append(L.Transactions[:i], L.Transactions[i+1:]...)
-			i--
+	L.Transactions = transactions
+	for _, a := range L.Accounts {
+		var splits []*accounting.Split
+		balance := a.StartBalance.Dup()
+		for _, s := range a.Splits {
+			if keep(s) {
+				balance.Add(s.Value)
+				s.Balance = balance.Dup()
+				splits = append(splits, s)
+			}
 		}
+		a.Splits = splits
 	}
-	for i := range L.Accounts {
-		for j := 0; j < len(L.Accounts[i].Splits); j++ {
-			if !transactionInPivot(L.Accounts[i].Splits[j].Transaction, pivot) {
-				L.Accounts[i].Splits = append(L.Accounts[i].Splits[:j], L.Accounts[i].Splits[j+1:]...)
-				j--
+}
+
+// expandArgs replaces every "@file" argument with the lines in that file,
+// one argument per line.  Files can reference other files the same way;
+// relative names are resolved from the directory of the referencing file.
+func expandArgs(args []string, dir string, seen map[string]bool) ([]string, error) {
+	var res []string
+	for _, arg := range args {
+		if len(arg) < 2 || arg[0] != '@' {
+			res = append(res, arg)
+			continue
+		}
+		filename := arg[1:]
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		if seen[filename] {
+			return nil, fmt.Errorf("%s: recursive reference to arguments file", arg)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", arg, err)
+		}
+		var lines []string
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimRight(line, "\r")
+			if line != "" {
+				lines = append(lines, line)
 			}
 		}
+		seen[filename] = true
+		lines, err = expandArgs(lines, filepath.Dir(filename), seen)
+		if err != nil {
+			return nil, err
+		}
+		delete(seen, filename)
+		res = append(res, lines...)
 	}
+	return res, nil
 }
 
 func main() {
 	var L *accounting.Ledger
 	var err error
 	var filename string
-	os.Args = os.Args[1:]
+	os.Args, err = expandArgs(os.Args[1:], ".", make(map[string]bool))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ledger: %s\n", err.Error())
+		os.Exit(1)
+	}
 	if len(os.Args) >= 2 && os.Args[0] == "-f" {
 		filename = os.Args[1]
 		os.Args = os.Args[2:]
@@ -440,116 +1195,125 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Please use option -f or environment variable LEDGER_FILE")
 		os.Exit(1)
 	}
+	conf, err := readConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ledger: config: %s\n", err.Error())
+		os.Exit(1)
+	}
 	L, err = accounting.Open(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err.Error())
 		os.Exit(1)
 	}
+	if conf.splitWindow > 0 {
+		for _, w := range L.CheckSplitTimes(time.Duration(conf.splitWindow) * 24 * time.Hour) {
+			fmt.Fprintf(os.Stderr, "ledger: warning: %s\n", w)
+		}
+	}
 	begin := 0
 	for i := range os.Args {
 		if os.Args[i] == "--" {
 			if begin != i {
-				main2(L.Clone(), os.Args[begin:i])
+				main2(L.Clone(), conf, filename, os.Args[begin:i])
 			}
 			begin = i + 1
 		}
 	}
 	if begin == 0 || begin < len(os.Args) {
-		main2(L.Clone(), os.Args[begin:])
+		main2(L.Clone(), conf, filename, os.Args[begin:])
 	}
 }
 
-func main2(L *accounting.Ledger, args []string) {
+func main2(L *accounting.Ledger, conf config, filename string, args []string) {
 	var flags flags
 	var err error
-	var txtBeginDate, txtEndDate, txtPeriod string
-	flags.endDate = time.Now()
+	var txtBeginDate, txtEndDate, txtPeriod, defaultCurrency string
+	var completeAccounts, completeCommodities bool
+	flags.filename = filename
+	flags.dateFormat = conf.dateFormat
+	flags.incomePrefix = conf.incomePrefix
+	flags.expensePrefix = conf.expensePrefix
+	flags.weekStart = conf.weekStart
 	f := flag.NewFlagSet("ledger", flag.ExitOnError)
 
 	f.StringVar(&txtBeginDate, "b", "", "begin date")
 	f.StringVar(&txtEndDate, "e", "", "end date")
-	f.StringVar(&txtPeriod, "p", "", "period")
+	f.StringVar(&txtPeriod, "p", "", "show balance changes by `period`: daily, weekly, monthly, quarterly or yearly")
 	f.Var(&flags.pivot, "pivot", "restrict transactions to those involving accounts with this partial name")
 	f.Var(&flags.currency, "currency", "only show balances in this currency")
+	f.Var(&flags.tags, "tag", "only include splits with this tag (name or name=value)")
+	f.StringVar(&defaultCurrency, "default-currency", "", "reporting currency (overrides the journal's default)")
+	f.StringVar(&flags.priceDB, "pricedb", "", "read additional prices from this file")
 	f.BoolVar(&flags.batch, "batch", false, "show computer-ready results")
+	f.BoolVar(&flags.json, "json", false, "show results in JSON")
+	f.BoolVar(&flags.full, "full", false, "show amounts with all their digits")
 	f.BoolVar(&flags.market, "market", false, "show amounts converted to market value")
 	f.BoolVar(&flags.total, "total", false, "show only total amounts")
 	f.BoolVar(&flags.negate, "negate", false, "change values from negative to positive (and vice versa)")
+	f.BoolVar(&flags.cleared, "cleared", false, "only include cleared splits")
+	f.BoolVar(&flags.pending, "pending", false, "only include pending splits")
+	f.BoolVar(&flags.real, "real", false, "do not include virtual splits")
+	f.IntVar(&flags.width, "width", terminalWidth(), "maximum width of the reports, eliding long account names (0: no limit)")
+	f.BoolVar(&completeAccounts, "complete-accounts", false, "print the names of all the accounts, for shell completion")
+	f.BoolVar(&completeCommodities, "complete-commodities", false, "print the names of all the commodities, for shell completion")
+	hideFlags(f, "complete-accounts", "complete-commodities")
 	f.Parse(args)
-	if txtBeginDate != "" {
-		if len(txtBeginDate) == 4 {
-			txtBeginDate += "-01-01/00:00:00"
-		} else if len(txtBeginDate) == 7 {
-			txtBeginDate += "-01/00:00:00"
-		} else if len(txtBeginDate) == 10 {
-			txtBeginDate += "/00:00:00"
-		}
-		flags.beginDate, err = ledger.GetDate(txtBeginDate)
-		if err != nil {
+	if flags.priceDB != "" {
+		if err = ledger.ReadPriceDB(L, flags.priceDB); err != nil {
 			fmt.Fprintf(os.Stderr, "ledger: %s\n", err.Error())
 			os.Exit(1)
 		}
 	}
-	if txtEndDate != "" {
-		var endOfMonth bool
-		if len(txtEndDate) == 4 {
-			txtEndDate += "-12-31/23:59:59"
-		} else if len(txtEndDate) == 7 {
-			txtEndDate += "-01/23:59:59"
-			endOfMonth = true
-		} else if len(txtEndDate) == 10 {
-			txtEndDate = txtEndDate + "/23:59:59"
+	conf.apply(L, defaultCurrency)
+	if completeAccounts || completeCommodities {
+		complete(L, completeAccounts, completeCommodities)
+		return
+	}
+	flags.beginDate, flags.endDate, err = ledger.ParseDateRange(txtBeginDate, txtEndDate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ledger: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if txtPeriod != "" {
+		if flags.period, err = ledger.ParsePeriod(txtPeriod); err != nil {
+			fmt.Fprintf(os.Stderr, "ledger: -p: %s\n", err.Error())
+			os.Exit(1)
 		}
-		flags.endDate, err = ledger.GetDate(txtEndDate)
+		flags.periodic = true
+	}
+	if len(flags.pivot) > 0 {
+		L, err = L.Filter(func(t *accounting.Transaction) bool {
+			return transactionInPivot(t, flags.pivot)
+		})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ledger: %s\n", err.Error())
+			fmt.Fprintf(os.Stderr, "ledger: -pivot: %s\n", err.Error())
 			os.Exit(1)
 		}
-		if endOfMonth {
-			flags.endDate = flags.endDate.AddDate(0, 1, -1)
-		}
 	}
-	if flags.pivot != nil {
-		doPivot(L, flags.pivot)
+	if flags.tags != nil {
+		doTags(L, flags.tags)
 	}
-	if txtBeginDate != "" {
-		for i := len(L.Transactions) - 1; i >= 0; i-- {
-			if L.Transactions[i].Time.Before(flags.beginDate) {
-				L.Transactions = L.Transactions[i+1:]
-				break
-			}
-		}
-		//for i, p := range Ledger.Prices {
-		//	if p.Time.After(endDate) {
-		//		Ledger.Prices = Ledger.Prices[:i]
-		//		break
-		//	}
-		//}
-		for i := range L.Accounts {
-			for j := len(L.Accounts[i].Splits) - 1; j >= 0; j-- {
-				if L.Accounts[i].Splits[j].Time.Before(flags.beginDate) {
-					L.Accounts[i].StartBalance = L.Accounts[i].Splits[j].Balance
-					L.Accounts[i].Splits = L.Accounts[i].Splits[j+1:]
-					break
-				}
-			}
-		}
+	if flags.real {
+		doFilter(L, func(s *accounting.Split) bool {
+			return s.Virtual == accounting.NotVirtual
+		})
 	}
-	if txtEndDate != "" {
-		for i, t := range L.Transactions {
-			if t.Time.After(flags.endDate) {
-				L.Transactions = L.Transactions[:i]
-				break
-			}
-		}
-		for i := range L.Accounts {
-			for j, s := range L.Accounts[i].Splits {
-				if s.Time.After(flags.endDate) {
-					L.Accounts[i].Splits = L.Accounts[i].Splits[:j]
-					break
-				}
-			}
-		}
+	if flags.cleared && flags.pending {
+		doState(L, accounting.Cleared, accounting.Pending)
+	} else if flags.cleared {
+		doState(L, accounting.Cleared)
+	} else if flags.pending {
+		doState(L, accounting.Pending)
+	}
+	if len(f.Args()) > 0 && needsHistory[f.Args()[0]] {
+		flags.untrimmed = L.Clone()
+	}
+	if len(f.Args()) == 0 || !trimsItself[f.Args()[0]] {
+		L.TrimTo(flags.beginDate, flags.endDate)
+	}
+	if flags.endDate.IsZero() {
+		flags.endDate = time.Now()
+		flags.openEnd = true
 	}
 	/*
 		for i := len(Ledger.Accounts) - 1; i >= 0; i-- {
@@ -568,7 +1332,7 @@ func main2(L *accounting.Ledger, args []string) {
 		}
 	*/
 	if len(f.Args()) == 0 {
-		tableAccounts(L)
+		tableAccounts(L, flags.dateFormat, flags.full)
 		return
 	}
 	if len(f.Args()) > 0 && commands[f.Args()[0]] == nil {
@@ -579,36 +1343,110 @@ func main2(L *accounting.Ledger, args []string) {
 	}
 }
 
-func tableAccounts(ledger *accounting.Ledger) {
+// hideFlags makes the usage message of a FlagSet not show some of its flags.
+func hideFlags(f *flag.FlagSet, names ...string) {
+	hidden := make(map[string]bool)
+	for _, name := range names {
+		hidden[name] = true
+	}
+	f.Usage = func() {
+		visible := flag.NewFlagSet(f.Name(), flag.ContinueOnError)
+		visible.SetOutput(f.Output())
+		f.VisitAll(func(fl *flag.Flag) {
+			if !hidden[fl.Name] {
+				visible.Var(fl.Value, fl.Name, fl.Usage)
+			}
+		})
+		fmt.Fprintf(f.Output(), "Usage of %s:\n", f.Name())
+		visible.PrintDefaults()
+	}
+}
+
+// complete prints the full names of all the accounts and/or the names of
+// all the commodities, one per line, to be used by shell completion scripts.
+func complete(L *accounting.Ledger, accounts, commodities bool) {
+	if accounts {
+		for _, a := range L.Accounts {
+			if !a.IsTransferAccount() {
+				fmt.Println(a.FullName())
+			}
+		}
+	}
+	if commodities {
+		for _, c := range L.Currencies {
+			fmt.Println(c.Name)
+		}
+	}
+}
+
+func tableAccounts(ledger *accounting.Ledger, dateFormat string, full bool) {
+	accounts := shownAccounts(ledger)
+	if len(accounts) == 0 {
+		fmt.Println("No accounts in ledger")
+		return
+	}
 	t := tableview.NewTableView()
-	t.FillTable([]string{"account", "balance"}, [][]string{})
+	t.FillTable([]string{"account", "balance", "cleared"}, [][]string{})
 	t.SetExpansion(0, 1)
-	for i, ac := range ledger.Accounts {
+	for i, ac := range accounts {
 		// t.SetCell(i, 0, strconv.Itoa(ac.ID))
-		t.SetCell(i, 0, ac.FullName())
+		t.SetCell(i, 0, ac.DisplayName())
 		t.SetAlign(1, tableview.AlignRight)
-		t.SetCell(i, 1, ledger.GetBalance(ac, time.Time{}).String())
+		t.SetCell(i, 1, balanceString(ledger.GetBalance(ac, time.Time{}), full))
+		t.SetAlign(2, tableview.AlignRight)
+		t.SetCell(i, 2, balanceString(ledger.GetClearedBalance(ac, time.Time{}), full))
 	}
 	t.SetSelectedFunc(func(row int) {
-		tableTransactions(ledger.Accounts[row-1])
+		if row < 1 || row > len(accounts) {
+			return
+		}
+		tableTransactions(accounts[row-1], dateFormat, full)
 	})
 	t.Run()
 }
 
-func tableTransactions(account *accounting.Account) {
+func tableTransactions(account *accounting.Account, dateFormat string, full bool) {
+	if dateFormat == "" {
+		dateFormat = "02-01-2006"
+	}
 	fmt.Printf("account %s: %d splits\n", account.FullName(), len(account.Splits))
+	// With only one currency, it is shown in the header
+	// and the columns have just numbers:
+	value, balance, cleared := "value", "balance", "cleared"
+	commodities := account.Commodities()
+	if len(commodities) == 1 && commodities[0] != nil {
+		value += " (" + commodities[0].Name + ")"
+		balance += " (" + commodities[0].Name + ")"
+		cleared += " (" + commodities[0].Name + ")"
+	}
 	t := tableview.NewTableView()
-	t.FillTable([]string{"date", "description", "value", "balance"}, [][]string{})
+	t.FillTable([]string{"date", "description", value, balance, cleared}, [][]string{})
 	t.SetExpansion(1, 1)
 	for i, sp := range account.Splits {
-		t.SetCell(i, 0, sp.Time.Format("02-01-2006"))
+		t.SetCell(i, 0, sp.Time.Format(dateFormat))
 		t.SetCell(i, 1, sp.Transaction.Description)
-		if v := sp.Value.String(); v != "0" {
-			t.SetCell(i, 2, sp.Value.String())
+		if len(commodities) == 1 {
+			t.SetCell(i, 2, sp.Value.GetString(full, false))
+			if len(sp.Balance) == 1 {
+				t.SetCell(i, 3, sp.Balance[0].GetString(full, false))
+			} else {
+				t.SetCell(i, 3, accounting.Value{Currency: commodities[0]}.GetString(full, false))
+			}
+			if len(sp.ClearedBalance) == 1 {
+				t.SetCell(i, 4, sp.ClearedBalance[0].GetString(full, false))
+			} else {
+				t.SetCell(i, 4, accounting.Value{Currency: commodities[0]}.GetString(full, false))
+			}
+		} else {
+			if v := valueString(sp.Value, full); v != "0" {
+				t.SetCell(i, 2, v)
+			}
+			t.SetCell(i, 3, balanceString(sp.Balance, full))
+			t.SetCell(i, 4, balanceString(sp.ClearedBalance, full))
 		}
 		t.SetAlign(2, tableview.AlignRight)
-		t.SetCell(i, 3, sp.Balance.String())
 		t.SetAlign(3, tableview.AlignRight)
+		t.SetAlign(4, tableview.AlignRight)
 	}
 	t.Run()
 }
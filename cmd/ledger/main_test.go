@@ -0,0 +1,593 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cespedes/accounting"
+	"github.com/cespedes/accounting/backend/ledger"
+)
+
+// usesArgs lists the commands which fail without their arguments.
+var usesArgs = map[string]bool{
+	"diff":          true,
+	"reconcile":     true,
+	"merge-account": true,
+}
+
+// journalRunner writes a journal in a temporary directory and returns
+// a function which runs a command on it with some global flags,
+// returning what it printed, and another one to clean up when done.
+func journalRunner(t *testing.T, journal string, fl flags) (run func(name string, args ...string) (string, error), done func()) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "test.journal")
+	if err := ioutil.WriteFile(filename, []byte(journal), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	// the output of the commands goes to a file, to be checked later:
+	out, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = out
+	run = func(name string, args ...string) (string, error) {
+		L, err := accounting.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer L.Close()
+		out.Truncate(0)
+		out.Seek(0, 0)
+		fl.filename, fl.untrimmed = filename, L
+		err = commands[name](L, fl, args)
+		b, _ := ioutil.ReadFile(out.Name())
+		return string(b), err
+	}
+	done = func() {
+		os.Stdout = stdout
+		out.Close()
+		os.RemoveAll(dir)
+	}
+	return run, done
+}
+
+func TestCommandAliases(t *testing.T) {
+	// every name of a command must be in the same lists as the others:
+	byFunc := make(map[uintptr][]string)
+	for name, fn := range commands {
+		p := reflect.ValueOf(fn).Pointer()
+		byFunc[p] = append(byFunc[p], name)
+	}
+	for _, names := range byFunc {
+		sort.Strings(names)
+		for _, list := range []struct {
+			name  string
+			names map[string]bool
+		}{
+			{"needsHistory", needsHistory},
+			{"trimsItself", trimsItself},
+			{"usesArgs", usesArgs},
+		} {
+			for _, name := range names[1:] {
+				if list.names[name] != list.names[names[0]] {
+					t.Errorf("%s: %q and %q differ", list.name, names[0], name)
+				}
+			}
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.journal")
+	journal := `commodity 1.00 EUR
+2021-01-01 Exchange
+    Assets:Bank  100 USD
+    Assets:Cash  -90.00 EUR
+`
+	if err := ioutil.WriteFile(filename, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	for _, test := range []struct {
+		flag   string
+		output string
+	}{
+		{"-complete-accounts", "Assets\nAssets:Bank\nAssets:Cash\n"},
+		{"-complete-commodities", "EUR\nUSD\n"},
+	} {
+		L, err := accounting.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Truncate(0)
+		out.Seek(0, 0)
+		// the command is not run: only the names are printed
+		main2(L, config{}, filename, []string{test.flag, "balance"})
+		L.Close()
+		if b, _ := ioutil.ReadFile(out.Name()); string(b) != test.output {
+			t.Errorf("ledger %s = %q (expected %q)", test.flag, b, test.output)
+		}
+	}
+}
+
+func TestEmptyJournal(t *testing.T) {
+	run, done := journalRunner(t, "; only comments\n", flags{})
+	defer done()
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := run(name); err != nil && !usesArgs[name] {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	for _, test := range []struct {
+		args   []string
+		output string
+	}{
+		{[]string{"accounts"}, ""},
+		{[]string{"balance"}, "0\n"},
+		{[]string{"balance", "-empty"}, "0\n"},
+		{[]string{"register"}, ""},
+		{[]string{"stats"}, "No transactions in ledger\n"},
+		{[]string{"print"}, "; only comments\n"},
+		{[]string{"delta", "Assets"}, "0\n"},
+	} {
+		output, err := run(test.args[0], test.args[1:]...)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+		}
+		if output != test.output {
+			t.Errorf("%v = %q (expected %q)", test.args, output, test.output)
+		}
+	}
+	if _, err := run("reconcile", "Assets", "0"); err == nil {
+		t.Errorf("reconcile of a missing account did not fail")
+	}
+	if _, err := run("price", "EUR"); err == nil {
+		t.Errorf("price without a default currency did not fail")
+	}
+}
+
+func TestBalanceCommodity(t *testing.T) {
+	run, done := journalRunner(t, `commodity 1.00 EUR
+commodity 1 AAPL
+2020-01-01 Buy
+    Assets:Broker:A    10 AAPL @ 100.00 EUR
+    Assets:Bank
+2020-01-02 Buy
+    Assets:Broker:B    5 AAPL @ 100.00 EUR
+    Assets:Broker:B    3 MSFT @ 100.00 EUR
+    Assets:Bank
+`, flags{})
+	defer done()
+	output, err := run("balance", "-commodity", "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `        Assets
+          Broker
+10 AAPL     A
+ 5 AAPL     B
+-------
+15 AAPL
+`
+	if output != expected {
+		t.Errorf("balance -commodity AAPL =\n%s(expected\n%s)", output, expected)
+	}
+	if _, err := run("balance", "-commodity", "XYZ"); err == nil {
+		t.Errorf("balance -commodity with an unknown commodity did not fail")
+	}
+}
+
+func TestBalanceAssert(t *testing.T) {
+	run, done := journalRunner(t, `2021-01-01 Groceries
+    Expenses:Food  10.00 EUR
+    Assets:Bank
+2021-01-02 Restaurant
+    Expenses:Food  25.50 EUR
+    Assets:Bank
+`, flags{})
+	defer done()
+	expected := `35.50 EUR Expenses:Food
+---------
+35.50 EUR
+`
+	output, err := run("balance", "-assert", "35.50 EUR", "food")
+	if err != nil {
+		t.Errorf("balance -assert 35.50 EUR: %v", err)
+	}
+	if output != expected {
+		t.Errorf("balance -assert 35.50 EUR =\n%s(expected\n%s)", output, expected)
+	}
+	// the report is shown even if the assertion fails:
+	output, err = run("balance", "-assert", "40 EUR", "food")
+	if err == nil || err.Error() != "wrong total: 35.50 EUR != 40.00 EUR (off by -4.50 EUR)" {
+		t.Errorf("balance -assert 40 EUR = %v (expected wrong total: 35.50 EUR != 40.00 EUR (off by -4.50 EUR))", err)
+	}
+	if output != expected {
+		t.Errorf("balance -assert 40 EUR =\n%s(expected\n%s)", output, expected)
+	}
+	if _, err := run("balance", "-assert", "many EUR"); err == nil {
+		t.Errorf("balance -assert with a wrong amount did not fail")
+	}
+}
+
+func TestBalanceWidth(t *testing.T) {
+	run, done := journalRunner(t, `2021-01-01 Groceries
+    Expenses:Supermarket and groceries  10.00 EUR
+    Assets:Bank account at the corner
+`, flags{width: 20})
+	defer done()
+	output, err := run("balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the names are elided in the middle, and the amounts are kept:
+	expected := `           Expenses
+ 10.00 EUR   Sup…ies
+           Assets
+-10.00 EUR   Ban…ner
+----------
+0
+`
+	if output != expected {
+		t.Errorf("balance with -width 20 =\n%s(expected\n%s)", output, expected)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	run, done := journalRunner(t, `2021-01-01 Groceries
+    Expenses:Food  10.00 EUR
+    Assets:Bank  -10.00 EUR
+`, flags{})
+	output, err := run("check")
+	done()
+	if err != nil || output != "" {
+		t.Errorf("check of a good journal = %q, %v (expected nothing)", output, err)
+	}
+
+	run, done = journalRunner(t, `2021-01-01 Groceries
+    Expenses:Food  10.00 EUR
+    Assets:Bank
+2021-01-02 Exchange
+    Assets:Bank  100 USD
+    Assets:Bank  -90.00 EUR
+2021-01-03 Alone
+    Assets:Bank  0 EUR
+`, flags{})
+	defer done()
+	output, err = run("check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the problems are shown with the name of the file, in a temporary directory:
+	output = regexp.MustCompile(`(?m)^.*test\.journal:`).ReplaceAllString(output, "test.journal:")
+	expected := `test.journal:3: amount of Assets:Bank calculated as -10.00 EUR
+test.journal:4: automatic price: P 2021-01-02/12:00 USD 0.90 EUR
+test.journal:4: automatic price: P 2021-01-02/12:00 EUR 1.11111111 USD
+test.journal:7: transaction with only one split
+`
+	if output != expected {
+		t.Errorf("check of a bad journal =\n%s(expected\n%s)", output, expected)
+	}
+}
+
+func TestSort(t *testing.T) {
+	run, done := journalRunner(t, `2021-01-02 Second day, first
+    Expenses:Food  2 EUR
+    Assets:Bank
+2021-01-01 First day, first
+    Expenses:Food  1 EUR
+    Assets:Bank
+2021-01-02 Second day, second
+    Expenses:Food  3 EUR
+    Assets:Bank
+P 2021-01-02 USD 0.90 EUR
+P 2021-01-01 USD 0.80 EUR
+P 2021-01-02 GBP 1.10 EUR
+2021-01-01 First day, second
+    Expenses:Food  4 EUR
+    Assets:Bank
+`, flags{})
+	output, err := run("sort")
+	done()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// entries with the same time keep the order they had in the file:
+	expected := `account Expenses
+account Expenses:Food
+account Assets
+account Assets:Bank
+
+commodity 1000000.00 EUR
+commodity 1000000 USD
+commodity 1000000 GBP
+
+2021-01-01/12:00 First day, first
+  Expenses:Food                                       1.00 EUR
+  Assets:Bank                                         -1.00 EUR
+2021-01-01/12:00 First day, second
+  Expenses:Food                                       4.00 EUR
+  Assets:Bank                                         -4.00 EUR
+P 2021-01-01/12:00 USD 0.80 EUR
+2021-01-02/12:00 Second day, first
+  Expenses:Food                                       2.00 EUR
+  Assets:Bank                                         -2.00 EUR
+2021-01-02/12:00 Second day, second
+  Expenses:Food                                       3.00 EUR
+  Assets:Bank                                         -3.00 EUR
+P 2021-01-02/12:00 USD 0.90 EUR
+P 2021-01-02/12:00 GBP 1.10 EUR
+`
+	if output != expected {
+		t.Fatalf("sort =\n%s(expected\n%s)", output, expected)
+	}
+	// and sorting it again does not change anything:
+	run, done = journalRunner(t, output, flags{})
+	defer done()
+	if again, err := run("sort"); err != nil || again != output {
+		t.Errorf("sort of a sorted journal = %v,\n%s(expected\n%s)", err, again, output)
+	}
+}
+
+func TestPeriodicBalance(t *testing.T) {
+	run, done := journalRunner(t, `commodity 1,000.00 EUR
+2020-01-10 Food
+    Expenses:Food    30.00 EUR
+    Assets:Bank
+2020-03-10 Fuel
+    Expenses:Fuel    40.00 EUR
+    Expenses:Fuel    10 USD
+    Assets:Bank    -40.00 EUR
+    Assets:Cash   -10 USD
+`, flags{periodic: true, period: ledger.Monthly, openEnd: true})
+	defer done()
+	output, err := run("balance", "expenses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `                 2020-01  2020-02    2020-03      Total    Average
+-------------  ---------  -------  ---------  ---------  ---------
+Expenses:Food  30.00 EUR        0          0  30.00 EUR  10.00 EUR
+Expenses:Fuel          0        0  40.00 EUR  40.00 EUR  13.33 EUR
+                                      10 USD     10 USD      3 USD
+-------------  ---------  -------  ---------  ---------  ---------
+               30.00 EUR        0  40.00 EUR  70.00 EUR  23.33 EUR
+                                      10 USD     10 USD      3 USD
+`
+	if output != expected {
+		t.Errorf("balance -p monthly =\n%s(expected\n%s)", output, expected)
+	}
+}
+
+func TestBalanceDates(t *testing.T) {
+	journal := `2020-01-01 Opening
+    Assets:Savings  1000 EUR
+    Assets:Bank  500 EUR
+    Equity:Opening
+2021-02-01 Food
+    Expenses:Food  10 EUR
+    Assets:Bank
+2022-03-01 Fuel
+    Expenses:Fuel  20 EUR
+    Assets:Bank
+`
+	begin := time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2021, 12, 31, 0, 0, 0, 0, time.Local)
+	run, done := journalRunner(t, journal, flags{beginDate: begin, endDate: end})
+	defer done()
+	output, err := run("balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the accounts with only an opening balance are shown too,
+	// and the balances are the ones at the end date:
+	expected := `          Assets
+ 1000 EUR   Savings
+  490 EUR   Bank
+          Equity
+-1500 EUR   Opening
+          Expenses
+   10 EUR   Food
+---------
+0
+`
+	if output != expected {
+		t.Errorf("balance -b 2021-01-01 -e 2021-12-31 =\n%s(expected\n%s)", output, expected)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	run, done := journalRunner(t, `2021-01-01 Groceries
+    Expenses:Food  10.00 EUR
+    Assets:Bank
+2021-01-02 Restaurant
+    Expenses:Food  25.50 EUR
+    Assets:Bank
+2021-01-03 Market
+    Expenses:Food  3.00 EUR
+    Assets:Cash
+2021-01-04 Trip
+    Expenses:Travel  20 USD
+    Assets:Cash
+`, flags{})
+	defer done()
+	total := `2021-01-01 Groceries   Expenses:Food  10.00 EUR  10.00 EUR
+2021-01-02 Restaurant  Expenses:Food  25.50 EUR  35.50 EUR
+2021-01-03 Market      Expenses:Food   3.00 EUR  38.50 EUR
+`
+	for _, test := range []struct {
+		args   []string
+		output string
+	}{
+		{[]string{"food"}, total},
+		{[]string{"-total", "food"}, total},
+		{[]string{"-average", "food"}, `2021-01-01 Groceries   Expenses:Food  10.00 EUR  10.00 EUR
+2021-01-02 Restaurant  Expenses:Food  25.50 EUR  17.75 EUR
+2021-01-03 Market      Expenses:Food   3.00 EUR  12.83 EUR
+`},
+	} {
+		output, err := run("register", test.args...)
+		if err != nil {
+			t.Errorf("register %v: %v", test.args, err)
+		}
+		if output != test.output {
+			t.Errorf("register %v =\n%s(expected\n%s)", test.args, output, test.output)
+		}
+	}
+	if _, err := run("register", "-average", "expenses"); err == nil || err.Error() != "cannot average amounts in EUR and USD" {
+		t.Errorf("register -average with EUR and USD = %v (expected cannot average amounts in EUR and USD)", err)
+	}
+	if _, err := run("register", "-average", "-total"); err == nil {
+		t.Errorf("register -average -total did not fail")
+	}
+}
+
+func TestPeriodicBalanceWeekStart(t *testing.T) {
+	journal := `2021-01-02 Saturday
+    Expenses:Food  1 EUR
+    Assets:Bank
+2021-01-03 Sunday
+    Expenses:Food  2 EUR
+    Assets:Bank
+2021-01-04 Monday
+    Expenses:Food  4 EUR
+    Assets:Bank
+`
+	end := time.Date(2021, 1, 5, 0, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		weekStart time.Weekday
+		expected  string
+	}{
+		{time.Monday, `               2020-12-28  2021-01-04  Total  Average
+-------------  ----------  ----------  -----  -------
+Expenses:Food       3 EUR       4 EUR  7 EUR    4 EUR
+-------------  ----------  ----------  -----  -------
+                    3 EUR       4 EUR  7 EUR    4 EUR
+`},
+		{time.Sunday, `               2020-12-27  2021-01-03  Total  Average
+-------------  ----------  ----------  -----  -------
+Expenses:Food       1 EUR       6 EUR  7 EUR    4 EUR
+-------------  ----------  ----------  -----  -------
+                    1 EUR       6 EUR  7 EUR    4 EUR
+`},
+	} {
+		run, done := journalRunner(t, journal, flags{periodic: true, period: ledger.Weekly, weekStart: test.weekStart, endDate: end})
+		output, err := run("balance", "food")
+		done()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != test.expected {
+			t.Errorf("balance -p weekly, starting on %s =\n%s(expected\n%s)", test.weekStart, output, test.expected)
+		}
+	}
+	if d, err := parseWeekday("SUN"); err != nil || d != time.Sunday {
+		t.Errorf("parseWeekday(SUN) = %v, %v (expected Sunday)", d, err)
+	}
+	if _, err := parseWeekday("someday"); err == nil {
+		t.Errorf("parseWeekday(someday) did not fail")
+	}
+}
+
+func TestBalanceEmpty(t *testing.T) {
+	run, done := journalRunner(t, `2021-01-01 x
+    Expenses:Food  10 EUR
+    Assets:Bank
+2021-01-02 y
+    Assets:Bank  10 EUR
+    Expenses:Food
+2021-01-03 z
+    Expenses:Fuel  5 EUR
+    Assets:Cash
+`, flags{})
+	defer done()
+	output, err := run("balance", "-empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `       Expenses
+     0   Food
+ 5 EUR   Fuel
+       Assets
+     0   Bank
+-5 EUR   Cash
+------
+0
+`
+	if output != expected {
+		t.Errorf("balance -empty =\n%s(expected\n%s)", output, expected)
+	}
+	output, _ = run("balance")
+	if strings.Contains(output, "Food") || strings.Contains(output, "Bank") {
+		t.Errorf("balance without -empty shows accounts with a zero balance:\n%s", output)
+	}
+}
+
+func TestIncomeStatementArgs(t *testing.T) {
+	run, done := journalRunner(t, `2021-01-01 Gift
+    Income  -100.00 EUR
+    Assets:Bank
+2021-01-31 Salary
+    Income:Salary  -1000.00 EUR
+    Assets:Bank
+`, flags{incomePrefix: "Income:", expensePrefix: "Expense:"})
+	defer done()
+	// the account named like the prefix is an income account too:
+	expected := `Income Statement
+
+===============++=============
+ Revenues      ||
+---------------++-------------
+ Income        ||  100.00 EUR
+ Income:Salary || 1000.00 EUR
+---------------++-------------
+               || 1100.00 EUR
+===============++=============
+ Expenses      ||
+---------------++-------------
+---------------++-------------
+               ||           0
+===============++=============
+ Net:          || 1100.00 EUR
+`
+	output, err := run("incomestatement", "income")
+	if err != nil {
+		t.Errorf("incomestatement income: %v", err)
+	}
+	if output != expected {
+		t.Errorf("incomestatement income =\n%s(expected\n%s)", output, expected)
+	}
+}
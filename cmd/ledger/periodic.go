@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cespedes/accounting"
+	"github.com/cespedes/accounting/backend/ledger"
+)
+
+// periodicRow is a line of the periodic balance report: what an account
+// (or all of them, in the last line) changed in every interval,
+// followed by the total and the average of those changes.
+type periodicRow struct {
+	name     string
+	balances []accounting.Balance
+}
+
+// runPeriodicBalance shows, for the accounts matching any of the
+// arguments (or every account, if there are none), how much their
+// balances changed in every interval of the period given with -p,
+// with two more columns: "Total", the sum of all the intervals,
+// and "Average", their mean.
+func runPeriodicBalance(L *accounting.Ledger, flags flags, args []string) error {
+	begin, end := flags.beginDate, flags.endDate
+	var intervals []ledger.Interval
+	if n := len(L.Transactions); n > 0 {
+		if begin.IsZero() {
+			begin = L.Transactions[0].Time
+		}
+		if flags.openEnd {
+			end = L.Transactions[n-1].Time
+		}
+		if !end.Before(begin) {
+			intervals = ledger.Intervals(flags.period, begin, end, flags.weekStart)
+		}
+	}
+
+	var rows []periodicRow
+	total := periodicRow{balances: make([]accounting.Balance, len(intervals))}
+	for _, a := range shownAccounts(L) {
+		if !accountMatches(a, args) {
+			continue
+		}
+		row := periodicRow{name: a.FullName(), balances: make([]accounting.Balance, len(intervals))}
+		used := false
+		for _, s := range a.Splits {
+			if s.Time.Before(begin) || s.Time.After(end) {
+				continue
+			}
+			i := sort.Search(len(intervals), func(i int) bool {
+				return !intervals[i].End.Before(*s.Time)
+			})
+			if i == len(intervals) {
+				continue
+			}
+			row.balances[i].Add(s.Value)
+			total.balances[i].Add(s.Value)
+			used = true
+		}
+		if used {
+			rows = append(rows, row)
+		}
+	}
+
+	headings := make([]string, len(intervals))
+	for i, iv := range intervals {
+		headings[i] = intervalHeading(flags, iv)
+	}
+	if flags.json {
+		type jsonRow struct {
+			Account  string               `json:"account,omitempty"`
+			Balances []accounting.Balance `json:"balances"`
+			Total    accounting.Balance   `json:"total"`
+			Average  accounting.Balance   `json:"average"`
+		}
+		toJSON := func(r periodicRow) jsonRow {
+			return jsonRow{r.name, r.balances, accounting.SumBalances(r.balances...), accounting.AverageBalance(r.balances...)}
+		}
+		var out struct {
+			Intervals []string  `json:"intervals"`
+			Accounts  []jsonRow `json:"accounts"`
+			Total     jsonRow   `json:"total"`
+		}
+		out.Intervals = headings
+		out.Accounts = []jsonRow{}
+		if !flags.total {
+			for _, r := range rows {
+				out.Accounts = append(out.Accounts, toJSON(r))
+			}
+		}
+		out.Total = toJSON(total)
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(out)
+	}
+
+	headings = append(headings, "Total", "Average")
+	if flags.total {
+		rows = nil
+	}
+	rows = append(rows, total)
+	// every row gets its total and average as two more columns,
+	// with the average rounded to the precision of its currencies:
+	for i, r := range rows {
+		average := accounting.AverageBalance(r.balances...)
+		if !flags.full {
+			var rounded accounting.Balance
+			for _, v := range average {
+				rounded.Add(v.Round())
+			}
+			average = rounded
+		}
+		rows[i].balances = append(r.balances, accounting.SumBalances(r.balances...), average)
+	}
+	nameWidth := 0
+	widths := make([]int, len(headings))
+	for i, h := range headings {
+		widths[i] = textWidth(h)
+	}
+	for _, r := range rows {
+		if w := textWidth(r.name); w > nameWidth {
+			nameWidth = w
+		}
+		for i, b := range r.balances {
+			for _, v := range b {
+				if w := textWidth(valueString(v, flags.full)); w > widths[i] {
+					widths[i] = w
+				}
+			}
+		}
+	}
+	// long names are elided to fit in flags.width, keeping the amounts:
+	if flags.width > 0 {
+		room := flags.width
+		for _, w := range widths {
+			room -= w + 2
+		}
+		if nameWidth > room {
+			nameWidth = room
+		}
+		if nameWidth < 1 {
+			nameWidth = 1
+		}
+	}
+	separator := strings.Repeat("-", nameWidth)
+	for _, w := range widths {
+		separator += "  " + strings.Repeat("-", w)
+	}
+	// printRow shows a row, with every currency of a balance in its own line:
+	printRow := func(r periodicRow) {
+		lines := 1
+		for _, b := range r.balances {
+			if len(b) > lines {
+				lines = len(b)
+			}
+		}
+		name := elide(r.name, nameWidth)
+		for l := 0; l < lines; l++ {
+			line := padRight(name, nameWidth)
+			for i, b := range r.balances {
+				var cell string
+				if l < len(b) {
+					cell = valueString(b.Sorted()[l], flags.full)
+				} else if l == 0 {
+					cell = "0"
+				}
+				line += "  " + padLeft(cell, widths[i])
+			}
+			fmt.Println(strings.TrimRight(line, " "))
+			name = ""
+		}
+	}
+
+	line := strings.Repeat(" ", nameWidth)
+	for i, h := range headings {
+		line += "  " + padLeft(h, widths[i])
+	}
+	fmt.Println(line)
+	fmt.Println(separator)
+	for _, r := range rows[:len(rows)-1] {
+		printRow(r)
+	}
+	if len(rows) > 1 {
+		fmt.Println(separator)
+	}
+	printRow(rows[len(rows)-1])
+	return nil
+}
+
+// intervalHeading returns the title of the column of an interval:
+// its year, quarter or month, or the day it begins.
+func intervalHeading(flags flags, iv ledger.Interval) string {
+	switch flags.period {
+	case ledger.Yearly:
+		return iv.Begin.Format("2006")
+	case ledger.Quarterly:
+		return fmt.Sprintf("%dQ%d", iv.Begin.Year(), (int(iv.Begin.Month())+2)/3)
+	case ledger.Monthly:
+		return iv.Begin.Format("2006-01")
+	}
+	if flags.dateFormat != "" {
+		return iv.Begin.Format(flags.dateFormat)
+	}
+	return iv.Begin.Format("2006-01-02")
+}
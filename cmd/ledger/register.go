@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/cespedes/accounting"
+)
+
+// registerNameWidth is the maximum width of the description and account
+// columns in the register.
+const registerNameWidth = 30
+
+// runRegister shows the splits in the accounts matching any of the arguments
+// (or in every account, if there are none), with a running total or,
+// with -average, the running mean of their amounts.
+func runRegister(L *accounting.Ledger, flags flags, args []string) error {
+	var averageFlag, totalFlag bool
+	f := flag.NewFlagSet("register", flag.ExitOnError)
+	f.BoolVar(&averageFlag, "average", false, "show the running average of the amounts")
+	f.BoolVar(&totalFlag, "total", false, "show the running total of the amounts (default)")
+	f.Parse(args)
+	args = f.Args()
+	if averageFlag && totalFlag {
+		return errors.New("-average and -total cannot be used together")
+	}
+
+	var splits []*accounting.Split
+	for _, t := range L.Transactions {
+		for _, s := range t.Splits {
+			if !s.Account.IsTransferAccount() && accountMatches(s.Account, args) {
+				splits = append(splits, s)
+			}
+		}
+	}
+	if averageFlag {
+		for _, s := range splits {
+			if c := splits[0].Value.Currency; s.Value.Currency != c {
+				return fmt.Errorf("cannot average amounts in %s and %s", c.Name, s.Value.Currency.Name)
+			}
+		}
+	}
+
+	format := flags.dateFormat
+	if format == "" {
+		format = "2006-01-02"
+	}
+	var descWidth, accountWidth, rightWidth int
+	column := amountColumn{full: flags.full}
+	var total accounting.Balance
+	var sum int64
+	right := make([]string, len(splits))
+	for i, s := range splits {
+		value := s.Value
+		if flags.negate {
+			value.Amount = -value.Amount
+		}
+		if averageFlag {
+			sum += value.Amount
+			right[i] = valueString(accounting.Value{Amount: sum / int64(i+1), Currency: value.Currency}, flags.full)
+		} else {
+			total.Add(value)
+			right[i] = balanceString(total, flags.full)
+		}
+		column.fit(value)
+		if w := textWidth(right[i]); w > rightWidth {
+			rightWidth = w
+		}
+		if w := textWidth(s.Transaction.Description); w > descWidth {
+			descWidth = w
+		}
+		if w := textWidth(s.Account.FullName()); w > accountWidth {
+			accountWidth = w
+		}
+	}
+	if descWidth > registerNameWidth {
+		descWidth = registerNameWidth
+	}
+	if accountWidth > registerNameWidth {
+		accountWidth = registerNameWidth
+	}
+	for i, s := range splits {
+		value := s.Value
+		if flags.negate {
+			value.Amount = -value.Amount
+		}
+		fmt.Printf("%s %s  %s  %s  %s\n", s.Time.Format(format),
+			padRight(elide(s.Transaction.Description, descWidth), descWidth),
+			padRight(elide(s.Account.FullName(), accountWidth), accountWidth),
+			column.format(value), padLeft(right[i], rightWidth))
+	}
+	return nil
+}
+
+// accountMatches tells whether the full name of an account contains
+// any of the patterns (ignoring case), or there are no patterns.
+func accountMatches(a *accounting.Account, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	name := strings.ToLower(a.FullName())
+	for _, p := range patterns {
+		if strings.Contains(name, strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+// terminalWidth returns the number of columns of the terminal
+// where the output is shown, or 0 if it is unknown.
+func terminalWidth() int {
+	return 0
+}
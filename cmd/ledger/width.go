@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/cespedes/accounting"
+	"github.com/mattn/go-runewidth"
+)
+
+// textWidth returns the number of columns used to display a string,
+// counting wide characters (such as CJK symbols) as two columns.
+func textWidth(s string) int {
+	return runewidth.StringWidth(s)
+}
+
+// padLeft right-aligns a string in width columns.
+func padLeft(s string, width int) string {
+	if n := width - textWidth(s); n > 0 {
+		return strings.Repeat(" ", n) + s
+	}
+	return s
+}
+
+// valueString returns a value as shown in reports: with the precision
+// of its currency or, with full (the -full flag), with all its digits.
+func valueString(v accounting.Value, full bool) string {
+	if full {
+		return v.FullString()
+	}
+	return v.String()
+}
+
+// balanceString is like valueString, for a balance.
+func balanceString(b accounting.Balance, full bool) string {
+	if full {
+		return b.FullString()
+	}
+	return b.String()
+}
+
+// amountColumn aligns amounts on their decimal separators.
+type amountColumn struct {
+	integer  int  // width of the widest integer part
+	fraction int  // width of the widest fractional part (with the decimal separator and trailing currency)
+	full     bool // show all the digits of the amounts, as with valueString
+}
+
+// parts returns the integer and fractional parts of a value.
+func (c amountColumn) parts(v accounting.Value) (string, string) {
+	if c.full {
+		return v.FullStringParts()
+	}
+	return v.StringParts()
+}
+
+// fit makes the column wide enough for a value.
+func (c *amountColumn) fit(v accounting.Value) {
+	i, f := c.parts(v)
+	if w := textWidth(i); w > c.integer {
+		c.integer = w
+	}
+	if w := textWidth(f); w > c.fraction {
+		c.fraction = w
+	}
+}
+
+// width returns the number of columns used by the amounts.
+func (c amountColumn) width() int {
+	return c.integer + c.fraction
+}
+
+// format returns a value padded to the width of the column,
+// with its decimal separator aligned with the others.
+func (c amountColumn) format(v accounting.Value) string {
+	i, f := c.parts(v)
+	return padLeft(i, c.integer) + padRight(f, c.fraction)
+}
+
+// padRight left-aligns a string in width columns.
+func padRight(s string, width int) string {
+	if n := width - textWidth(s); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+	return s
+}
+
+// elide shortens a string to fit in width columns, replacing its middle
+// with "…".  Leading spaces (used to indent account names) are kept.
+// A width of 0 or less means no limit.
+func elide(s string, width int) string {
+	if width <= 0 || textWidth(s) <= width {
+		return s
+	}
+	name := strings.TrimLeft(s, " ")
+	indent := s[:len(s)-len(name)]
+	width -= len(indent)
+	if width < 1 {
+		return indent + "…"
+	}
+	tail := (width - 1) / 2
+	head := runewidth.Truncate(name, width-1-tail, "")
+	runes := []rune(name)
+	i := len(runes)
+	for w := 0; i > 0 && w+runewidth.RuneWidth(runes[i-1]) <= tail; i-- {
+		w += runewidth.RuneWidth(runes[i-1])
+	}
+	return indent + head + "…" + string(runes[i:])
+}
@@ -91,12 +91,11 @@ func main2(L *accounting.Ledger, args []string) {
 	var flags flags
 	var err error
 	var txtBeginDate, txtEndDate, txtPeriod, txtMeasurePeriod string
-	flags.endDate = time.Now()
 	f := flag.NewFlagSet("muscular", flag.ExitOnError)
 
 	f.StringVar(&txtBeginDate, "b", "", "begin date")
 	f.StringVar(&txtEndDate, "e", "", "end date")
-	f.StringVar(&txtPeriod, "period", "1m0d", "periodicity")
+	f.StringVar(&txtPeriod, "period", "1m0d", "periodicity (\"<n>m<n>d\" or \"daily\", \"weekly\", \"monthly\"...)")
 	f.StringVar(&txtMeasurePeriod, "measureperiod", "3m0d", "periodicity")
 	f.BoolVar(&flags.batch, "batch", false, "show computer-ready results")
 	f.BoolVar(&flags.debug, "debug", false, "show debugging information")
@@ -106,6 +105,10 @@ func main2(L *accounting.Ledger, args []string) {
 	f.IntVar(&flags.numMeasures, "measures", 1, "number of measures")
 	f.Parse(args)
 	// flags.period*:
+	if p, err := ledger.ParsePeriod(txtPeriod); err == nil {
+		months, days := p.Length()
+		txtPeriod = fmt.Sprintf("%dm%dd", months, days)
+	}
 	_, err = fmt.Sscanf(txtPeriod+"_", "%dm%dd_", &flags.periodMonths, &flags.periodDays)
 	if err != nil {
 		flags.periodDays = 0
@@ -120,6 +123,10 @@ func main2(L *accounting.Ledger, args []string) {
 		os.Exit(1)
 	}
 	// flags.measure*:
+	if p, err := ledger.ParsePeriod(txtMeasurePeriod); err == nil {
+		months, days := p.Length()
+		txtMeasurePeriod = fmt.Sprintf("%dm%dd", months, days)
+	}
 	_, err = fmt.Sscanf(txtMeasurePeriod+"_", "%dm%dd_", &flags.measureMonths, &flags.measureDays)
 	if err != nil {
 		flags.measureDays = 0
@@ -133,81 +140,18 @@ func main2(L *accounting.Ledger, args []string) {
 		fmt.Fprintf(os.Stderr, "muscular: wrong format for measureperiod %s\n", txtMeasurePeriod)
 		os.Exit(1)
 	}
-	if txtBeginDate != "" {
-		if len(txtBeginDate) == 4 {
-			txtBeginDate += "-01-01/00:00:00"
-		} else if len(txtBeginDate) == 7 {
-			txtBeginDate += "-01/00:00:00"
-		} else if len(txtBeginDate) == 10 {
-			txtBeginDate += "/00:00:00"
-		}
-		flags.beginDate, err = ledger.GetDate(txtBeginDate)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "muscular: %s\n", err.Error())
-			os.Exit(1)
-		}
+	flags.beginDate, flags.endDate, err = ledger.ParseDateRange(txtBeginDate, txtEndDate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "muscular: %s\n", err.Error())
+		os.Exit(1)
 	}
-	if txtEndDate != "" {
-		var endOfMonth bool
-		if len(txtEndDate) == 4 {
-			txtEndDate += "-12-31/23:59:59"
-		} else if len(txtEndDate) == 7 {
-			txtEndDate += "-01/23:59:59"
-			endOfMonth = true
-		} else if len(txtEndDate) == 10 {
-			txtEndDate = txtEndDate + "/23:59:59"
-		}
-		flags.endDate, err = ledger.GetDate(txtEndDate)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "muscular: %s\n", err.Error())
-			os.Exit(1)
-		}
-		if endOfMonth {
-			flags.endDate = flags.endDate.AddDate(0, 1, -1)
-		}
+	L.TrimTo(flags.beginDate, flags.endDate)
+	if flags.endDate.IsZero() {
+		flags.endDate = time.Now()
 	}
 	if flags.debug {
 		fmt.Printf("flags: %+v\n", flags)
 	}
-	if txtBeginDate != "" {
-		for i := len(L.Transactions) - 1; i >= 0; i-- {
-			if L.Transactions[i].Time.Before(flags.beginDate) {
-				L.Transactions = L.Transactions[i+1:]
-				break
-			}
-		}
-		//for i, p := range Ledger.Prices {
-		//	if p.Time.After(endDate) {
-		//		Ledger.Prices = Ledger.Prices[:i]
-		//		break
-		//	}
-		//}
-		for i := range L.Accounts {
-			for j := len(L.Accounts[i].Splits) - 1; j >= 0; j-- {
-				if L.Accounts[i].Splits[j].Time.Before(flags.beginDate) {
-					L.Accounts[i].StartBalance = L.Accounts[i].Splits[j].Balance
-					L.Accounts[i].Splits = L.Accounts[i].Splits[j+1:]
-					break
-				}
-			}
-		}
-	}
-	if txtEndDate != "" {
-		for i, t := range L.Transactions {
-			if t.Time.After(flags.endDate) {
-				L.Transactions = L.Transactions[:i]
-				break
-			}
-		}
-		for i := range L.Accounts {
-			for j, s := range L.Accounts[i].Splits {
-				if s.Time.After(flags.endDate) {
-					L.Accounts[i].Splits = L.Accounts[i].Splits[:j]
-					break
-				}
-			}
-		}
-	}
 
 	momentum := make([][]accounting.Value, len(f.Args()))
 	mom2 := make([]float64, len(f.Args()))
@@ -221,7 +165,7 @@ func main2(L *accounting.Ledger, args []string) {
 		for j := 0; j < flags.numMeasures; j++ {
 			t = t.AddDate(0, -flags.measureMonths, -flags.measureDays)
 			momentum[i][j+1], _ = L.Convert(v, t, L.DefaultCurrency)
-			mom2[i] += float64(momentum[i][0].Amount) / float64(momentum[i][j+1].Amount)
+			mom2[i] += momentum[i][0].Float64() / momentum[i][j+1].Float64()
 		}
 		mom2[i] /= float64(flags.numMeasures)
 		mom2[i]--
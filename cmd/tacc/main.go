@@ -1,48 +1,193 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/cespedes/accounting"
+	"github.com/cespedes/accounting/backend/ledger"
 	"github.com/cespedes/tableview"
 
-	_ "github.com/cespedes/accounting/backend/ledger"
 	_ "github.com/cespedes/accounting/backend/postgres"
 	_ "github.com/cespedes/accounting/backend/txtdb"
 )
 
+// accountTree holds the accounts shown in the accounts table:
+// the top-level ones, and the children of the expanded ones.
+type accountTree struct {
+	l          *accounting.Ledger // the whole ledger
+	view       *accounting.Ledger // l trimmed to the begin and end dates
+	begin, end time.Time          // zero if not limited
+	expanded   map[string]bool    // by full name, to survive a refresh
+	visible    []*accounting.Account
+}
+
+// trim updates the view of the ledger after changing it or the dates.
+func (tree *accountTree) trim() {
+	tree.view = tree.l
+	if !tree.begin.IsZero() || !tree.end.IsZero() {
+		tree.view = tree.l.Clone()
+		tree.view.TrimTo(tree.begin, tree.end)
+	}
+}
+
+// columns returns the headings of the table, with the dates, if any.
+func (tree *accountTree) columns() []string {
+	account := "account"
+	switch {
+	case !tree.begin.IsZero() && !tree.end.IsZero():
+		account += " (" + tree.begin.Format("2006-01-02") + " to " + tree.end.Format("2006-01-02") + ")"
+	case !tree.begin.IsZero():
+		account += " (from " + tree.begin.Format("2006-01-02") + ")"
+	case !tree.end.IsZero():
+		account += " (until " + tree.end.Format("2006-01-02") + ")"
+	}
+	return []string{account, "balance", "cleared"}
+}
+
+// rows returns the contents of the table, updating the visible accounts.
+// Collapsed accounts show the balances of all their descendants.
+func (tree *accountTree) rows() [][]string {
+	var data [][]string
+	tree.visible = nil
+	var add func(accounts []*accounting.Account)
+	add = func(accounts []*accounting.Account) {
+		for _, a := range accounts {
+			expanded := tree.expanded[a.FullName()]
+			marker := "  "
+			balance := tree.view.GetBalance(a, time.Time{})
+			cleared := tree.view.GetClearedBalance(a, time.Time{})
+			if len(a.Children) > 0 {
+				marker = "- "
+				if !expanded {
+					marker = "+ "
+					balance = a.SubtreeBalance()
+					cleared = subtreeClearedBalance(tree.view, a)
+				}
+			}
+			tree.visible = append(tree.visible, a)
+			data = append(data, []string{strings.Repeat("  ", a.Level) + marker + a.Name, balance.String(), cleared.String()})
+			if expanded {
+				add(a.Children)
+			}
+		}
+	}
+	var top []*accounting.Account
+	for _, a := range tree.view.Accounts {
+		if a.Parent == nil && !a.IsTransferAccount() {
+			top = append(top, a)
+		}
+	}
+	add(top)
+	return data
+}
+
+// subtreeClearedBalance returns the cleared balance of an account
+// plus the cleared balances of all its descendants.
+func subtreeClearedBalance(l *accounting.Ledger, a *accounting.Account) accounting.Balance {
+	b := l.GetClearedBalance(a, time.Time{}).Dup()
+	for _, c := range a.Children {
+		b.AddBalance(subtreeClearedBalance(l, c))
+	}
+	return b
+}
+
+// prompt asks for a line of text in the terminal.
+func prompt(question string) string {
+	fmt.Print(question)
+	line, _ := stdin.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
 func tableAccounts(l *accounting.Ledger) {
+	tree := &accountTree{l: l, expanded: make(map[string]bool)}
+	tree.trim()
+	if len(tree.rows()) == 0 {
+		fmt.Println("No accounts in ledger")
+		return
+	}
 	t := tableview.NewTableView()
-	t.FillTable([]string{"account", "balance"}, [][]string{})
+	t.FillTable(tree.columns(), tree.rows())
 	t.SetExpansion(0, 1)
-	for i, ac := range l.Accounts {
-		// t.SetCell(i, 0, strconv.Itoa(ac.ID))
-		t.SetCell(i, 0, ac.FullName())
-		t.SetAlign(1, tableview.AlignRight)
-		t.SetCell(i, 1, l.GetBalance(ac, time.Time{}).String())
-	}
+	t.SetAlign(1, tableview.AlignRight)
+	t.SetAlign(2, tableview.AlignRight)
 	t.SetSelectedFunc(func(row int) {
-		tableTransactions(l, l.Accounts[row-1])
+		if row < 1 || row > len(tree.visible) {
+			return
+		}
+		tableTransactions(tree.view, tree.visible[row-1])
+	})
+	t.NewCommand('x', "expand/collapse", func(row int) {
+		if row >= len(tree.visible) {
+			return
+		}
+		if a := tree.visible[row]; len(a.Children) > 0 {
+			tree.expanded[a.FullName()] = !tree.expanded[a.FullName()]
+			t.FillTable(tree.columns(), tree.rows())
+		}
+	})
+	t.NewCommand('d', "dates", func(row int) {
+		begin, end, err := ledger.ParseDateRange(prompt("Begin date (empty for none): "), prompt("End date (empty for none): "))
+		if err != nil {
+			prompt(err.Error() + " (press Enter)")
+			return
+		}
+		tree.begin, tree.end = begin, end
+		tree.trim()
+		t.FillTable(tree.columns(), tree.rows())
+	})
+	t.NewCommand('r', "refresh", func(row int) {
+		l.Refresh()
+		tree.trim()
+		t.FillTable(tree.columns(), tree.rows())
 	})
 	t.Run()
 }
 
 func tableTransactions(l *accounting.Ledger, account *accounting.Account) {
 	fmt.Printf("account %s: %d splits\n", account.FullName(), len(account.Splits))
+	// With only one currency, it is shown in the header
+	// and the columns have just numbers:
+	value, balance, cleared := "value", "balance", "cleared"
+	commodities := account.Commodities()
+	if len(commodities) == 1 && commodities[0] != nil {
+		value += " (" + commodities[0].Name + ")"
+		balance += " (" + commodities[0].Name + ")"
+		cleared += " (" + commodities[0].Name + ")"
+	}
 	t := tableview.NewTableView()
-	t.FillTable([]string{"date", "description", "value", "balance"}, [][]string{})
+	t.FillTable([]string{"date", "description", value, balance, cleared}, [][]string{})
 	t.SetExpansion(1, 1)
 	for i, sp := range account.Splits {
 		t.SetCell(i, 0, sp.Time.Format("02-01-2006"))
 		t.SetCell(i, 1, sp.Transaction.Description)
-		if v := sp.Value.String(); v != "0" {
-			t.SetCell(i, 2, sp.Value.String())
+		if len(commodities) == 1 {
+			t.SetCell(i, 2, sp.Value.AmountString())
+			if len(sp.Balance) == 1 {
+				t.SetCell(i, 3, sp.Balance[0].AmountString())
+			} else {
+				t.SetCell(i, 3, accounting.Value{Currency: commodities[0]}.AmountString())
+			}
+			if len(sp.ClearedBalance) == 1 {
+				t.SetCell(i, 4, sp.ClearedBalance[0].AmountString())
+			} else {
+				t.SetCell(i, 4, accounting.Value{Currency: commodities[0]}.AmountString())
+			}
+		} else {
+			if v := sp.Value.String(); v != "0" {
+				t.SetCell(i, 2, sp.Value.String())
+			}
+			t.SetCell(i, 3, sp.Balance.String())
+			t.SetCell(i, 4, sp.ClearedBalance.String())
 		}
 		t.SetAlign(2, tableview.AlignRight)
-		t.SetCell(i, 3, sp.Balance.String())
 		t.SetAlign(3, tableview.AlignRight)
+		t.SetAlign(4, tableview.AlignRight)
 	}
 	t.Run()
 }
@@ -52,13 +197,13 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Usage: tacc <database>")
 		os.Exit(1)
 	}
-	ledger, err := accounting.Open(os.Args[1])
+	l, err := accounting.Open(os.Args[1])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 
-	tableAccounts(ledger)
+	tableAccounts(l)
 	/*
 		transactions := ledger.Transactions()
 
@@ -0,0 +1,139 @@
+package accounting
+
+// ChangeKind specifies how an object differs between two ledgers.
+type ChangeKind int
+
+// Kinds of changes returned by Diff.
+const (
+	Added ChangeKind = iota + 1
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Change is a difference in an account or transaction between two ledgers.
+type Change struct {
+	Kind ChangeKind
+	Old  interface{} // *Account or *Transaction in the first ledger (nil if added)
+	New  interface{} // *Account or *Transaction in the second ledger (nil if removed)
+}
+
+// Diff returns the accounts and transactions which have been added, removed
+// or modified from ledger a to ledger b.  Objects are matched by the string
+// form of their ID; those without an ID are ignored.
+func Diff(a, b *Ledger) []Change {
+	var changes []Change
+
+	accounts := make(map[string]*Account)
+	for _, ac := range b.Accounts {
+		if ac.ID != nil {
+			accounts[ac.ID.String()] = ac
+		}
+	}
+	for _, ac := range a.Accounts {
+		if ac.ID == nil {
+			continue
+		}
+		bc, ok := accounts[ac.ID.String()]
+		if !ok {
+			changes = append(changes, Change{Kind: Removed, Old: ac})
+			continue
+		}
+		delete(accounts, ac.ID.String())
+		if ac.FullName() != bc.FullName() || ac.Code != bc.Code {
+			changes = append(changes, Change{Kind: Modified, Old: ac, New: bc})
+		}
+	}
+	for _, bc := range b.Accounts {
+		if bc.ID != nil && accounts[bc.ID.String()] == bc {
+			changes = append(changes, Change{Kind: Added, New: bc})
+		}
+	}
+
+	transactions := make(map[string]*Transaction)
+	for _, t := range b.Transactions {
+		if t.ID != nil {
+			transactions[t.ID.String()] = t
+		}
+	}
+	for _, t := range a.Transactions {
+		if t.ID == nil {
+			continue
+		}
+		bt, ok := transactions[t.ID.String()]
+		if !ok {
+			changes = append(changes, Change{Kind: Removed, Old: t})
+			continue
+		}
+		delete(transactions, t.ID.String())
+		if !equalTransactions(t, bt) {
+			changes = append(changes, Change{Kind: Modified, Old: t, New: bt})
+		}
+	}
+	for _, bt := range b.Transactions {
+		if bt.ID != nil && transactions[bt.ID.String()] == bt {
+			changes = append(changes, Change{Kind: Added, New: bt})
+		}
+	}
+	return changes
+}
+
+// equalValues compares two values from different ledgers,
+// where currencies are matched by name.
+func equalValues(v1, v2 Value) bool {
+	if v1.Amount != v2.Amount {
+		return false
+	}
+	if v1.Currency == nil || v2.Currency == nil {
+		return v1.Currency == v2.Currency
+	}
+	return v1.Currency.Name == v2.Currency.Name
+}
+
+// equalTransactions compares two transactions from different ledgers,
+// ignoring the splits automatically added by Fill.
+func equalTransactions(t1, t2 *Transaction) bool {
+	if !t1.Time.Equal(t2.Time) || t1.State != t2.State || t1.Description != t2.Description || t1.Payee != t2.Payee {
+		return false
+	}
+	var s1, s2 []*Split
+	for _, s := range t1.Splits {
+		if !s.Account.IsTransferAccount() {
+			s1 = append(s1, s)
+		}
+	}
+	for _, s := range t2.Splits {
+		if !s.Account.IsTransferAccount() {
+			s2 = append(s2, s)
+		}
+	}
+	if len(s1) != len(s2) {
+		return false
+	}
+	for i := range s1 {
+		if s1[i].Account.FullName() != s2[i].Account.FullName() {
+			return false
+		}
+		if s1[i].State != s2[i].State || s1[i].Virtual != s2[i].Virtual || !equalValues(s1[i].Value, s2[i].Value) {
+			return false
+		}
+		if (s1[i].Time == nil) != (s2[i].Time == nil) {
+			return false
+		}
+		if s1[i].Time != nil && !s1[i].Time.Equal(*s2[i].Time) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+)
+
+type testID string
+
+func (id testID) String() string {
+	return string(id)
+}
+
+func diffLedger(desc string, amount int64, extra bool) *Ledger {
+	eur := &Currency{Name: "EUR"}
+	cash := &Account{ID: testID("a1"), Name: "Cash"}
+	food := &Account{ID: testID("a2"), Name: "Food"}
+	when := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := &Ledger{Accounts: []*Account{cash, food}, Currencies: []*Currency{eur}}
+	l.Transactions = append(l.Transactions, &Transaction{
+		ID:          testID("t1"),
+		Time:        when,
+		Description: desc,
+		Splits: []*Split{
+			{Account: cash, Value: Value{Amount: -amount, Currency: eur}},
+			{Account: food, Value: Value{Amount: amount, Currency: eur}},
+		},
+	})
+	if extra {
+		l.Accounts = append(l.Accounts, &Account{ID: testID("a3"), Name: "Bank"})
+		l.Transactions = append(l.Transactions, &Transaction{ID: testID("t2"), Time: when})
+	}
+	return l
+}
+
+func TestDiff(t *testing.T) {
+	a := diffLedger("lunch", 10*U, false)
+	if changes := Diff(a, diffLedger("lunch", 10*U, false)); len(changes) != 0 {
+		t.Errorf("Diff of equal ledgers = %v", changes)
+	}
+
+	b := diffLedger("lunch", 12*U, true)
+	changes := Diff(a, b)
+	expected := []Change{
+		{Kind: Added, New: b.Accounts[2]},
+		{Kind: Modified, Old: a.Transactions[0], New: b.Transactions[0]},
+		{Kind: Added, New: b.Transactions[1]},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Diff = %v (expected %v)", changes, expected)
+	}
+	for i := range changes {
+		if changes[i] != expected[i] {
+			t.Errorf("change %d = %v (expected %v)", i, changes[i], expected[i])
+		}
+	}
+
+	changes = Diff(b, a)
+	if len(changes) != 3 || changes[0].Kind != Removed || changes[2].Kind != Removed {
+		t.Errorf("Diff (reverse) = %v", changes)
+	}
+}
@@ -0,0 +1,171 @@
+package accounting
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Disposal is the sale of some units of a commodity, with the price
+// obtained and the price paid for them.
+type Disposal struct {
+	Split    *Split // Split selling the commodity.
+	Proceeds Value  // Total price obtained in the sale.
+	Basis    Value  // Total price paid for the units sold.
+}
+
+// Gain returns the realized gain (or loss, if negative) in a disposal.
+func (d Disposal) Gain() Value {
+	v := d.Proceeds
+	v.Amount -= d.Basis.Amount
+	return v
+}
+
+// CostMethod is the way to choose which purchases are matched against a sale
+// to compute its cost basis.
+type CostMethod int
+
+// Possible values for CostMethod. The default one is FIFO.
+const (
+	FIFO    CostMethod = iota // First in, first out: the oldest lots are sold first
+	LIFO                      // Last in, first out: the newest lots are sold first
+	Average                   // Every unit costs the running average of the units held
+)
+
+var costMethods = []string{"fifo", "lifo", "average"}
+
+func (m CostMethod) String() string {
+	if m < 0 || int(m) >= len(costMethods) {
+		return fmt.Sprintf("CostMethod(%d)", int(m))
+	}
+	return costMethods[m]
+}
+
+// ParseCostMethod returns the CostMethod with a given name
+// ("fifo", "lifo" or "average").
+func ParseCostMethod(s string) (CostMethod, error) {
+	for i, name := range costMethods {
+		if strings.EqualFold(s, name) {
+			return CostMethod(i), nil
+		}
+	}
+	return FIFO, fmt.Errorf("unknown cost method %q", s)
+}
+
+// lot is an amount of a commodity bought at once, and its total cost.
+type lot struct {
+	amount int64
+	cost   Value
+}
+
+// mulDiv returns a*b/c without overflowing in the intermediate product.
+func mulDiv(a, b, c int64) int64 {
+	i := big.NewInt(a)
+	i.Mul(i, big.NewInt(b))
+	i.Quo(i, big.NewInt(c))
+	return i.Int64()
+}
+
+// sameUnitCost tells whether two lots were bought at the same price per unit.
+func sameUnitCost(a, b lot) bool {
+	if a.cost.Currency != b.cost.Currency {
+		return false
+	}
+	x := big.NewInt(a.cost.Amount)
+	x.Mul(x, big.NewInt(b.amount))
+	y := big.NewInt(b.cost.Amount)
+	y.Mul(y, big.NewInt(a.amount))
+	return x.Cmp(y) == 0
+}
+
+// Disposals returns the sales of every commodity in an account, matching each
+// one against the previous purchases in the same account using a given method.
+//
+// Only splits with a price ("@" or "@@") or a lot price ("{}" or "{{}}") are
+// considered: those with a positive amount are purchases, and those with a
+// negative amount are sales. The cost of a purchase is its lot price, if it
+// has one. A sale with a lot price takes it as its basis, and sells first
+// the lots bought at that same unit price.
+//
+// With Average, all the purchases of a commodity are merged into a single lot,
+// and every sale reduces its basis proportionally.
+func (l *Ledger) Disposals(a *Account, method CostMethod) ([]Disposal, error) {
+	var disposals []Disposal
+	lots := make(map[*Currency][]lot)
+	for _, s := range a.Splits {
+		cost, ok := l.Cost(s)
+		if !ok || s.Value.Amount == 0 {
+			continue
+		}
+		c := s.Value.Currency
+		basis, hasLot := l.LotPrices[s]
+		if hasLot && (basis.Amount < 0) != (s.Value.Amount < 0) {
+			basis.Amount = -basis.Amount
+		}
+		if s.Value.Amount > 0 {
+			if hasLot {
+				cost = basis
+			}
+			if method == Average && len(lots[c]) > 0 {
+				held := &lots[c][0]
+				if held.cost.Currency != cost.Currency {
+					return nil, fmt.Errorf("%s: bought in %s and %s", s.ID, held.cost.Currency.Name, cost.Currency.Name)
+				}
+				held.amount += s.Value.Amount
+				held.cost.Amount += cost.Amount
+				continue
+			}
+			lots[c] = append(lots[c], lot{amount: s.Value.Amount, cost: cost})
+			continue
+		}
+		d := Disposal{
+			Split:    s,
+			Proceeds: cost.Negate(),
+			Basis:    Value{Currency: cost.Currency},
+		}
+		if hasLot {
+			d.Basis.Currency = basis.Currency
+		}
+		remaining := -s.Value.Amount
+		for remaining > 0 {
+			if len(lots[c]) == 0 {
+				return nil, fmt.Errorf("%s: selling %s not previously bought in %s", s.ID, Value{Amount: remaining, Currency: c}, a.FullName())
+			}
+			i := 0
+			if method == LIFO {
+				i = len(lots[c]) - 1
+			}
+			if hasLot {
+				for j, held := range lots[c] {
+					if sameUnitCost(held, lot{amount: s.Value.Amount, cost: basis}) {
+						i = j
+						break
+					}
+				}
+			}
+			matched := &lots[c][i]
+			if matched.cost.Currency != d.Basis.Currency {
+				return nil, fmt.Errorf("%s: bought in %s and sold in %s", s.ID, matched.cost.Currency.Name, d.Basis.Currency.Name)
+			}
+			n := remaining
+			if matched.amount < n {
+				n = matched.amount
+			}
+			cost := mulDiv(matched.cost.Amount, n, matched.amount)
+			if !hasLot {
+				d.Basis.Amount += cost
+			}
+			matched.cost.Amount -= cost
+			matched.amount -= n
+			remaining -= n
+			if matched.amount == 0 {
+				lots[c] = append(lots[c][:i], lots[c][i+1:]...)
+			}
+		}
+		if hasLot {
+			d.Basis.Amount = -basis.Amount
+		}
+		disposals = append(disposals, d)
+	}
+	return disposals, nil
+}
@@ -0,0 +1,102 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+)
+
+// tradesLedger returns an account in which 10 AAPL are bought at $100,
+// 10 AAPL at $110, and then 15 AAPL are sold at $120.
+func tradesLedger() (*Ledger, *Account) {
+	usd := &Currency{Name: "USD"}
+	aapl := &Currency{Name: "AAPL"}
+	broker := &Account{Name: "Broker"}
+	l := &Ledger{SplitPrices: make(map[*Split]Value)}
+	trades := []struct {
+		amount, price int64
+	}{
+		{10, 100}, {10, 110}, {-15, 120},
+	}
+	for i, tr := range trades {
+		when := time.Date(2020, 1, i+1, 12, 0, 0, 0, time.UTC)
+		s := &Split{Account: broker, Time: &when, Value: Value{Amount: tr.amount * U, Currency: aapl}}
+		l.SplitPrices[s] = Value{Amount: tr.amount * tr.price * U, Currency: usd}
+		broker.Splits = append(broker.Splits, s)
+	}
+	return l, broker
+}
+
+func TestDisposals(t *testing.T) {
+	tests := []struct {
+		method      CostMethod
+		basis, gain int64
+	}{
+		{FIFO, 1550, 250},    // 10 at $100 and 5 at $110
+		{LIFO, 1600, 200},    // 10 at $110 and 5 at $100
+		{Average, 1575, 225}, // 15 at $105
+	}
+	for _, test := range tests {
+		l, broker := tradesLedger()
+		disposals, err := l.Disposals(broker, test.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(disposals) != 1 {
+			t.Fatalf("Disposals(%s) = %v (expected 1)", test.method, disposals)
+		}
+		d := disposals[0]
+		if d.Proceeds.Amount != 1800*U || d.Basis.Amount != test.basis*U || d.Gain().Amount != test.gain*U {
+			t.Errorf("Disposal(%s): proceeds %s, basis %s, gain %s (expected 1800, %d, %d)",
+				test.method, d.Proceeds, d.Basis, d.Gain(), test.basis, test.gain)
+		}
+	}
+
+	l, broker := tradesLedger()
+	broker.Splits = broker.Splits[1:]
+	if _, err := l.Disposals(broker, FIFO); err == nil {
+		t.Errorf("Disposals: selling more than bought should fail")
+	}
+}
+
+func TestDisposalsLotPrices(t *testing.T) {
+	l, broker := tradesLedger()
+	usd := l.SplitPrices[broker.Splits[0]].Currency
+	l.LotPrices = make(map[*Split]Value)
+	// the first lot costs $90, whatever its market price:
+	l.LotPrices[broker.Splits[0]] = Value{Amount: 900 * U, Currency: usd}
+	// sell 5 of the second lot, and then 10 more without a lot price:
+	sale := broker.Splits[2]
+	sale.Value.Amount = -5 * U
+	l.SplitPrices[sale] = Value{Amount: -600 * U, Currency: usd}
+	l.LotPrices[sale] = Value{Amount: -550 * U, Currency: usd}
+	when := sale.Time.AddDate(0, 0, 1)
+	s := &Split{Account: broker, Time: &when, Value: Value{Amount: -10 * U, Currency: sale.Value.Currency}}
+	l.SplitPrices[s] = Value{Amount: -1200 * U, Currency: usd}
+	broker.Splits = append(broker.Splits, s)
+
+	disposals, err := l.Disposals(broker, FIFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disposals) != 2 {
+		t.Fatalf("Disposals = %v (expected 2)", disposals)
+	}
+	if d := disposals[0]; d.Basis.Amount != 550*U || d.Gain().Amount != 50*U {
+		t.Errorf("first disposal: basis %s, gain %s (expected 550, 50)", d.Basis, d.Gain())
+	}
+	if d := disposals[1]; d.Basis.Amount != 900*U || d.Gain().Amount != 300*U {
+		t.Errorf("second disposal: basis %s, gain %s (expected 900, 300)", d.Basis, d.Gain())
+	}
+}
+
+func TestParseCostMethod(t *testing.T) {
+	for _, m := range []CostMethod{FIFO, LIFO, Average} {
+		got, err := ParseCostMethod(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseCostMethod(%q) = %v, %v (expected %v)", m.String(), got, err, m)
+		}
+	}
+	if _, err := ParseCostMethod("hifo"); err == nil {
+		t.Errorf("ParseCostMethod(\"hifo\") should fail")
+	}
+}
@@ -0,0 +1,38 @@
+package accounting
+
+import "encoding/json"
+
+// decimalString returns the amount of a value as a decimal number, with "."
+// as the decimal sign, no thousand separators and all its relevant digits.
+func (value Value) decimalString() string {
+	var c Currency
+	if value.Currency != nil {
+		c = *value.Currency
+	}
+	c.Thousand = ""
+	c.Decimal = "."
+	value.Currency = &c
+	return value.GetString(true, false)
+}
+
+// MarshalJSON encodes a value as an object with its amount,
+// as a decimal string, and the name of its currency.
+func (value Value) MarshalJSON() ([]byte, error) {
+	var v struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	v.Amount = value.decimalString()
+	if value.Currency != nil {
+		v.Currency = value.Currency.Name
+	}
+	return json.Marshal(v)
+}
+
+// MarshalJSON encodes a balance as an array of values, sorted by currency.
+func (b Balance) MarshalJSON() ([]byte, error) {
+	if len(b) == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Value(b.Sorted()))
+}
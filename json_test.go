@@ -0,0 +1,30 @@
+package accounting
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	eur := &Currency{Name: "EUR", Thousand: ".", Decimal: ",", Precision: 2}
+	dollar := &Currency{Name: "$", PrintBefore: true, WithoutSpace: true, Decimal: ".", Precision: 2}
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{Value{Amount: 1234567 * U / 100, Currency: eur}, `{"amount":"12345.67","currency":"EUR"}`},
+		{Value{Amount: -U / 1000, Currency: dollar}, `{"amount":"-0.001","currency":"$"}`},
+		{Balance{{Amount: 3 * U, Currency: dollar}}, `[{"amount":"3.00","currency":"$"}]`},
+		{Balance(nil), `[]`},
+	}
+	for _, test := range tests {
+		got, err := json.Marshal(test.in)
+		if err != nil {
+			t.Errorf("json.Marshal(%v): %v", test.in, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("json.Marshal(%v) = %s (expected %s)", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package notify implements accounting.FileNotifier using fsnotify, so that
+// a ledger can be reloaded as soon as its files change:
+//
+//	l, err := accounting.Open("ledger:main.journal", accounting.WithFileNotifier(notify.New))
+//	...
+//	go l.Watch(ctx, func(l *accounting.Ledger) { ... })
+package notify
+
+import (
+	"github.com/cespedes/accounting"
+	"github.com/fsnotify/fsnotify"
+)
+
+var _ accounting.FileNotifier = (*Notifier)(nil)
+
+// Notifier is an accounting.FileNotifier using fsnotify.
+type Notifier struct {
+	watcher *fsnotify.Watcher
+	events  chan string
+	done    chan struct{}
+}
+
+// New returns a Notifier which is not watching any file yet.
+func New() (accounting.FileNotifier, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	n := &Notifier{watcher: w, events: make(chan string), done: make(chan struct{})}
+	go n.run()
+	return n, nil
+}
+
+// run sends the names of the files written, created, removed or renamed,
+// until the Notifier is closed.
+func (n *Notifier) run() {
+	defer close(n.events)
+	for event := range n.watcher.Events {
+		if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+			select {
+			case n.events <- event.Name:
+			case <-n.done:
+				return
+			}
+		}
+	}
+}
+
+// Add starts watching a file or directory.
+func (n *Notifier) Add(name string) error {
+	return n.watcher.Add(name)
+}
+
+// Events returns a channel with the names of the changed files.
+func (n *Notifier) Events() <-chan string {
+	return n.events
+}
+
+// Errors returns a channel with the errors found while watching.
+func (n *Notifier) Errors() <-chan error {
+	return n.watcher.Errors
+}
+
+// Close stops watching all the files, and closes the channels.
+func (n *Notifier) Close() error {
+	close(n.done)
+	return n.watcher.Close()
+}
@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	n, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if err := n.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "main.journal")
+	if err := ioutil.WriteFile(name, []byte("; empty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-n.Events():
+		if got != name {
+			t.Errorf("event for %q (expected %q)", got, name)
+		}
+	case err := <-n.Errors():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event after writing %q", name)
+	}
+}
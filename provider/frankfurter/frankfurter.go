@@ -0,0 +1,72 @@
+// Package frankfurter gets exchange rates published by the European Central Bank
+// using the free Frankfurter API (https://www.frankfurter.app).
+package frankfurter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cespedes/accounting"
+)
+
+var _ accounting.PriceProvider = Provider{}
+
+// DefaultURL is the base URL of the public Frankfurter API.
+const DefaultURL = "https://api.frankfurter.app"
+
+// Provider is an accounting.PriceProvider for currency exchange rates.
+// Its zero value is ready to use.
+type Provider struct {
+	URL    string       // Base URL of the API (DefaultURL if empty)
+	Client *http.Client // HTTP client to use (http.DefaultClient if nil)
+}
+
+// Fetch returns the exchange rate between two currencies, identified by their
+// ISO 4217 names, at a given day (or the latest available, if when is zero).
+func (p Provider) Fetch(from, to *accounting.Currency, when time.Time) (accounting.Value, error) {
+	base := p.URL
+	if base == "" {
+		base = DefaultURL
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	date := "latest"
+	if !when.IsZero() {
+		date = when.Format("2006-01-02")
+	}
+	u := fmt.Sprintf("%s/%s?from=%s&to=%s", base, date, url.QueryEscape(from.Name), url.QueryEscape(to.Name))
+	resp, err := client.Get(u)
+	if err != nil {
+		return accounting.Value{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return accounting.Value{}, fmt.Errorf("frankfurter: %s: %s", u, resp.Status)
+	}
+	var result struct {
+		Rates map[string]json.Number `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return accounting.Value{}, fmt.Errorf("frankfurter: %v", err)
+	}
+	rate, ok := result.Rates[to.Name]
+	if !ok {
+		return accounting.Value{}, fmt.Errorf("frankfurter: no rate from %s to %s", from.Name, to.Name)
+	}
+	r, ok := new(big.Rat).SetString(rate.String())
+	if !ok {
+		return accounting.Value{}, fmt.Errorf("frankfurter: invalid rate %q", rate)
+	}
+	r.Mul(r, big.NewRat(accounting.U, 1))
+	amount := new(big.Int).Quo(r.Num(), r.Denom())
+	if !amount.IsInt64() {
+		return accounting.Value{}, fmt.Errorf("frankfurter: rate %s: %w", rate, accounting.ErrOverflow)
+	}
+	return accounting.Value{Amount: amount.Int64(), Currency: to}, nil
+}
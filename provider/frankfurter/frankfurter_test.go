@@ -0,0 +1,36 @@
+package frankfurter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cespedes/accounting"
+)
+
+func TestFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2021-01-04" || r.URL.Query().Get("from") != "EUR" || r.URL.Query().Get("to") != "USD" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"amount":1.0,"base":"EUR","date":"2021-01-04","rates":{"USD":1.2296}}`)
+	}))
+	defer ts.Close()
+
+	eur := &accounting.Currency{Name: "EUR"}
+	usd := &accounting.Currency{Name: "USD"}
+	p := Provider{URL: ts.URL}
+	v, err := p.Fetch(eur, usd, time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if v.Currency != usd || v.Amount != 122960000 {
+		t.Errorf("Fetch = %d %s (expected 1.2296 USD)", v.Amount, v.Currency.Name)
+	}
+	if _, err := p.Fetch(usd, eur, time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("Fetch(USD, EUR): expected an error")
+	}
+}
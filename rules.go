@@ -0,0 +1,91 @@
+package accounting
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule rewrites the splits which match some conditions.
+// It is used to categorize imported transactions.
+//
+// A nil regular expression matches anything.
+type Rule struct {
+	Description *regexp.Regexp // Matched against the transaction's description
+	Account     *regexp.Regexp // Matched against the full name of the split's account
+	Amount      *regexp.Regexp // Matched against the split's value, as in Value.String()
+
+	SetAccount string // If not empty, full name of the new account for the split
+	AddTags    []Tag  // Tags to add to the split
+}
+
+// Match tells whether a rule applies to a split.
+func (r Rule) Match(s *Split) bool {
+	if r.Description != nil && (s.Transaction == nil || !r.Description.MatchString(s.Transaction.Description)) {
+		return false
+	}
+	if r.Account != nil && (s.Account == nil || !r.Account.MatchString(s.Account.FullName())) {
+		return false
+	}
+	if r.Amount != nil && !r.Amount.MatchString(s.Value.String()) {
+		return false
+	}
+	return true
+}
+
+// Apply runs a list of rules on every split in the ledger, in order,
+// so a rule can match the account set by a previous one.
+//
+// It is meant to be used after parsing the transactions, but before Fill().
+// Accounts which do not exist are created, and tags are added to the
+// split's comments as "name:value".
+func (l *Ledger) Apply(rules []Rule) error {
+	if l.Comments == nil {
+		l.Comments = make(map[interface{}][]string)
+	}
+	for _, t := range l.Transactions {
+		for _, s := range t.Splits {
+			s.Transaction = t
+			for _, r := range rules {
+				if !r.Match(s) {
+					continue
+				}
+				if r.SetAccount != "" {
+					a, err := l.getAccount(r.SetAccount)
+					if err != nil {
+						return err
+					}
+					s.Account = a
+				}
+				for _, tag := range r.AddTags {
+					l.Comments[s] = append(l.Comments[s], tag.Name+":"+tag.Value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getAccount returns the account with a given full name,
+// creating it (and its ancestors) if it does not exist.
+func (l *Ledger) getAccount(name string) (*Account, error) {
+	for _, a := range l.Accounts {
+		if a.FullName() == name {
+			return a, nil
+		}
+	}
+	var parent *Account
+	short := name
+	if i := strings.LastIndexByte(name, ':'); i > -1 {
+		var err error
+		parent, err = l.getAccount(name[:i])
+		if err != nil {
+			return nil, err
+		}
+		short = name[i+1:]
+	}
+	if short == "" {
+		return nil, fmt.Errorf("invalid account name %q", name)
+	}
+	return l.NewAccount(Account{Name: short, Parent: parent})
+}
@@ -0,0 +1,60 @@
+package accounting
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	eur := &Currency{Name: "EUR"}
+	bank := &Account{Name: "Bank"}
+	unknown := &Account{Name: "Unknown"}
+	l := &Ledger{Accounts: []*Account{bank, unknown}}
+	for _, d := range []string{"AMAZON EU 1234", "Rent"} {
+		l.Transactions = append(l.Transactions, &Transaction{
+			Time:        time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			Description: d,
+			Splits: []*Split{
+				{Account: bank, Value: Value{Amount: -10 * U, Currency: eur}},
+				{Account: unknown, Value: Value{Amount: 10 * U, Currency: eur}},
+			},
+		})
+	}
+	rules := []Rule{
+		{
+			Description: regexp.MustCompile("AMAZON"),
+			Account:     regexp.MustCompile("^Unknown$"),
+			SetAccount:  "Expenses:Shopping",
+		},
+		{
+			Account: regexp.MustCompile("^Expenses:"),
+			AddTags: []Tag{{Name: "imported", Value: "yes"}},
+		},
+	}
+	if err := l.Apply(rules); err != nil {
+		t.Fatal(err)
+	}
+	amazon, rent := l.Transactions[0], l.Transactions[1]
+	if got := amazon.Splits[1].Account.FullName(); got != "Expenses:Shopping" {
+		t.Errorf("amazon account = %s (expected Expenses:Shopping)", got)
+	}
+	if got := l.Comments[amazon.Splits[1]]; len(got) != 1 || got[0] != "imported:yes" {
+		t.Errorf("amazon comments = %v (expected [imported:yes])", got)
+	}
+	if amazon.Splits[0].Account != bank || rent.Splits[1].Account != unknown {
+		t.Errorf("unmatched splits were changed")
+	}
+	if len(l.Accounts) != 4 {
+		t.Errorf("len(Accounts) = %d (expected 4)", len(l.Accounts))
+	}
+	if a := amazon.Splits[1].Account; a.ID == nil || a.Level != 1 || len(a.Parent.Children) != 1 || a.Parent.Children[0] != a {
+		t.Errorf("Expenses:Shopping: ID %v, level %d, parent's children %v (expected an ID, level 1, and being its parent's child)", a.ID, a.Level, a.Parent.Children)
+	}
+	if err := l.Fill(); err != nil {
+		t.Fatal(err)
+	}
+	if got := amazon.Splits[1].Account.Level; got != 1 {
+		t.Errorf("Expenses:Shopping level = %d (expected 1)", got)
+	}
+}
@@ -1,6 +1,11 @@
 package accounting
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
 
 // U is the number by which every amount must be multiplied before storing it.
 const U = 100_000_000
@@ -8,23 +13,54 @@ const U = 100_000_000
 // Ledger stores all the accounts and transactions in one accounting.
 type Ledger struct {
 	connection      Connection
+	backendOptions  map[string][]interface{}     // Settings given with WithBackendOption.
+	currencies      map[string]*Currency         // Index of Currencies, by name.
+	newNotifier     func() (FileNotifier, error) // Given with WithFileNotifier.
+	lastID          SeqID                        // Last ID given by newID.
 	Accounts        []*Account
 	Transactions    []*Transaction           // sorted by Time.
+	Periodic        []*PeriodicTransaction   // Templates of transactions repeated every period, for forecasts.
 	Currencies      []*Currency              // can be empty.
 	Prices          []*Price                 // can be empty; sorted by Time.
 	Comments        map[interface{}][]string // Comments in Accounts, Transactions, Currencies or Prices.
+	FileComments    map[interface{}][]string // Top-level comments before an Account, Transaction, Currency or Price (nil: end of file).
 	Assertions      map[*Split]Value         // Value that should be in an account after one split.
 	SplitPrices     map[*Split]Value         // Price for the value in a split, in another currency.
+	LotPrices       map[*Split]Value         // Total cost basis of the value in a split ("{}" or "{{}}"), if given.
+	Elided          map[*Split]bool          // Splits without an amount, calculated by Fill to balance their transaction.
 	DefaultCurrency *Currency                // Default currency.
+
+	RoundingTolerance int64  // Maximum residual (times U) absorbed when balancing a transaction.
+	RoundingAccount   string // Full name of the account for rounding adjustments (largest split if empty).
+	NoAutomaticPrices bool   // Do not add prices from the transactions in Fill.
+	KeepOrder         bool   // Do not sort the transactions in Fill (they must be already sorted).
 	// Tags            map[interface{}][]Tag
 	// TagsByName      map[string][]struct {Value string; Place interface{}}
 }
 
 // ID is used to identify one currency, account, transaction, split or price.
+//
+// IDs are normally assigned by the backend (a file name and line number
+// in the ledger backend, an integer in SQL databases...), and they must
+// be comparable with ==, as Ledger.Account does.
+// The String of an ID must be different for every object of the same kind
+// in a ledger, as Diff uses it to match the objects of two ledgers.
+//
+// Objects without a backend-given ID keep it nil, except those added
+// with NewAccount or NewTransaction, which get a SeqID from the ledger.
 type ID interface {
 	String() string
 }
 
+// SeqID is the ID given by a Ledger to the accounts, transactions and
+// splits added when its backend does not give them one:
+// consecutive numbers starting at 1.
+type SeqID uint64
+
+func (id SeqID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
 // Currency represents a currency or commodity, and stores
 // its name and how to display it with an amount.
 //
@@ -36,7 +72,8 @@ type Currency struct {
 	WithoutSpace bool   // "1.00EUR" vs "1.00 EUR"
 	Thousand     string // What to use (if any) every 3 digits
 	Decimal      string // decimal separator ("." if empty)
-	Precision    int    // Number of decimal places to show
+	Precision    int    // Number of decimal places to show (if negative, the amount is rounded to 10^-Precision units)
+	Suffix       string // With a negative Precision, what to show after the rounded amount (ie, "k")
 	ISIN         string // International Securities Identification Number
 }
 
@@ -51,39 +88,134 @@ type Balance []Value
 
 // Account specifies one origin or destination of funds.
 type Account struct {
-	ID           ID         // used to identify this account.
-	Parent       *Account   // Optional
-	Children     []*Account // Automatically filled.
-	Level        int        // Number of ancestors does this Account have. Automatically filled.
-	Name         string     // Common (short) name (ie, "Cash")
-	Code         string     // Optional. For example, account number
-	Splits       []*Split   // List of movements in this account
-	StartBalance Balance    // Balance at the start of current period (zero if no start date was specified)
+	ID           ID          // used to identify this account.
+	Parent       *Account    // Optional
+	Children     []*Account  // Automatically filled.
+	Level        int         // Number of ancestors does this Account have. Automatically filled.
+	Name         string      // Common (short) name (ie, "Cash")
+	Code         string      // Optional. For example, account number
+	Alias        string      // Optional. Name to show in reports instead of Name
+	Type         AccountType // Optional. Kind of account, used to classify it in reports
+	Splits       []*Split    // List of movements in this account
+	StartBalance Balance     // Balance at the start of current period (opening balance if no start date was specified)
+
+	StartClearedBalance Balance   // Cleared part of StartBalance (nil: all of it)
+	DefaultCurrency     *Currency // Optional. Currency of the amounts written without one
 }
 
+// AccountType is the kind of an account: asset, liability, equity, income or expense.
+type AccountType int
+
+// Possible values for AccountType.
+const (
+	Untyped AccountType = iota // Not specified
+	Asset
+	Liability
+	Equity
+	Income
+	Expense
+)
+
 // TransferAccount is a special account used when a transaction has two or more splits with different times.
 // Ledger.Fill() automatically generates splits with this account.
 var TransferAccount Account = Account{
 	Name: "Assets:Transfer account",
 }
 
+// State is the clearing status of a transaction or split.
+type State int
+
+// Possible values for State.
+const (
+	Uncleared State = iota
+	Pending
+	Cleared
+)
+
+// Virtual tells whether a split is a real movement of money or not.
+type Virtual int
+
+// Possible values for Virtual.
+const (
+	NotVirtual        Virtual = iota
+	BalancedVirtual           // Virtual split balanced together with the real ones
+	UnbalancedVirtual         // Virtual split ignored when balancing the transaction
+)
+
 // Transaction stores an entry in the journal, consisting in a timestamp,
 // a description and two or more money movements from different accounts.
 type Transaction struct {
 	ID          ID        // used to identify this transaction.
 	Time        time.Time // Date and time
+	State       State     // Clearing status
 	Description string    // Short description
+	Payee       string    // Optional. Who was paid, or who paid
 	Splits      []*Split  // List of movements
 }
 
+// PeriodicTransaction is a template for a transaction repeated every period,
+// used to forecast future balances with Ledger.Forecast.
+// Its first occurrence is at Template.Time.
+type PeriodicTransaction struct {
+	Template Transaction // Splits without a Currency are calculated in every occurrence.
+	Months   int         // Length of the period, in months
+	Days     int         // and days.
+	End      time.Time   // Optional. There are no occurrences after it.
+}
+
 // Split is a deposit or withdrawal from an account.
 type Split struct {
 	ID          ID           // used to identify this split.
 	Account     *Account     // Origin or destination of funds.
 	Transaction *Transaction // Transaction this split belongs to.
 	Time        *time.Time   // In most cases, this is equal to Transaction.Time
-	Value       Value        // Amount to be transferred.
+	State       State        // Clearing status (usually the same as the transaction's)
+	Virtual     Virtual      // Whether this is a virtual split
+	Value       Value        // Amount to be transferred (with nil Currency if not given, to be calculated by Fill).
 	Balance     Balance      // Balance of this account, after this movement.
+
+	ClearedBalance Balance // Balance of this account with only its cleared splits, after this movement.
+}
+
+// Allocation is the part of an amount assigned to one account,
+// as used by Ledger.NewAllocatedTransaction.
+type Allocation struct {
+	Account  *Account
+	Fraction float64 // Part of the amount, between 0 and 1.
+}
+
+// ErrOverflow is the error for an amount too big to be stored in a Value:
+// as amounts are kept times U in an int64, their magnitude must be below
+// about 9.2e10.
+var ErrOverflow = errors.New("amount out of range")
+
+// TransactionError is an error found by Fill in one transaction.
+type TransactionError struct {
+	Transaction *Transaction
+	Err         error
+}
+
+func (e *TransactionError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// AssertionError is the error found by Fill when the balance of an account
+// after a split is not the asserted one.  It is wrapped in a TransactionError.
+type AssertionError struct {
+	ID         ID       // ID of the split with the assertion.
+	Account    *Account // Account of the split.
+	Expected   Value    // Asserted balance.
+	Actual     Value    // Balance in the same currency (zero if there is none).
+	Difference Value    // Expected minus Actual.
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("%s: wrong assertion: %s != %s (off by %s)", e.ID, e.Actual, e.Expected, e.Difference)
 }
 
 // Price declares a market price, which is an exchange rate between
@@ -95,6 +227,13 @@ type Price struct {
 	Value    Value
 }
 
+// PriceProvider gets market prices from an external source.
+type PriceProvider interface {
+	// Fetch returns the price of one unit of from, expressed in to,
+	// at a given time.
+	Fetch(from, to *Currency, when time.Time) (Value, error)
+}
+
 // A Tag is a label which can be added to a transaction or movement.
 type Tag struct {
 	Name  string